@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// additionsSettleInterval is how often pending new files are re-checked. A
+// file is announced once its size is unchanged between two checks.
+var additionsSettleInterval = 2 * time.Second
+
+// additionsKeepAlive is how often an idle /additions stream sends a comment
+// so proxies don't close it.
+const additionsKeepAlive = 30 * time.Second
+
+// additionsFeed announces files as they are created in the configured
+// directories, once they have finished being written. Removals and renames
+// away are ignored: it is an append-only feed. It is started by the first
+// subscriber and then runs for the life of the process. With --watch it is
+// fed the index watcher's create events; otherwise it runs its own watcher.
+type additionsFeed struct {
+	mu        sync.Mutex
+	running   bool
+	watcher   *fsnotify.Watcher // its own watcher, nil when fed by the index
+	fromIndex chan string       // paths created under the index's watcher
+	subs      map[chan FileEntry]struct{}
+	pending   map[string]int64 // path -> size at last check, -1 if not yet checked
+}
+
+var additions = newAdditionsFeed()
+
+func newAdditionsFeed() *additionsFeed {
+	return &additionsFeed{
+		fromIndex: make(chan string, 1024),
+		subs:      make(map[chan FileEntry]struct{}),
+		pending:   make(map[string]int64),
+	}
+}
+
+// subscribe starts the feed if needed and returns a channel of new files.
+func (f *additionsFeed) subscribe() (chan FileEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.running {
+		var w *fsnotify.Watcher
+		if !index.watching() {
+			var err error
+			if w, err = fsnotify.NewWatcher(); err != nil {
+				return nil, err
+			}
+			for _, dir := range configuredDirs() {
+				if err := watchTree(w, dir); err != nil {
+					w.Close()
+					return nil, err
+				}
+			}
+		}
+		f.watcher, f.running = w, true
+		go f.run(w)
+	}
+
+	ch := make(chan FileEntry, 64)
+	f.subs[ch] = struct{}{}
+	return ch, nil
+}
+
+func (f *additionsFeed) unsubscribe(ch chan FileEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, ch)
+}
+
+// watchTree adds a watch for dir and every directory beneath it. fsnotify
+// watches are not recursive, so each directory needs its own.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Error accessing path", "path", path, "err", err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && excluded(path) {
+			return fs.SkipDir
+		}
+		if err := w.Add(path); err != nil {
+			return fmt.Errorf("watching %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// setDirs moves a running watcher from the old roots to a reloaded set.
+func (f *additionsFeed) setDirs(old, dirs []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watcher != nil {
+		rewatch(f.watcher, old, dirs)
+	}
+}
+
+// rewatch moves w from the old roots to dirs: added roots are watched in
+// full, and watches no longer under any root are removed.
+func rewatch(w *fsnotify.Watcher, old, dirs []string) {
+	for _, dir := range dirs {
+		if slices.Contains(old, dir) {
+			continue
+		}
+		if err := watchTree(w, dir); err != nil {
+			slog.Warn("Error watching directory", "dir", dir, "err", err)
+		}
+	}
+	for _, path := range w.WatchList() {
+		if !slices.ContainsFunc(dirs, func(dir string) bool { return hasPathPrefix(path, dir) }) {
+			w.Remove(path)
+		}
+	}
+}
+
+// indexCreated hands the feed a path the index's watcher saw created. It
+// never blocks the index: with no feed running on it, or a backlog the feed
+// hasn't caught up with, the path is dropped.
+func (f *additionsFeed) indexCreated(path string) {
+	f.mu.Lock()
+	fed := f.running && f.watcher == nil
+	f.mu.Unlock()
+	if !fed {
+		return
+	}
+	select {
+	case f.fromIndex <- path:
+	default:
+		slog.Warn("Dropping new path for a backed-up /additions feed", "path", path)
+	}
+}
+
+// run handles create events, from w or, if w is nil, from the index, and
+// settles pending files.
+func (f *additionsFeed) run(w *fsnotify.Watcher) {
+	ticker := time.NewTicker(additionsSettleInterval)
+	defer ticker.Stop()
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if w != nil {
+		events, errs = w.Events, w.Errors
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) {
+				f.created(w, event.Name)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				return
+			}
+			slog.Warn("Error watching for additions", "err", err)
+		case path := <-f.fromIndex:
+			f.created(nil, path)
+		case <-ticker.C:
+			f.settle()
+		}
+	}
+}
+
+// created records a new path. New directories are watched by w, unless the
+// index's watcher already has them, and any files already inside them
+// (created before the watch existed) become pending too. The directory is
+// walked before f.mu is taken, so subscribers aren't held up by it.
+func (f *additionsFeed) created(w *fsnotify.Watcher, path string) {
+	if underExcluded(path, configuredRoot(path)) {
+		return
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+
+	var found []string
+	if !info.IsDir() {
+		if scanIncludes(path) {
+			found = append(found, path)
+		}
+	} else {
+		if w != nil {
+			if err := watchTree(w, path); err != nil {
+				slog.Warn("Error watching new directory", "dir", path, "err", err)
+			}
+		}
+		filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if excluded(p) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && scanIncludes(p) {
+				found = append(found, p)
+			}
+			return nil
+		})
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range found {
+		f.pending[p] = -1
+	}
+}
+
+// settle announces pending files whose size has stopped changing and drops
+// ones that have disappeared.
+func (f *additionsFeed) settle() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for path, last := range f.pending {
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(f.pending, path)
+			continue
+		}
+		if info.Size() != last {
+			f.pending[path] = info.Size()
+			continue
+		}
+
+		delete(f.pending, path)
+		entry := FileEntry{Path: reportedPath(path), Name: info.Name(), Size: info.Size()}
+		for ch := range f.subs {
+			select {
+			case ch <- entry:
+			default:
+				slog.Warn("Dropping addition for a slow /additions client", "path", path)
+			}
+		}
+	}
+}
+
+// handleAdditions streams newly created files as Server-Sent Events, one
+// "added" event per file with a FileEntry as its JSON data.
+func handleAdditions(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	ch, err := additions.subscribe()
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("watching directories: %v", err))
+		return
+	}
+	defer additions.unsubscribe(ch)
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(additionsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case entry := <-ch:
+			if !opts.allowed(entry.Path) {
+				continue
+			}
+			data, _ := json.Marshal(entry)
+			fmt.Fprintf(w, "event: added\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-shutdownStarted(r.Context()):
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleAdditions(t *testing.T) {
+	oldInterval := additionsSettleInterval
+	additionsSettleInterval = 20 * time.Millisecond
+	t.Cleanup(func() { additionsSettleInterval = oldInterval })
+
+	t.Run("own watcher", func(t *testing.T) {
+		testAdditions(t, false)
+	})
+	t.Run("fed by the index's watcher", func(t *testing.T) {
+		testAdditions(t, true)
+	})
+}
+
+func testAdditions(t *testing.T, watched bool) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "existing.mkv"), []byte("old"), 0644)
+	config.Dirs = []string{tmpDir}
+
+	saved := additions
+	additions = newAdditionsFeed()
+	t.Cleanup(func() { additions = saved })
+	if watched {
+		index = newFileIndex(config.Dirs)
+		t.Cleanup(func() { index = nil })
+		if err := index.watch(); err != nil {
+			t.Fatal(err)
+		}
+		index.scan()
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(handleAdditions))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %s", ct)
+	}
+
+	os.MkdirAll(filepath.Join(tmpDir, "new-show"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "new-show", "e01.mkv"), []byte("episode"), 0644)
+	os.Remove(filepath.Join(tmpDir, "existing.mkv"))
+
+	scanner := bufio.NewScanner(resp.Body)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			event = strings.TrimPrefix(line, "event: ")
+		}
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var entry FileEntry
+		json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &entry)
+		if event != "added" {
+			t.Errorf("expected added event, got %q", event)
+		}
+		if entry.Name != "e01.mkv" || entry.Size != int64(len("episode")) {
+			t.Errorf("unexpected addition %+v", entry)
+		}
+		if got := additions.watcher != nil; got == watched {
+			t.Errorf("expected own watcher %v, got %v", !watched, got)
+		}
+		return
+	}
+	t.Fatalf("stream ended without an addition: %v", scanner.Err())
+}
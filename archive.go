@@ -0,0 +1,39 @@
+package main
+
+import (
+	"archive/zip"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// isZipArchive reports whether name looks like a zip file.
+func isZipArchive(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+// walkArchive calls fn for every file stored in the zip archive at path.
+// Members are given synthetic paths beneath the archive, e.g.
+// archive.zip/inner/file.txt. Members with unsafe names (absolute paths or
+// ".." components) are skipped.
+func walkArchive(path string, fn walkFunc) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		info := f.FileInfo()
+		if info.IsDir() || !fs.ValidPath(f.Name) {
+			continue
+		}
+
+		inner := filepath.Join(path, filepath.FromSlash(f.Name))
+		if err := fn(inner, fs.FileInfoToDirEntry(info)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
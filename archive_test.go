@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, path string, members map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, body := range members {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleListExpandArchives(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("test"), 0644)
+	writeTestZip(t, filepath.Join(tmpDir, "extras.zip"), map[string]string{
+		"inner/behind.the.scenes.mkv": "scenes",
+		"readme.txt":                  "hello",
+		"../escape.txt":               "nope",
+	})
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		expand    bool
+		wantCount int
+	}{
+		{false, 2},
+		{true, 4},
+	}
+
+	for _, tt := range tests {
+		config.ExpandArchives = tt.expand
+		t.Cleanup(func() { config.ExpandArchives = false })
+
+		req := httptest.NewRequest(http.MethodGet, "/list", nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+
+		if len(resp.Files) != tt.wantCount {
+			t.Errorf("expand=%v: expected %d files, got %d", tt.expand, tt.wantCount, len(resp.Files))
+		}
+
+		if !tt.expand {
+			continue
+		}
+
+		want := filepath.Join(tmpDir, "extras.zip", "inner", "behind.the.scenes.mkv")
+		found := false
+		for _, f := range resp.Files {
+			if f.Path == want {
+				found = true
+				if f.Name != "behind.the.scenes.mkv" || f.Size != int64(len("scenes")) {
+					t.Errorf("unexpected archive entry %+v", f)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected archive member %s in listing", want)
+		}
+	}
+}
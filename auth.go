@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// withAuth refuses requests without one of the configured credentials: the
+// --auth-token, sent as "Authorization: Bearer <token>" or X-API-Key, or the
+// --basic-auth user and password. With neither flag set every request passes,
+// and with --auth-exempt-health so does /health, for load balancer probes.
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorized(r) || config.AuthExemptHealth && r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if config.BasicAuth != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="filesystem-lister", charset="UTF-8"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeError(w, http.StatusUnauthorized, "authentication required")
+	})
+}
+
+// authorized reports whether r carries a configured credential, or none is
+// configured.
+func authorized(r *http.Request) bool {
+	if config.AuthToken == "" && config.BasicAuth == "" {
+		return true
+	}
+	if config.AuthToken != "" {
+		token := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+			token = auth[len("Bearer "):]
+		}
+		if token != "" && secretEqual(token, config.AuthToken) {
+			return true
+		}
+	}
+	if config.BasicAuth != "" {
+		// Neither side's user name can contain a colon, so comparing the
+		// joined pair is the same as comparing both parts.
+		if user, pass, ok := r.BasicAuth(); ok && secretEqual(user+":"+pass, config.BasicAuth) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretEqual compares a and b in time independent of their contents and
+// lengths, by comparing their hashes.
+func secretEqual(a, b string) bool {
+	ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithAuth(t *testing.T) {
+	t.Cleanup(func() { config.AuthToken, config.BasicAuth, config.AuthExemptHealth = "", "", false })
+	handler := withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name       string
+		token      string
+		basic      string
+		exempt     bool
+		path       string
+		header     []string
+		user, pass string
+		want       int
+	}{
+		{"no auth configured", "", "", false, "/list", nil, "", "", http.StatusTeapot},
+		{"missing token", "s3cret", "", false, "/list", nil, "", "", http.StatusUnauthorized},
+		{"bearer token", "s3cret", "", false, "/list", []string{"Authorization", "Bearer s3cret"}, "", "", http.StatusTeapot},
+		{"lower-case scheme", "s3cret", "", false, "/list", []string{"Authorization", "bearer s3cret"}, "", "", http.StatusTeapot},
+		{"X-API-Key", "s3cret", "", false, "/list", []string{"X-API-Key", "s3cret"}, "", "", http.StatusTeapot},
+		{"wrong token", "s3cret", "", false, "/list", []string{"Authorization", "Bearer s3cre"}, "", "", http.StatusUnauthorized},
+		{"basic auth", "", "alice:pa:ss", false, "/list", nil, "alice", "pa:ss", http.StatusTeapot},
+		{"wrong password", "", "alice:pa:ss", false, "/list", nil, "alice", "pa", http.StatusUnauthorized},
+		{"token as basic password", "s3cret", "", false, "/list", nil, "alice", "s3cret", http.StatusUnauthorized},
+		{"either credential", "s3cret", "alice:pw", false, "/list", []string{"X-API-Key", "s3cret"}, "", "", http.StatusTeapot},
+		{"health not exempt", "s3cret", "", false, "/health", nil, "", "", http.StatusUnauthorized},
+		{"health exempt", "s3cret", "", true, "/health", nil, "", "", http.StatusTeapot},
+		{"exemption is health only", "s3cret", "", true, "/list", nil, "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		config.AuthToken, config.BasicAuth, config.AuthExemptHealth = tt.token, tt.basic, tt.exempt
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		for i := 0; i+1 < len(tt.header); i += 2 {
+			req.Header.Set(tt.header[i], tt.header[i+1])
+		}
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate challenge", tt.name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultBloomFPR = 0.01
+	// maxBloomBits caps the filter at 8MB.
+	maxBloomBits = 64 << 20
+)
+
+// bloomFilter is a fixed-size bloom filter over lowercased file names. Bit i
+// is stored in bits[i/8] under mask 1<<(i%8). The k bit positions for a name
+// come from double hashing its 64-bit FNV-1a hash h:
+// (uint32(h) + i*uint32(h>>32)) mod m, for i in [0, k).
+type bloomFilter struct {
+	bits []byte
+	m    uint64
+	k    uint64
+}
+
+func newBloomFilter(m, k uint64) *bloomFilter {
+	return &bloomFilter{bits: make([]byte, (m+7)/8), m: m, k: k}
+}
+
+// bloomParams sizes a filter for n items at false-positive rate p.
+func bloomParams(n int, p float64) (m, k uint64) {
+	n = max(n, 1)
+	bits := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	m = uint64(min(max(bits, 8), maxBloomBits))
+	k = uint64(max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return m, k
+}
+
+func (b *bloomFilter) positions(name string) func(yield func(uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(name)))
+	sum := h.Sum64()
+	h1, h2 := uint64(uint32(sum)), uint64(uint32(sum>>32))
+
+	return func(yield func(uint64) bool) {
+		for i := range b.k {
+			if !yield((h1 + i*h2) % b.m) {
+				return
+			}
+		}
+	}
+}
+
+func (b *bloomFilter) add(name string) {
+	for pos := range b.positions(name) {
+		b.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+func (b *bloomFilter) mayContain(name string) bool {
+	for pos := range b.positions(name) {
+		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type BloomResponse struct {
+	Host   string  `json:"host"`
+	Count  int     `json:"count"`
+	Bits   uint64  `json:"bits"`
+	Hashes uint64  `json:"hashes"`
+	FPR    float64 `json:"fpr"` // expected false-positive rate for Count names
+	Hash   string  `json:"hash"`
+	Filter []byte  `json:"filter"` // base64 in JSON
+}
+
+// handleBloom returns a bloom filter of every file name, so an aggregator can
+// cheaply ask "does this host probably have X" locally. A hit may be a false
+// positive (at roughly the reported fpr); a miss is definite. The size comes
+// from ?bits=, or is derived from ?fpr= (default 0.01) and the file count;
+// smaller filters are cheaper to ship but give more false positives.
+func handleBloom(w http.ResponseWriter, r *http.Request) {
+	var bits uint64
+	if v := r.URL.Query().Get("bits"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n < 8 || n > maxBloomBits {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'bits' parameter: %q (want 8-%d)", v, maxBloomBits))
+			return
+		}
+		bits = n
+	}
+
+	fpr := defaultBloomFPR
+	if v := r.URL.Query().Get("fpr"); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p <= 0 || p >= 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'fpr' parameter: %q (want 0 < fpr < 1)", v))
+			return
+		}
+		fpr = p
+	}
+
+	var names []string
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		names = append(names, d.Name())
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	m, k := bloomParams(len(names), fpr)
+	if bits != 0 {
+		m = bits
+		k = uint64(max(1, math.Round(float64(m)/float64(max(len(names), 1))*math.Ln2)))
+	}
+
+	filter := newBloomFilter(m, k)
+	for _, name := range names {
+		filter.add(name)
+	}
+
+	expected := math.Pow(1-math.Exp(-float64(k)*float64(len(names))/float64(m)), float64(k))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BloomResponse{
+		Host:   config.FriendlyName,
+		Count:  len(names),
+		Bits:   m,
+		Hashes: k,
+		FPR:    expected,
+		Hash:   "fnv1a64-lowercase-double",
+		Filter: filter.bits,
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	m, k := bloomParams(1000, 0.01)
+	filter := newBloomFilter(m, k)
+	for i := range 1000 {
+		filter.add(fmt.Sprintf("Movie.%d.mkv", i))
+	}
+
+	for i := range 1000 {
+		if !filter.mayContain(fmt.Sprintf("movie.%d.MKV", i)) {
+			t.Fatalf("expected filter to contain movie %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := range 10000 {
+		if filter.mayContain(fmt.Sprintf("Other.%d.avi", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Errorf("false positive rate %.3f well above target 0.01", rate)
+	}
+}
+
+func TestHandleBloom(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "Edge.of.Darkness.2010.1080p.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Other.Movie.720p.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/bloom?bits=1024", nil)
+	w := httptest.NewRecorder()
+	handleBloom(w, req)
+
+	var resp BloomResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Count != 2 || resp.Bits != 1024 || len(resp.Filter) != 128 {
+		t.Fatalf("unexpected bloom response %+v", resp)
+	}
+
+	// Rebuild the filter client-side from the response, as an aggregator would.
+	filter := &bloomFilter{bits: resp.Filter, m: resp.Bits, k: resp.Hashes}
+	if !filter.mayContain("Edge.of.Darkness.2010.1080p.mkv") {
+		t.Error("expected filter to contain Edge.of.Darkness")
+	}
+
+	for _, q := range []string{"bits=4", "fpr=0", "fpr=2"} {
+		req := httptest.NewRequest(http.MethodGet, "/bloom?"+q, nil)
+		w := httptest.NewRecorder()
+		handleBloom(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "errors"
+
+// errReadBudgetExceeded is returned when reading more file content would take
+// a request past --max-read-bytes-per-request.
+var errReadBudgetExceeded = errors.New("read budget exceeded")
+
+// readBudget caps the bytes of file content a single request may read, so
+// content-reading options (previews, verification) can't be used to read
+// terabytes in one go. Listing metadata is unaffected. A nil budget, or one
+// with a zero limit, is unlimited.
+type readBudget struct {
+	limit     int64
+	used      int64
+	exhausted bool
+}
+
+func newReadBudget() *readBudget {
+	return &readBudget{limit: config.MaxReadBytes}
+}
+
+// reserve claims n bytes, reporting false (and marking the budget exhausted)
+// if that would exceed the limit. Once exhausted, every reservation fails.
+func (b *readBudget) reserve(n int64) bool {
+	if b == nil || b.limit <= 0 {
+		return true
+	}
+	if b.exhausted || b.used+n > b.limit {
+		b.exhausted = true
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// refund returns bytes that were reserved but not read.
+func (b *readBudget) refund(n int64) {
+	if b == nil || b.limit <= 0 {
+		return
+	}
+	b.used -= n
+}
+
+// Exhausted reports whether any read was refused.
+func (b *readBudget) Exhausted() bool {
+	return b != nil && b.exhausted
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeEvent is a file appearing in, disappearing from or changing in the
+// index. Removed files carry only their path. Seq numbers events from 1 in
+// the order they were seen, and restarts with the process; Time is when.
+type ChangeEvent struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // added, removed or modified
+	Path    string    `json:"path"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitzero"`
+}
+
+// changeFeed numbers index changes, keeps the last size of them in a
+// journal for /changes and reconnecting /events clients, and fans them out
+// to live subscribers such as /ws. Events are only produced while an index
+// is running.
+type changeFeed struct {
+	mu      sync.Mutex
+	size    int
+	seq     uint64
+	journal []ChangeEvent
+	from    time.Time // the journal holds every change since then
+	subs    map[chan ChangeEvent]struct{}
+}
+
+// changes is replaced in main once --change-journal is known.
+var changes = newChangeFeed(10000)
+
+func newChangeFeed(size int) *changeFeed {
+	return &changeFeed{size: size, from: time.Now(), subs: make(map[chan ChangeEvent]struct{})}
+}
+
+func (f *changeFeed) subscribe() chan ChangeEvent {
+	ch, _, _ := f.subscribeSince(0)
+	return ch
+}
+
+// subscribeSince subscribes and returns the journalled events after seq,
+// so nothing is lost or repeated between the two. ok is false when events
+// after seq have already left the journal, or seq is from an earlier run,
+// and the client must start over.
+func (f *changeFeed) subscribeSince(seq uint64) (ch chan ChangeEvent, missed []ChangeEvent, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch = make(chan ChangeEvent, 256)
+	f.subs[ch] = struct{}{}
+	missed, ok = f.since(seq)
+	return ch, missed, ok
+}
+
+// since returns the journalled events after seq; f.mu must be held.
+func (f *changeFeed) since(seq uint64) ([]ChangeEvent, bool) {
+	if seq > f.seq {
+		return nil, false
+	}
+	if seq == f.seq {
+		return nil, true
+	}
+	if len(f.journal) == 0 || f.journal[0].Seq > seq+1 {
+		return nil, false
+	}
+	i := int(seq + 1 - f.journal[0].Seq)
+	return slices.Clone(f.journal[i:]), true
+}
+
+// after returns the journalled events recorded after t, or false if t is
+// before the journal's coverage: older than its oldest event or the start
+// of the process. f.mu must be held.
+func (f *changeFeed) after(t time.Time) ([]ChangeEvent, bool) {
+	if t.Before(f.from) {
+		return nil, false
+	}
+	i, _ := slices.BinarySearchFunc(f.journal, t, func(ev ChangeEvent, t time.Time) int {
+		if ev.Time.After(t) {
+			return 1
+		}
+		return -1
+	})
+	return slices.Clone(f.journal[i:]), true
+}
+
+func (f *changeFeed) unsubscribe(ch chan ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, ch)
+}
+
+func (f *changeFeed) subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs)
+}
+
+// publish numbers events, journals them and hands them to every
+// subscriber, dropping them for any that aren't keeping up rather than
+// stalling the index.
+func (f *changeFeed) publish(events []ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for i := range events {
+		f.seq++
+		events[i].Seq, events[i].Time = f.seq, now
+	}
+	f.journal = append(f.journal, events...)
+	if over := len(f.journal) - f.size; over > 0 {
+		f.from = f.journal[over-1].Time
+		f.journal = slices.Delete(f.journal, 0, over)
+	}
+	for ch := range f.subs {
+		for _, ev := range events {
+			select {
+			case ch <- ev:
+			default:
+				slog.Warn("Dropping change event for a slow client", "path", ev.Path)
+			}
+		}
+	}
+}
+
+// diffFiles returns the changes that turn old into cur: paths only in cur
+// are added, paths only in old removed, and paths in both whose size or
+// modification time differ modified.
+func diffFiles(old, cur []indexedFile) []ChangeEvent {
+	before := make(map[string]indexedFile, len(old))
+	for _, f := range old {
+		before[f.path] = f
+	}
+
+	var events []ChangeEvent
+	for _, f := range cur {
+		info, err := f.d.Info()
+		if err != nil {
+			continue
+		}
+		ev := ChangeEvent{Type: "added", Path: reportedPath(f.path), Size: info.Size(), ModTime: info.ModTime()}
+		if prev, ok := before[f.path]; ok {
+			delete(before, f.path)
+			if prevInfo, err := prev.d.Info(); err == nil && prevInfo.Size() == info.Size() && prevInfo.ModTime().Equal(info.ModTime()) {
+				continue
+			}
+			ev.Type = "modified"
+		}
+		events = append(events, ev)
+	}
+
+	removed := make([]ChangeEvent, 0, len(before))
+	for path := range before {
+		removed = append(removed, ChangeEvent{Type: "removed", Path: reportedPath(path)})
+	}
+	slices.SortFunc(removed, func(a, b ChangeEvent) int { return strings.Compare(a.Path, b.Path) })
+	return append(events, removed...)
+}
+
+// ChangesResponse is the /changes result. Seq is the latest event's, to
+// pass as ?since= next time. Reset means the changes asked for are no longer
+// journalled (or predate a restart) and a full listing is needed.
+type ChangesResponse struct {
+	Host    string        `json:"host"`
+	Seq     uint64        `json:"seq"`
+	Reset   bool          `json:"reset,omitempty"`
+	Changes []ChangeEvent `json:"changes"`
+}
+
+// handleChanges returns the journalled changes after ?since=, either a seq
+// from an earlier response or an RFC 3339 time. Without since it returns
+// every change since the process started, if the journal still holds them.
+func handleChanges(w http.ResponseWriter, r *http.Request) {
+	if index == nil {
+		writeError(w, http.StatusServiceUnavailable, "change notifications need an index (--scan-interval or --watch)")
+		return
+	}
+
+	since := r.URL.Query().Get("since")
+	seq, seqErr := strconv.ParseUint(cmp.Or(since, "0"), 10, 64)
+	t, timeErr := time.Parse(time.RFC3339Nano, since)
+	if seqErr != nil && timeErr != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'since' parameter: %q (want a seq or an RFC 3339 time)", since))
+		return
+	}
+
+	resp := ChangesResponse{Host: config.FriendlyName}
+	var ok bool
+	changes.mu.Lock()
+	if seqErr == nil {
+		resp.Changes, ok = changes.since(seq)
+	} else {
+		resp.Changes, ok = changes.after(t)
+	}
+	resp.Seq = changes.seq
+	changes.mu.Unlock()
+	resp.Reset = !ok
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	resp.Changes = slices.DeleteFunc(resp.Changes, func(ev ChangeEvent) bool { return !opts.allowed(ev.Path) })
+	if resp.Changes == nil {
+		resp.Changes = []ChangeEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
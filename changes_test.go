@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIndexChangeEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	kept, gone := filepath.Join(tmpDir, "kept.mkv"), filepath.Join(tmpDir, "gone.mkv")
+	os.WriteFile(kept, []byte("old"), 0644)
+	os.WriteFile(gone, []byte("gone"), 0644)
+
+	config.Dirs = []string{tmpDir}
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+	index.scan()
+
+	ch := changes.subscribe()
+	defer changes.unsubscribe(ch)
+	next := func() ChangeEvent {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a change event")
+			return ChangeEvent{}
+		}
+	}
+
+	// A watch update announces what changed beneath the dirty paths.
+	added := filepath.Join(tmpDir, "new.mkv")
+	os.WriteFile(added, []byte("new!!"), 0644)
+	index.update(map[string]bool{added: true})
+	if ev := next(); ev.Type != "added" || ev.Path != added || ev.Size != 5 || ev.ModTime.IsZero() {
+		t.Errorf("expected new.mkv added with size and mtime, got %+v", ev)
+	}
+	index.update(map[string]bool{added: true})
+	select {
+	case ev := <-ch:
+		t.Errorf("expected nothing for an unchanged file, got %+v", ev)
+	default:
+	}
+
+	// A full rescan catches what no watcher reported.
+	os.WriteFile(kept, []byte("newer"), 0644)
+	os.Remove(gone)
+	index.scan()
+	got := map[string]ChangeEvent{}
+	for range 2 {
+		ev := next()
+		got[ev.Type] = ev
+	}
+	if ev := got["modified"]; ev.Path != kept || ev.Size != 5 {
+		t.Errorf("expected kept.mkv modified to 5 bytes, got %+v", got)
+	}
+	if ev := got["removed"]; ev.Path != gone || ev.Size != 0 || !ev.ModTime.IsZero() {
+		t.Errorf("expected gone.mkv removed with only a path, got %+v", got)
+	}
+}
+
+func TestHandleChanges(t *testing.T) {
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{t.TempDir()}
+	oldChanges := changes
+	changes = newChangeFeed(3)
+	t.Cleanup(func() { changes = oldChanges })
+
+	get := func(query string) ChangesResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleChanges(w, httptest.NewRequest(http.MethodGet, "/changes"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status 200, got %d", query, w.Code)
+		}
+		var resp ChangesResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+	seqs := func(resp ChangesResponse) []uint64 {
+		var s []uint64
+		for _, ev := range resp.Changes {
+			s = append(s, ev.Seq)
+		}
+		return s
+	}
+
+	w := httptest.NewRecorder()
+	handleChanges(w, httptest.NewRequest(http.MethodGet, "/changes", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 without an index, got %d", w.Code)
+	}
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+
+	if resp := get(""); resp.Seq != 0 || resp.Reset || resp.Changes == nil || len(resp.Changes) != 0 {
+		t.Errorf("expected an empty journal at seq 0, got %+v", resp)
+	}
+
+	changes.publish([]ChangeEvent{{Type: "added", Path: "/media/a.mkv"}, {Type: "added", Path: "/media/b.mkv"}})
+	time.Sleep(10 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	changes.publish([]ChangeEvent{{Type: "removed", Path: "/media/a.mkv"}})
+
+	if resp := get("?since=1"); !slices.Equal(seqs(resp), []uint64{2, 3}) || resp.Seq != 3 || resp.Reset {
+		t.Errorf("expected seqs 2 and 3 after 1, got %+v", resp)
+	}
+	if resp := get("?since=3"); len(resp.Changes) != 0 || resp.Seq != 3 || resp.Reset {
+		t.Errorf("expected nothing after the latest seq, got %+v", resp)
+	}
+	if resp := get("?since=" + between.Format(time.RFC3339Nano)); !slices.Equal(seqs(resp), []uint64{3}) || resp.Changes[0].Path != "/media/a.mkv" {
+		t.Errorf("expected only the removal after the timestamp, got %+v", resp)
+	}
+	if resp := get("?since=2000-01-01T00:00:00Z"); !resp.Reset {
+		t.Errorf("expected a reset for a time before the process started, got %+v", resp)
+	}
+	if resp := get("?since=7"); !resp.Reset || resp.Seq != 3 {
+		t.Errorf("expected a reset for a seq from another run, got %+v", resp)
+	}
+
+	// The journal keeps the newest 3 events.
+	changes.publish([]ChangeEvent{{Type: "added", Path: "/media/c.mkv"}})
+	if resp := get(""); !resp.Reset {
+		t.Errorf("expected a reset once seq 1 has left the journal, got %+v", resp)
+	}
+	if resp := get("?since=1"); !slices.Equal(seqs(resp), []uint64{2, 3, 4}) || resp.Reset {
+		t.Errorf("expected seqs 2-4 after 1, got %+v", resp)
+	}
+	if resp := get("?since=" + between.Format(time.RFC3339Nano)); resp.Reset || !slices.Equal(seqs(resp), []uint64{3, 4}) {
+		t.Errorf("expected seqs 3 and 4 after the timestamp, got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	handleChanges(w, httptest.NewRequest(http.MethodGet, "/changes?since=yesterday", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for since=yesterday, got %d", w.Code)
+	}
+
+	// X-Allowed-Prefixes hides other paths' events.
+	req := httptest.NewRequest(http.MethodGet, "/changes?since=1", nil)
+	req.Header.Set("X-Allowed-Prefixes", "/media/c.mkv")
+	w = httptest.NewRecorder()
+	handleChanges(w, req)
+	var resp ChangesResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !slices.Equal(seqs(resp), []uint64{4}) {
+		t.Errorf("expected only c.mkv's event under X-Allowed-Prefixes, got %+v", resp)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix starts the environment variable for each flag: --max-depth is
+// FSLISTER_MAX_DEPTH.
+const envPrefix = "FSLISTER_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// configValue is what a flag takes from the environment or config file.
+type configValue struct {
+	values []string // one per Set call; several for repeatable flags
+	source string   // the variable or "config file", for error messages
+}
+
+// applyConfigSources fills in every flag of fs that wasn't given on the
+// command line, first from its FSLISTER_* environment variable and failing
+// that from the TOML file named by --config (or FSLISTER_CONFIG). File keys
+// are flag names, e.g. port = 8080 or exclude = [".git", "@eaDir"];
+// repeatable flags take arrays, or os.PathListSeparator-separated values in
+// the environment (see splitEnvList); other flags take the variable whole.
+// Each flag takes its value from a single source, so
+// command-line flags override the environment, which overrides the file.
+func applyConfigSources(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	values, err := configValues(fs, lookupEnv)
+	if err != nil {
+		return err
+	}
+	for name, v := range values {
+		for _, value := range v.values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for %s from %s: %v", value, name, v.source, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues reads the environment and config file and returns the values
+// applyConfigSources would set, keyed by flag name. Flags given on the
+// command line are left out.
+func configValues(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) (map[string]configValue, error) {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	values := make(map[string]configValue)
+	if path := configPath(fs, onCommandLine, lookupEnv); path != "" {
+		var raw map[string]any
+		if _, err := toml.DecodeFile(path, &raw); err != nil {
+			return nil, err
+		}
+		for key, value := range raw {
+			if key == "config" || fs.Lookup(key) == nil {
+				return nil, fmt.Errorf("%s: unknown option %q", path, key)
+			}
+			v := configValue{source: "config file"}
+			if list, ok := value.([]any); ok {
+				for _, item := range list {
+					v.values = append(v.values, fmt.Sprint(item))
+				}
+			} else {
+				v.values = []string{fmt.Sprint(value)}
+			}
+			values[key] = v
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if env, ok := lookupEnv(envName(f.Name)); ok && f.Name != "config" {
+			list := []string{env}
+			if _, repeatable := f.Value.(*stringsFlag); repeatable {
+				list = splitEnvList(env)
+			}
+			values[f.Name] = configValue{list, envName(f.Name)}
+		}
+	})
+	for name := range onCommandLine {
+		delete(values, name)
+	}
+	return values, nil
+}
+
+// splitEnvList splits a repeatable flag's environment value on
+// os.PathListSeparator. On Unix that is ':', which URLs contain too, so a
+// piece starting with "//" is joined back onto its scheme and a port number
+// onto its host: FSLISTER_PEER=http://a:8080:http://b is two peers.
+func splitEnvList(env string) []string {
+	sep := string(os.PathListSeparator)
+	var list []string
+	for _, piece := range strings.Split(env, sep) {
+		if n := len(list); n > 0 && (strings.HasPrefix(piece, "//") || isPort(list[n-1], piece)) {
+			list[n-1] += sep + piece
+			continue
+		}
+		list = append(list, piece)
+	}
+	return list
+}
+
+// isPort reports whether piece is the port (and perhaps path) of the URL
+// prev, which doesn't have one yet: prev is scheme://host.
+func isPort(prev, piece string) bool {
+	_, host, ok := strings.Cut(prev, "://")
+	if !ok || strings.ContainsAny(host, ":/") {
+		return false
+	}
+	port, _, _ := strings.Cut(piece, "/")
+	if port == "" {
+		return false
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func configPath(fs *flag.FlagSet, onCommandLine map[string]bool, lookupEnv func(string) (string, bool)) string {
+	if f := fs.Lookup("config"); f != nil && onCommandLine["config"] {
+		return f.Value.String()
+	}
+	path, _ := lookupEnv(envName("config"))
+	return path
+}
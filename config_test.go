@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigSources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lister.toml")
+	os.WriteFile(path, []byte(`
+port = 9000
+friendlyname = "from-file"
+dir = ["/mnt/a,workers=4", "/mnt/b"]
+exclude = [".git"]
+scan-interval = "5m"
+`), 0644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("port", 8080, "")
+	name := fs.String("friendlyname", "", "")
+	interval := fs.Duration("scan-interval", 0, "")
+	var dirs, excludes stringsFlag
+	fs.Var(&dirs, "dir", "")
+	fs.Var(&excludes, "exclude", "")
+	fs.String("config", "", "")
+
+	if err := fs.Parse([]string{"--config", path, "--friendlyname", "from-cli"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"FSLISTER_PORT": "9100"}
+	lookupEnv := func(key string) (string, bool) { v, ok := env[key]; return v, ok }
+
+	if err := applyConfigSources(fs, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	if *port != 9100 {
+		t.Errorf("expected the environment to override the file port, got %d", *port)
+	}
+	if *name != "from-cli" {
+		t.Errorf("expected the command line to win for friendlyname, got %q", *name)
+	}
+	if *interval != 5*time.Minute {
+		t.Errorf("expected scan-interval 5m from the file, got %s", *interval)
+	}
+	if !reflect.DeepEqual([]string(dirs), []string{"/mnt/a,workers=4", "/mnt/b"}) || !reflect.DeepEqual([]string(excludes), []string{".git"}) {
+		t.Errorf("expected repeatable flags from file arrays, got dirs %v excludes %v", dirs, excludes)
+	}
+}
+
+func TestApplyConfigSourcesEnvironmentLists(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	auth := fs.String("basic-auth", "", "")
+	var peers, exts stringsFlag
+	fs.Var(&peers, "peer", "")
+	fs.Var(&exts, "ext", "")
+	fs.String("config", "", "")
+	fs.Parse(nil)
+
+	sep := string(os.PathListSeparator)
+	env := map[string]string{
+		"FSLISTER_BASIC_AUTH": "user:pass",
+		"FSLISTER_PEER":       "http://nas2:8080" + sep + "https://nas3/lister" + sep + "http://nas4",
+		"FSLISTER_EXT":        "mkv" + sep + "mp4",
+	}
+	lookupEnv := func(key string) (string, bool) { v, ok := env[key]; return v, ok }
+	if err := applyConfigSources(fs, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	if *auth != "user:pass" {
+		t.Errorf("expected basic-auth to be kept whole, got %q", *auth)
+	}
+	if want := []string{"http://nas2:8080", "https://nas3/lister", "http://nas4"}; !reflect.DeepEqual([]string(peers), want) {
+		t.Errorf("expected peers %v, got %v", want, peers)
+	}
+	if want := []string{"mkv", "mp4"}; !reflect.DeepEqual([]string(exts), want) {
+		t.Errorf("expected exts %v, got %v", want, exts)
+	}
+}
+
+func TestApplyConfigSourcesRejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lister.toml")
+	os.WriteFile(path, []byte(`prot = 9000`), 0644)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 8080, "")
+	fs.String("config", "", "")
+	fs.Parse([]string{"--config", path})
+
+	noEnv := func(string) (string, bool) { return "", false }
+	if err := applyConfigSources(fs, noEnv); err == nil {
+		t.Error("expected an error for the unknown key prot")
+	}
+}
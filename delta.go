@@ -0,0 +1,70 @@
+package main
+
+import "sync"
+
+// deltaHistorySize is how many recent /list scans are kept for ?delta-from=.
+const deltaHistorySize = 4
+
+// scanHistory remembers the reported paths of recent /list scans, keyed by
+// version, so a client holding one of those versions can be sent only what
+// changed. The oldest scan is dropped once limit is reached.
+type scanHistory struct {
+	mu    sync.Mutex
+	limit int
+	order []string
+	paths map[string][]string
+}
+
+var listHistory = newScanHistory(deltaHistorySize)
+
+func newScanHistory(limit int) *scanHistory {
+	return &scanHistory{limit: limit, paths: make(map[string][]string)}
+}
+
+func (h *scanHistory) add(version string, paths []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.paths[version]; ok {
+		return
+	}
+	if len(h.order) == h.limit {
+		delete(h.paths, h.order[0])
+		h.order = h.order[1:]
+	}
+	h.order = append(h.order, version)
+	h.paths[version] = paths
+}
+
+func (h *scanHistory) get(version string) ([]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	paths, ok := h.paths[version]
+	return paths, ok
+}
+
+// diffEntries compares the current entries against the paths of an earlier
+// scan, returning the entries that are new and the paths that have gone.
+func diffEntries(previous []string, current []FileEntry) (added []FileEntry, removed []string) {
+	seen := make(map[string]bool, len(previous))
+	for _, p := range previous {
+		seen[p] = true
+	}
+
+	for _, f := range current {
+		if seen[f.Path] {
+			delete(seen, f.Path)
+			continue
+		}
+		added = append(added, f)
+	}
+
+	for _, p := range previous {
+		if seen[p] {
+			removed = append(removed, p)
+		}
+	}
+
+	return added, removed
+}
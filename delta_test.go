@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScanHistoryEvictsOldest(t *testing.T) {
+	h := newScanHistory(2)
+	h.add("v1", []string{"a"})
+	h.add("v2", []string{"b"})
+	h.add("v3", []string{"c"})
+
+	if _, ok := h.get("v1"); ok {
+		t.Error("expected v1 to be evicted")
+	}
+	if paths, ok := h.get("v3"); !ok || paths[0] != "c" {
+		t.Errorf("expected v3 to be retained, got %v", paths)
+	}
+}
+
+func TestHandleListDeltaFrom(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "keep.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "gone.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	list := func(url string) (ListResponse, string) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp, w.Header().Get("X-Content-Version")
+	}
+
+	_, v1 := list("/list")
+	if v1 != computeVersion(context.Background()) {
+		t.Fatalf("expected X-Content-Version %s to match computeVersion", v1)
+	}
+
+	os.Remove(filepath.Join(tmpDir, "gone.mkv"))
+	os.WriteFile(filepath.Join(tmpDir, "new.mkv"), []byte("test"), 0644)
+
+	resp, _ := list("/list?delta-from=" + v1)
+	if resp.DeltaFrom != v1 {
+		t.Fatalf("expected delta from %s, got %q", v1, resp.DeltaFrom)
+	}
+	if len(resp.Files) != 1 || resp.Files[0].Name != "new.mkv" {
+		t.Errorf("expected only new.mkv to be added, got %+v", resp.Files)
+	}
+	if len(resp.Removed) != 1 || resp.Removed[0] != filepath.Join(tmpDir, "gone.mkv") {
+		t.Errorf("expected gone.mkv to be removed, got %v", resp.Removed)
+	}
+
+	resp, _ = list("/list?delta-from=sha256:unknown")
+	if resp.DeltaFrom != "" || len(resp.Files) != 2 || len(resp.Removed) != 0 {
+		t.Errorf("expected full listing for unknown version, got %+v", resp)
+	}
+}
+
+func TestHandleListDeltaFromRespectsAllowedPrefixes(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "kids"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "adults"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "kids", "cartoon.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "adults", "thriller.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+	v1 := w.Header().Get("X-Content-Version")
+
+	os.Remove(filepath.Join(tmpDir, "adults", "thriller.mkv"))
+
+	req = httptest.NewRequest(http.MethodGet, "/list?delta-from="+v1, nil)
+	req.Header.Set("X-Allowed-Prefixes", filepath.Join(tmpDir, "kids"))
+	w = httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.DeltaFrom != v1 {
+		t.Fatalf("expected a delta response, got %+v", resp)
+	}
+	if len(resp.Removed) != 0 || len(resp.Files) != 0 {
+		t.Errorf("expected no visible changes, got files %v removed %v", resp.Files, resp.Removed)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+type SizeDiff struct {
+	Path  string `json:"path"`
+	SizeA int64  `json:"size_a"`
+	SizeB int64  `json:"size_b"`
+}
+
+type DiffResponse struct {
+	Host        string     `json:"host"`
+	A           string     `json:"a"`
+	B           string     `json:"b"`
+	OnlyA       []string   `json:"only_a"`
+	OnlyB       []string   `json:"only_b"`
+	SizeDiffers []SizeDiff `json:"size_differs"`
+}
+
+// resolveDirLabel finds the configured root a label refers to: its --dir
+// value, its reported path or, if unambiguous, its base name.
+func resolveDirLabel(label string) (string, bool) {
+	var match string
+	matches := 0
+	for _, dir := range configuredDirs() {
+		switch {
+		case label == dir, label == reportedPath(dir):
+			return dir, true
+		case label == filepath.Base(dir):
+			match = dir
+			matches++
+		}
+	}
+	return match, matches == 1
+}
+
+// relativeSizes maps each file under root that r may see, by slash-separated
+// path relative to root, to its size.
+func relativeSizes(r *http.Request, root string) map[string]int64 {
+	sizes := make(map[string]int64)
+	walkAllowed(r, []string{root}, func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		sizes[filepath.ToSlash(rel)] = info.Size()
+		return nil
+	})
+	return sizes
+}
+
+// handleDiff compares two configured roots by relative path, e.g. to check a
+// mirror is in sync: files only in a, only in b, and in both with different
+// sizes. With ?from= it compares snapshots instead (handleSnapshotDiff).
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("from") {
+		handleSnapshotDiff(w, r)
+		return
+	}
+
+	var roots [2]string
+	for i, param := range []string{"a", "b"} {
+		label := r.URL.Query().Get(param)
+		if label == "" {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("missing '%s' parameter", param))
+			return
+		}
+		dir, ok := resolveDirLabel(label)
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("'%s' is not a configured directory: %q", param, label))
+			return
+		}
+		roots[i] = dir
+	}
+
+	a, b := relativeSizes(r, roots[0]), relativeSizes(r, roots[1])
+	if walkCancelled(w, r) {
+		return
+	}
+	response := DiffResponse{
+		Host:        config.FriendlyName,
+		A:           reportedPath(roots[0]),
+		B:           reportedPath(roots[1]),
+		OnlyA:       []string{},
+		OnlyB:       []string{},
+		SizeDiffers: []SizeDiff{},
+	}
+
+	for path, sizeA := range a {
+		sizeB, ok := b[path]
+		switch {
+		case !ok:
+			response.OnlyA = append(response.OnlyA, path)
+		case sizeA != sizeB:
+			response.SizeDiffers = append(response.SizeDiffers, SizeDiff{Path: path, SizeA: sizeA, SizeB: sizeB})
+		}
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			response.OnlyB = append(response.OnlyB, path)
+		}
+	}
+
+	sort.Strings(response.OnlyA)
+	sort.Strings(response.OnlyB)
+	sort.Slice(response.SizeDiffers, func(i, j int) bool { return response.SizeDiffers[i].Path < response.SizeDiffers[j].Path })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHandleDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	primary := filepath.Join(tmpDir, "primary")
+	mirror := filepath.Join(tmpDir, "mirror")
+	os.MkdirAll(filepath.Join(primary, "Show"), 0755)
+	os.MkdirAll(filepath.Join(mirror, "Show"), 0755)
+
+	os.WriteFile(filepath.Join(primary, "Show", "e01.mkv"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(mirror, "Show", "e01.mkv"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(primary, "Show", "e02.mkv"), make([]byte, 20), 0644)
+	os.WriteFile(filepath.Join(mirror, "Show", "e02.mkv"), make([]byte, 15), 0644)
+	os.WriteFile(filepath.Join(primary, "new.mkv"), make([]byte, 5), 0644)
+	os.WriteFile(filepath.Join(mirror, "stale.mkv"), make([]byte, 5), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{primary, mirror}
+
+	req := httptest.NewRequest(http.MethodGet, "/diff?a=primary&b="+mirror, nil)
+	w := httptest.NewRecorder()
+	handleDiff(w, req)
+
+	var resp DiffResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !reflect.DeepEqual(resp.OnlyA, []string{"new.mkv"}) {
+		t.Errorf("expected only_a [new.mkv], got %v", resp.OnlyA)
+	}
+	if !reflect.DeepEqual(resp.OnlyB, []string{"stale.mkv"}) {
+		t.Errorf("expected only_b [stale.mkv], got %v", resp.OnlyB)
+	}
+	want := []SizeDiff{{Path: "Show/e02.mkv", SizeA: 20, SizeB: 15}}
+	if !reflect.DeepEqual(resp.SizeDiffers, want) {
+		t.Errorf("expected size_differs %v, got %v", want, resp.SizeDiffers)
+	}
+
+	// Files X-Allowed-Prefixes hides aren't compared or named.
+	req = httptest.NewRequest(http.MethodGet, "/diff?a=primary&b=mirror", nil)
+	req.Header.Set("X-Allowed-Prefixes", filepath.Join(primary, "Show")+","+filepath.Join(mirror, "Show"))
+	w = httptest.NewRecorder()
+	handleDiff(w, req)
+	resp = DiffResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.OnlyA) != 0 || len(resp.OnlyB) != 0 || !reflect.DeepEqual(resp.SizeDiffers, want) {
+		t.Errorf("expected only Show/e02.mkv under X-Allowed-Prefixes, got %+v", resp)
+	}
+
+	for _, q := range []string{"a=primary", "a=primary&b=elsewhere", "a=/etc&b=mirror"} {
+		req := httptest.NewRequest(http.MethodGet, "/diff?"+q, nil)
+		w := httptest.NewRecorder()
+		handleDiff(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", q, w.Code)
+		}
+	}
+}
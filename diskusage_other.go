@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package main
+
+import "errors"
+
+// diskUsage always fails: filesystem space isn't available on this platform.
+func diskUsage(path string) (*DiskUsage, error) {
+	return nil, errors.ErrUnsupported
+}
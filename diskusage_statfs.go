@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// diskUsage returns the size and space of the filesystem holding path.
+func diskUsage(path string) (*DiskUsage, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	bsize := uint64(st.Bsize)
+	total, free := st.Blocks*bsize, uint64(st.Bfree)*bsize
+	return &DiskUsage{Total: total, Used: total - free, Available: uint64(st.Bavail) * bsize}, nil
+}
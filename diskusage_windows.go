@@ -0,0 +1,16 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// diskUsage returns the size and space of the volume holding path.
+func diskUsage(path string) (*DiskUsage, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &available, &total, &free); err != nil {
+		return nil, err
+	}
+	return &DiskUsage{Total: total, Used: total - free, Available: available}, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+)
+
+// handleDownload streams one file, named by its reported path as in /list.
+// http.ServeContent supplies Content-Type (from the extension, else sniffed),
+// Content-Length, Last-Modified and Range requests. Paths outside the
+// configured directories, or hidden by X-Allowed-Prefixes, are refused.
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "missing 'path' parameter")
+		return
+	}
+
+	real, ok := resolveReportedPath(p)
+	if !ok || !(listOptions{AllowedPrefixes: allowedPrefixes(r)}).allowed(p) {
+		writeError(w, http.StatusForbidden, "path is outside the served directories")
+		return
+	}
+
+	f, err := os.Open(real)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Warn("Error opening file", "path", real, "err", err)
+		writeError(w, http.StatusForbidden, "file can't be read")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusBadRequest, "not a regular file")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleDownload(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("0123456789"), 0644)
+	os.Mkdir(filepath.Join(tmpDir, "season1"), 0755)
+	config.Dirs = []string{tmpDir}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/download?path="+url.QueryEscape(path), nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handleDownload(w, req)
+		return w
+	}
+
+	movie := filepath.Join(tmpDir, "movie.mkv")
+	w := get(movie)
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" || w.Header().Get("Content-Length") != "10" {
+		t.Fatalf("expected the whole file, got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w.Header().Get("Content-Disposition") != `attachment; filename=movie.mkv` {
+		t.Errorf("unexpected Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+
+	w = get(movie, "Range", "bytes=2-4")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("expected bytes 2-4, got %d %q", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header []string
+		want   int
+	}{
+		{"missing path", "", nil, http.StatusBadRequest},
+		{"escape with ..", filepath.Join(tmpDir, "..", "etc", "passwd"), nil, http.StatusForbidden},
+		{"outside the roots", "/etc/passwd", nil, http.StatusForbidden},
+		{"missing file", filepath.Join(tmpDir, "gone.mkv"), nil, http.StatusNotFound},
+		{"directory", filepath.Join(tmpDir, "season1"), nil, http.StatusBadRequest},
+		{"hidden by X-Allowed-Prefixes", movie, []string{"X-Allowed-Prefixes", filepath.Join(tmpDir, "season1")}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if w := get(tt.path, tt.header...); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleDownloadUnlistedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"movie.mkv", "notes.txt", ".secret.mkv", ".git/config.mkv", "@eaDir/thumb.mkv", "a/b/deep.mkv"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0755)
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("data"), 0644)
+	}
+	config.Dirs = []string{tmpDir}
+	config.SkipHidden = true
+	config.Excludes = []string{"@eaDir"}
+	config.Extensions = []string{"mkv"}
+	config.MaxDepth = 2
+	t.Cleanup(func() {
+		config.Dirs, config.SkipHidden, config.Excludes, config.Extensions, config.MaxDepth = nil, false, nil, nil, 0
+	})
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"listed file", "movie.mkv", http.StatusOK},
+		{"hidden file", ".secret.mkv", http.StatusForbidden},
+		{"inside a hidden directory", ".git/config.mkv", http.StatusForbidden},
+		{"inside an excluded directory", "@eaDir/thumb.mkv", http.StatusForbidden},
+		{"extension not in --ext", "notes.txt", http.StatusForbidden},
+		{"deeper than --max-depth", "a/b/deep.mkv", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/download?path="+url.QueryEscape(filepath.Join(tmpDir, tt.path)), nil)
+		w := httptest.NewRecorder()
+		handleDownload(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d: %s", tt.name, tt.want, w.Code, w.Body.String())
+		}
+	}
+}
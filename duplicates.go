@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+)
+
+type DuplicateGroup struct {
+	Size  int64    `json:"size"`
+	Hash  string   `json:"sha256,omitempty"`
+	Paths []string `json:"paths"`
+}
+
+type DuplicatesResponse struct {
+	Host string `json:"host"`
+	// Reclaimable is the bytes freed by keeping one file from each group.
+	Reclaimable int64            `json:"reclaimable"`
+	Groups      []DuplicateGroup `json:"groups"`
+	Truncated   bool             `json:"truncated,omitempty"`
+}
+
+// handleDuplicates reports sets of probable duplicates across every
+// configured directory: files of the same size and, with ?hash=1, the same
+// sha256. Hardlinks to one file are listed once, since removing them frees
+// nothing. ?min_size= (default 1 byte) skips small files. Hashing is charged
+// to the read budget; groups it doesn't reach are reported by size alone and
+// the response is marked truncated. Groups come largest saving first.
+func handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	minSize := int64(1)
+	if s := r.URL.Query().Get("min_size"); s != "" {
+		n, err := parseByteSize(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'min_size' parameter: %q", s))
+			return
+		}
+		minSize = max(n, 1)
+	}
+	hash := queryFlag(r, "hash")
+
+	bySize := make(map[int64][]string)
+	seen := inodeSet{}
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
+		}
+		if info.Size() < minSize || !seen.firstSighting(info) {
+			return nil
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	// Hash the biggest files first: they're the ones worth reclaiming.
+	var sizes []int64
+	for size, paths := range bySize {
+		if len(paths) > 1 {
+			sizes = append(sizes, size)
+		}
+	}
+	slices.SortFunc(sizes, func(a, b int64) int { return cmp.Compare(b, a) })
+
+	budget := newReadBudget()
+	response := DuplicatesResponse{Host: config.FriendlyName, Groups: []DuplicateGroup{}}
+	for _, size := range sizes {
+		paths := bySize[size]
+		if !hash || budget.Exhausted() {
+			response.Groups = append(response.Groups, DuplicateGroup{Size: size, Paths: paths})
+			continue
+		}
+		byHash, err := hashGroup(r, paths, budget)
+		if errors.Is(err, errReadBudgetExceeded) {
+			response.Groups = append(response.Groups, DuplicateGroup{Size: size, Paths: paths})
+			continue
+		}
+		for sum, same := range byHash {
+			if len(same) > 1 {
+				response.Groups = append(response.Groups, DuplicateGroup{Size: size, Hash: sum, Paths: same})
+			}
+		}
+	}
+	response.Truncated = budget.Exhausted()
+
+	for i := range response.Groups {
+		g := &response.Groups[i]
+		for j, p := range g.Paths {
+			g.Paths[j] = reportedPath(p)
+		}
+		slices.Sort(g.Paths)
+		response.Reclaimable += g.Size * int64(len(g.Paths)-1)
+	}
+	slices.SortFunc(response.Groups, func(a, b DuplicateGroup) int {
+		return cmp.Or(
+			cmp.Compare(b.Size*int64(len(b.Paths)-1), a.Size*int64(len(a.Paths)-1)),
+			cmp.Compare(a.Paths[0], b.Paths[0]),
+		)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// hashGroup splits paths by the sha256 of their content. Files that can't be
+// read are left out; running out of read budget abandons the whole group.
+func hashGroup(r *http.Request, paths []string, budget *readBudget) (map[string][]string, error) {
+	byHash := make(map[string][]string)
+	for _, p := range paths {
+		sum, err := contentHashes.sum(p, "sha256", budget)
+		if errors.Is(err, errReadBudgetExceeded) {
+			return nil, err
+		}
+		if err != nil {
+			requestLogger(r).Warn("Error hashing file", "path", p, "err", err)
+			continue
+		}
+		byHash[sum] = append(byHash[sum], p)
+	}
+	return byHash, nil
+}
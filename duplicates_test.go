@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHandleDuplicates(t *testing.T) {
+	tmpDir := t.TempDir()
+	a, b := filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "b")
+	os.MkdirAll(filepath.Join(b, "backup"), 0755)
+	os.MkdirAll(a, 0755)
+
+	movie := bytes.Repeat([]byte("m"), 100)
+	os.WriteFile(filepath.Join(a, "movie.mkv"), movie, 0644)
+	os.WriteFile(filepath.Join(b, "backup", "movie.mkv"), movie, 0644)
+	os.WriteFile(filepath.Join(a, "other.mkv"), bytes.Repeat([]byte("o"), 100), 0644)
+	os.WriteFile(filepath.Join(a, "notes.txt"), []byte("hi"), 0644)
+	os.WriteFile(filepath.Join(b, "notes.txt"), []byte("hi"), 0644)
+	os.WriteFile(filepath.Join(a, "empty"), nil, 0644)
+	os.WriteFile(filepath.Join(b, "empty"), nil, 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{a, b}
+	cache := contentHashes
+	t.Cleanup(func() { config.MaxReadBytes, contentHashes = 0, cache })
+
+	moviePaths := []string{filepath.Join(a, "movie.mkv"), filepath.Join(b, "backup", "movie.mkv")}
+	notesPaths := []string{filepath.Join(a, "notes.txt"), filepath.Join(b, "notes.txt")}
+	bySize := []DuplicateGroup{
+		{Size: 100, Paths: []string{filepath.Join(a, "movie.mkv"), filepath.Join(a, "other.mkv"), filepath.Join(b, "backup", "movie.mkv")}},
+		{Size: 2, Paths: notesPaths},
+	}
+
+	tests := []struct {
+		query           string
+		maxRead         int64
+		want            []DuplicateGroup
+		wantReclaimable int64
+		wantTruncated   bool
+	}{
+		{"", 0, bySize, 202, false},
+		{"min_size=10", 0, bySize[:1], 200, false},
+		{"hash=1", 0, []DuplicateGroup{
+			{Size: 100, Hash: "f6449b4f736925a0013c6cbeb5cc4129c77576761aa2f694cae0f55b1d43f5c9", Paths: moviePaths},
+			{Size: 2, Hash: "8f434346648f6b96df89dda901c5176b10a6d83961dd3c1ac88b59b2dc327aa4", Paths: notesPaths},
+		}, 102, false},
+		{"hash=1", 150, bySize, 202, true},
+	}
+
+	for _, tt := range tests {
+		config.MaxReadBytes = tt.maxRead
+		contentHashes = newHashCache(2) // cached sums would cost no budget
+		w := httptest.NewRecorder()
+		handleDuplicates(w, httptest.NewRequest(http.MethodGet, "/duplicates?"+tt.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status 200, got %d: %s", tt.query, w.Code, w.Body.String())
+		}
+		var resp DuplicatesResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if !reflect.DeepEqual(resp.Groups, tt.want) {
+			t.Errorf("%q (max read %d): expected groups %v, got %v", tt.query, tt.maxRead, tt.want, resp.Groups)
+		}
+		if resp.Reclaimable != tt.wantReclaimable {
+			t.Errorf("%q (max read %d): expected reclaimable %d, got %d", tt.query, tt.maxRead, tt.wantReclaimable, resp.Reclaimable)
+		}
+		if resp.Truncated != tt.wantTruncated {
+			t.Errorf("%q (max read %d): expected truncated %v, got %v", tt.query, tt.maxRead, tt.wantTruncated, resp.Truncated)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleDuplicates(w, httptest.NewRequest(http.MethodGet, "/duplicates?min_size=lots", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad min_size, got %d", w.Code)
+	}
+}
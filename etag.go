@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeWithETag writes body with an ETag derived from its bytes, or just a
+// 304 Not Modified if r's If-None-Match already names that ETag, so pollers
+// re-download a listing only when it has changed. Headers set before the call,
+// such as X-Content-Version, are sent either way.
+//
+// The tag is a hash of the body, so a 304 saves the transfer but not the walk
+// that built it. A tag worked out before walking, from computeVersion and the
+// query, would miss changed sizes and mtimes, peers' files, ages such
+// as ?modified_after=7d and hashed content, and serve stale 304s.
+func writeWithETag(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// etagMatches reports whether an If-None-Match header names etag, using the
+// weak comparison RFC 9110 asks for: W/ prefixes are ignored.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate != "" && candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleEvents streams index changes as Server-Sent Events: each has its
+// Seq as the id, its Type as the event name and a ChangeEvent as JSON data.
+// A reconnecting client's Last-Event-ID header (or ?last-event-id=, for
+// clients that can't set it) replays the journalled events it missed. If
+// they are no longer journalled, a "reset" event tells the client to fetch a
+// full listing before carrying on. Needs an index, like /ws.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if index == nil {
+		writeError(w, http.StatusServiceUnavailable, "change notifications need an index (--scan-interval or --watch)")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last-event-id")
+	}
+	var ch chan ChangeEvent
+	var missed []ChangeEvent
+	reset := false
+	if lastID == "" {
+		ch = changes.subscribe()
+	} else {
+		seq, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid Last-Event-ID: %q", lastID))
+			return
+		}
+		ch, missed, ok = changes.subscribeSince(seq)
+		reset = !ok
+	}
+	defer changes.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	for _, ev := range missed {
+		if opts.allowed(ev.Path) {
+			writeChangeEvent(w, ev)
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(additionsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev := <-ch:
+			if !opts.allowed(ev.Path) {
+				continue
+			}
+			writeChangeEvent(w, ev)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-shutdownStarted(r.Context()):
+			return
+		}
+	}
+}
+
+func writeChangeEvent(w http.ResponseWriter, ev ChangeEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleEvents(t *testing.T) {
+	config.Dirs = []string{t.TempDir()}
+	index = newFileIndex(config.Dirs)
+	oldChanges := changes
+	changes = newChangeFeed(10000)
+	t.Cleanup(func() { index, changes = nil, oldChanges })
+	changes.publish([]ChangeEvent{
+		{Type: "added", Path: "/media/a.mkv", Size: 1},
+		{Type: "modified", Path: "/media/a.mkv", Size: 2},
+		{Type: "removed", Path: "/media/a.mkv"},
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(handleEvents))
+	t.Cleanup(srv.Close) // after the streams below are closed
+
+	// open connects with lastID and returns a function reading the next
+	// event's id, name and data.
+	open := func(lastID string) func() (string, string, string) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("expected text/event-stream, got %s", ct)
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		return func() (id, event, data string) {
+			t.Helper()
+			for scanner.Scan() {
+				line := scanner.Text()
+				if line == "" && event != "" {
+					return id, event, data
+				}
+				if v, ok := strings.CutPrefix(line, "id: "); ok {
+					id = v
+				} else if v, ok := strings.CutPrefix(line, "event: "); ok {
+					event = v
+				} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+					data = v
+				}
+			}
+			t.Fatalf("stream ended: %v", scanner.Err())
+			return
+		}
+	}
+
+	next := open("1")
+	for _, want := range []string{"2 modified", "3 removed"} {
+		if id, event, _ := next(); id+" "+event != want {
+			t.Errorf("expected catch-up event %q, got %q", want, id+" "+event)
+		}
+	}
+	for changes.subscribers() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	changes.publish([]ChangeEvent{{Type: "added", Path: "/media/b.mkv", Size: 5}})
+	if id, event, data := next(); id != "4" || event != "added" || !strings.Contains(data, `"path":"/media/b.mkv"`) {
+		t.Errorf("expected live event 4 added for b.mkv, got %s %s %s", id, event, data)
+	}
+
+	// An ID from before a restart can't be caught up.
+	if _, event, _ := open("99")(); event != "reset" {
+		t.Errorf("expected a reset for an unknown ID, got %q", event)
+	}
+
+	changes.size = 2
+	changes.publish([]ChangeEvent{{Type: "removed", Path: "/media/b.mkv"}, {Type: "removed", Path: "/media/c.mkv"}})
+	if _, event, _ := open("3")(); event != "reset" {
+		t.Errorf("expected a reset for an ID older than the journal, got %q", event)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Last-Event-ID", "abc")
+	handleEvents(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a bad Last-Event-ID, got %d", w.Code)
+	}
+}
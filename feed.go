@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultFeedEntries = 20
+	maxFeedEntries     = 500
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	Link    atomLink `xml:"link"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary"`
+}
+
+// atomLink is an entry's alternate link, to the file on /download. The href
+// is relative, so readers resolve it against the feed's own URL.
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// handleFeed returns the ?n= (default 20) most recently modified files as an
+// Atom feed, so a feed reader can follow newly added media. Each entry links
+// to the file's /download. Entry IDs are
+// derived from the reported path, so a replaced file shows up as an update
+// rather than a new entry.
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	n := defaultFeedEntries
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'n' parameter: %q", v))
+			return
+		}
+		n = min(n, maxFeedEntries)
+	}
+
+	type recentFile struct {
+		path    string
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []recentFile
+
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
+		}
+		files = append(files, recentFile{path: reportedPath(path), name: d.Name(), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	files = files[:min(n, len(files))]
+
+	feed := atomFeed{
+		Title:  fmt.Sprintf("Recent files on %s", config.FriendlyName),
+		ID:     fmt.Sprintf("urn:filesystem-lister:%s:feed", config.FriendlyName),
+		Author: atomAuthor{Name: config.FriendlyName},
+	}
+	// An empty feed still needs an updated time; use the Unix epoch.
+	updated := time.Unix(0, 0)
+	if len(files) > 0 {
+		updated = files[0].modTime
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	for _, f := range files {
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   f.name,
+			Link:    atomLink{Rel: "alternate", Href: "/download?path=" + url.QueryEscape(f.path)},
+			ID:      fmt.Sprintf("urn:sha256:%x", sha256.Sum256([]byte(f.path))),
+			Updated: f.modTime.UTC().Format(time.RFC3339),
+			Summary: fmt.Sprintf("%s (%d bytes)", f.path, f.size),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		requestLogger(r).Error("Error encoding feed", "err", err)
+	}
+}
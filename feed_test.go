@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleFeed(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"old.mkv", "newer.mkv", "newest.mkv"} {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, []byte("test"), 0644)
+		mtime := base.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/feed.xml?n=2", nil)
+	w := httptest.NewRecorder()
+	handleFeed(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid feed XML: %v", err)
+	}
+	if feed.Updated != "2024-03-01T14:00:00Z" {
+		t.Errorf("expected feed updated at newest mtime, got %s", feed.Updated)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].Title != "newest.mkv" || feed.Entries[1].Title != "newer.mkv" {
+		t.Errorf("expected newest.mkv then newer.mkv, got %+v", feed.Entries)
+	}
+	wantLink := atomLink{Rel: "alternate", Href: "/download?path=" + url.QueryEscape(filepath.Join(tmpDir, "newest.mkv"))}
+	if len(feed.Entries) > 0 && feed.Entries[0].Link != wantLink {
+		t.Errorf("expected link %+v, got %+v", wantLink, feed.Entries[0].Link)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/feed.xml?n=0", nil)
+	w = httptest.NewRecorder()
+	handleFeed(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for n=0, got %d", w.Code)
+	}
+}
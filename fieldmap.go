@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldMap renames FileEntry JSON keys for clients that expect different
+// names, e.g. {"path": "filepath", "size": "bytes"}.
+type fieldMap map[string]string
+
+// fileEntryKeys lists the JSON keys FileEntry can produce.
+var fileEntryKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(FileEntry{})
+	for i := range t.NumField() {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		keys[name] = true
+	}
+	return keys
+}()
+
+// parseFieldMap parses a spec like "path:filepath,name:filename,size:bytes".
+// Every source must be a FileEntry key, and the resulting key names (mapped
+// and unmapped) must be non-empty and unique.
+func parseFieldMap(spec string) (fieldMap, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	m := make(fieldMap)
+	for _, pair := range strings.Split(spec, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || to == "" {
+			return nil, fmt.Errorf("invalid field mapping %q (want field:name)", pair)
+		}
+		if !fileEntryKeys[from] {
+			return nil, fmt.Errorf("unknown field %q", from)
+		}
+		if _, dup := m[from]; dup {
+			return nil, fmt.Errorf("field %q mapped twice", from)
+		}
+		m[from] = to
+	}
+
+	seen := make(map[string]string)
+	for key := range fileEntryKeys {
+		name := key
+		if to, ok := m[key]; ok {
+			name = to
+		}
+		if other, dup := seen[name]; dup {
+			return nil, fmt.Errorf("fields %q and %q would both be named %q", other, key, name)
+		}
+		seen[name] = key
+	}
+
+	return m, nil
+}
+
+// mappedEntry marshals a FileEntry with its keys renamed.
+type mappedEntry struct {
+	entry  FileEntry
+	fields fieldMap
+}
+
+func (e mappedEntry) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(e.entry)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	renamed := make(map[string]json.RawMessage, len(raw))
+	for key, value := range raw {
+		if to, ok := e.fields[key]; ok {
+			key = to
+		}
+		renamed[key] = value
+	}
+	return json.Marshal(renamed)
+}
+
+// mappedListResponse is a ListResponse whose files use a fieldMap. The outer
+// Files field shadows the embedded one when encoding.
+type mappedListResponse struct {
+	ListResponse
+	Files []mappedEntry `json:"files"`
+}
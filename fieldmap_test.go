@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFieldMap(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"", false},
+		{"path:filepath,name:filename,size:bytes", false},
+		{"path:name,name:path", false},
+		{"path:", true},
+		{"path", true},
+		{"bogus:thing", true},
+		{"path:a,path:b", true},
+		{"path:name", true},
+		{"path:x,name:x", true},
+	}
+
+	for _, tt := range tests {
+		_, err := parseFieldMap(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFieldMap(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHandleListFieldMap(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list?fieldmap=path:filepath,name:filename,size:bytes", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp struct {
+		Host  string           `json:"host"`
+		Files []map[string]any `json:"files"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	if resp.Host != "test-host" || len(resp.Files) != 1 {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+	f := resp.Files[0]
+	if f["filepath"] != filepath.Join(tmpDir, "movie.mkv") || f["filename"] != "movie.mkv" || f["bytes"] != float64(4) {
+		t.Errorf("expected renamed keys, got %v", f)
+	}
+	if _, ok := f["path"]; ok {
+		t.Errorf("expected original key to be gone, got %v", f)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/list?fieldmap=path:name", nil)
+	w = httptest.NewRecorder()
+	handleList(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for colliding names, got %d", w.Code)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listFormatTypes are the ?format= values writeListResponse supports besides
+// the default json, and their Content-Types.
+var listFormatTypes = map[string]string{
+	"csv": "text/csv; charset=utf-8",
+	"tsv": "text/tab-separated-values; charset=utf-8",
+	"xml": "application/xml; charset=utf-8",
+}
+
+// encodeDelimited writes resp.Files as CSV, or TSV with comma '\t': a header
+// row, then path, name, size and, unless nosize left it out, mtime (RFC
+// 3339) for each file. Header names follow the request's field mapping.
+// Only the files are included, not the response's other fields.
+func encodeDelimited(resp ListResponse, opts listOptions, comma rune) []byte {
+	columns := []string{"path", "name", "size"}
+	if !opts.NoSize {
+		columns = append(columns, "mtime")
+	}
+
+	var body bytes.Buffer
+	cw := csv.NewWriter(&body)
+	cw.Comma = comma
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c
+		if to, ok := opts.FieldMap[c]; ok {
+			header[i] = to
+		}
+	}
+	cw.Write(header)
+	for _, f := range resp.Files {
+		record := []string{f.Path, f.Name, strconv.FormatInt(f.Size, 10)}
+		if !opts.NoSize {
+			record = append(record, f.ModTime.Format(time.RFC3339))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return body.Bytes()
+}
+
+// prefersXML reports whether an Accept header asks for XML over JSON:
+// application/xml or text/xml with a higher q than application/json.
+// Browsers list XML above */* too, so an Accept that takes text/html keeps
+// JSON.
+func prefersXML(header string) bool {
+	xmlQ, jsonQ, htmlQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return xmlQ > 0 && xmlQ > jsonQ && htmlQ <= 0
+}
+
+// xmlListing is the ?format=xml document:
+//
+//	<listing host="" instance_id="" [total="" next_offset="" truncated="true" ...]>
+//	  <file path="" name="" size="" [mtime="" mode="" hash="" host="" ...]>
+//	    [<preview>text</preview>] [<xattr name="">value</xattr>...]
+//	  </file>
+//	  <removed>path</removed>
+//	  <peer url="" [host=""] files="" [error=""]/>
+//	</listing>
+//
+// Attributes and elements carry the same names and values as the JSON keys,
+// and optional ones are omitted when empty. Field renaming doesn't apply.
+type xmlListing struct {
+	XMLName    xml.Name   `xml:"listing"`
+	Host       string     `xml:"host,attr"`
+	InstanceID string     `xml:"instance_id,attr"`
+	DeltaFrom  string     `xml:"delta_from,attr,omitempty"`
+	Truncated  bool       `xml:"truncated,attr,omitempty"`
+	Sampled    bool       `xml:"sampled,attr,omitempty"`
+	Scanned    int        `xml:"scanned,attr,omitempty"`
+	Total      *int       `xml:"total,attr,omitempty"`
+	NextOffset int        `xml:"next_offset,attr,omitempty"`
+	Sort       string     `xml:"sort,attr,omitempty"`
+	Partial    bool       `xml:"partial,attr,omitempty"`
+	Files      []xmlFile  `xml:"file"`
+	Removed    []string   `xml:"removed"`
+	Peers      []xmlPeer  `xml:"peer"`
+	Errors     []xmlError `xml:"error"`
+}
+
+type xmlFile struct {
+	Path    string     `xml:"path,attr"`
+	Name    string     `xml:"name,attr"`
+	Size    int64      `xml:"size,attr"`
+	ModTime string     `xml:"mtime,attr,omitempty"`
+	Mode    string     `xml:"mode,attr,omitempty"`
+	Symlink bool       `xml:"symlink,attr,omitempty"`
+	Target  string     `xml:"target,attr,omitempty"`
+	Parent  string     `xml:"parent,attr,omitempty"`
+	Nlink   uint64     `xml:"nlink,attr,omitempty"`
+	Locked  bool       `xml:"locked,attr,omitempty"`
+	Score   int        `xml:"score,attr,omitempty"`
+	Hash    string     `xml:"hash,attr,omitempty"`
+	Host    string     `xml:"host,attr,omitempty"`
+	NameHex string     `xml:"name_hex,attr,omitempty"`
+	Match   string     `xml:"match,attr,omitempty"` // "start,end", as the JSON array
+	Preview string     `xml:"preview,omitempty"`
+	Xattrs  []xmlXattr `xml:"xattr"`
+	Media   *xmlMedia  `xml:"media"`
+}
+
+type xmlXattr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type xmlMedia struct {
+	Title      string `xml:"title,attr,omitempty"`
+	Year       int    `xml:"year,attr,omitempty"`
+	Resolution string `xml:"resolution,attr,omitempty"`
+	Codec      string `xml:"codec,attr,omitempty"`
+	Season     *int   `xml:"season,attr"`
+	Episode    *int   `xml:"episode,attr"`
+}
+
+type xmlPeer struct {
+	URL   string `xml:"url,attr"`
+	Host  string `xml:"host,attr,omitempty"`
+	Files int    `xml:"files,attr"`
+	Error string `xml:"error,attr,omitempty"`
+}
+
+type xmlError struct {
+	Path  string `xml:"path,attr"`
+	Host  string `xml:"host,attr,omitempty"`
+	Error string `xml:",chardata"`
+}
+
+// encodeXML writes resp as an xmlListing.
+func encodeXML(resp ListResponse) []byte {
+	doc := xmlListing{
+		Host:       resp.Host,
+		InstanceID: resp.InstanceID,
+		DeltaFrom:  resp.DeltaFrom,
+		Truncated:  resp.Truncated,
+		Sampled:    resp.Sampled,
+		Scanned:    resp.Scanned,
+		Total:      resp.Total,
+		NextOffset: resp.NextOffset,
+		Sort:       resp.Sort,
+		Partial:    resp.Partial,
+		Removed:    resp.Removed,
+	}
+	for _, f := range resp.Files {
+		xf := xmlFile{
+			Path: f.Path, Name: f.Name, Size: f.Size, Mode: f.Mode, Symlink: f.Symlink, Target: f.Target,
+			Parent: f.Parent, Nlink: f.Nlink, Locked: f.Locked, Score: f.Score,
+			Hash: f.Hash, Host: f.Host, NameHex: f.NameHex, Preview: f.Preview,
+		}
+		if !f.ModTime.IsZero() {
+			xf.ModTime = f.ModTime.Format(time.RFC3339Nano)
+		}
+		if f.Match != nil {
+			xf.Match = strconv.Itoa(f.Match[0]) + "," + strconv.Itoa(f.Match[1])
+		}
+		if m := f.Media; m != nil {
+			xf.Media = &xmlMedia{Title: m.Title, Year: m.Year, Resolution: m.Resolution, Codec: m.Codec, Season: m.Season, Episode: m.Episode}
+		}
+		for _, name := range slices.Sorted(maps.Keys(f.Xattrs)) {
+			xf.Xattrs = append(xf.Xattrs, xmlXattr{Name: name, Value: f.Xattrs[name]})
+		}
+		doc.Files = append(doc.Files, xf)
+	}
+	for _, p := range resp.Peers {
+		doc.Peers = append(doc.Peers, xmlPeer{URL: p.URL, Host: p.Host, Files: p.Files, Error: p.Error})
+	}
+	for _, e := range resp.Errors {
+		doc.Errors = append(doc.Errors, xmlError{Path: e.Path, Host: e.Host, Error: e.Error})
+	}
+
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	enc := xml.NewEncoder(&body)
+	enc.Indent("", "  ")
+	enc.Encode(doc)
+	body.WriteByte('\n')
+	return body.Bytes()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListFormats(t *testing.T) {
+	tmpDir := t.TempDir()
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, size := range map[string]int{"a.mkv": 3, "b, the sequel.mkv": 5} {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, make([]byte, size), 0644)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	a, b := filepath.Join(tmpDir, "a.mkv"), filepath.Join(tmpDir, "b, the sequel.mkv")
+	stamp := mtime.Local().Format(time.RFC3339)
+
+	tests := []struct {
+		query       string
+		contentType string
+		want        string
+	}{
+		{"format=csv", "text/csv; charset=utf-8",
+			"path,name,size,mtime\n" + a + ",a.mkv,3," + stamp + "\n\"" + b + "\",\"b, the sequel.mkv\",5," + stamp + "\n"},
+		{"format=tsv&nosize=1&fieldmap=path:filepath", "text/tab-separated-values; charset=utf-8",
+			"filepath\tname\tsize\n" + a + "\ta.mkv\t0\n" + b + "\tb, the sequel.mkv\t0\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleList(w, httptest.NewRequest(http.MethodGet, "/list?sort=name&"+tt.query, nil))
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%q: expected Content-Type %q, got %q", tt.query, tt.contentType, ct)
+		}
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.query, tt.want, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?q=a*&format=csv", nil))
+	if !strings.HasSuffix(w.Body.String(), ",a.mkv,3,"+stamp+"\n") || strings.Count(w.Body.String(), "\n") != 2 {
+		t.Errorf("expected a header and one row from /filter, got %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handleList(w, httptest.NewRequest(http.MethodGet, "/list?format=yaml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for format=yaml, got %d", w.Code)
+	}
+}
+
+func TestListXML(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a & b.mkv"), make([]byte, 3), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/list"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handleList(w, r)
+		return w
+	}
+
+	w := get("?format=xml&limit=1", "")
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Errorf("expected Content-Type application/xml, got %q", ct)
+	}
+	if !strings.HasPrefix(w.Body.String(), xml.Header) {
+		t.Errorf("expected an XML declaration, got %q", w.Body.String())
+	}
+	var doc xmlListing
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Host != "test-host" || doc.Total == nil || *doc.Total != 1 || len(doc.Files) != 1 {
+		t.Fatalf("expected host test-host and one of one files, got %+v", doc)
+	}
+	if f := doc.Files[0]; f.Name != "a & b.mkv" || f.Size != 3 || f.ModTime == "" {
+		t.Errorf("expected a & b.mkv with size and mtime, got %+v", f)
+	}
+
+	tests := []struct {
+		accept string
+		xml    bool
+	}{
+		{"application/xml", true},
+		{"text/xml, application/json;q=0.5", true},
+		{"application/json, application/xml;q=0.9", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"*/*", false},
+	}
+	for _, tt := range tests {
+		w := get("", tt.accept)
+		if got := strings.HasPrefix(w.Body.String(), "<?xml"); got != tt.xml {
+			t.Errorf("Accept %q: expected XML %v, got %q", tt.accept, tt.xml, w.Body.String())
+		}
+		if w.Header().Get("Vary") == "" {
+			t.Errorf("Accept %q: expected a Vary header", tt.accept)
+		}
+	}
+	if w := get("?format=json", "application/xml"); strings.HasPrefix(w.Body.String(), "<?xml") {
+		t.Error("expected ?format=json to override Accept")
+	}
+}
@@ -1,3 +1,20 @@
 module filesystem-lister
 
 go 1.24.1
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/grandcat/zeroconf v1.0.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.39.0
+	golang.org/x/sys v0.33.0
+)
+
+require (
+	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
+	github.com/miekg/dns v1.1.27 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+)
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters recycles compressors, which are large, one pool per level.
+var gzipWriters [gzip.BestCompression + 1]sync.Pool
+
+// withGzip compresses responses for clients that accept gzip, at --gzip-level
+// (0 turns compression off). Listings repeat the same directory prefixes over
+// and over, so large ones shrink tenfold or more.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.GzipLevel == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w, level: config.GzipLevel}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, by name
+// or through "*", with a non-zero q value. An explicit gzip;q=0 wins over "*".
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		switch coding = strings.ToLower(strings.TrimSpace(coding)); coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// compressible reports whether a Content-Type is worth compressing: JSON,
+// XML and text. Downloaded media is already compressed, and an unknown type
+// is left alone.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml")
+}
+
+// gzipWriter compresses the body written through it. Compression is decided
+// when the header is written, from the status and Content-Type: partial
+// content is never compressed, as its Content-Range counts uncompressed bytes.
+// The gzip stream only starts with the first body write, so bodiless
+// responses such as 304 stay empty.
+type gzipWriter struct {
+	http.ResponseWriter
+	level       int
+	gz          *gzip.Writer
+	wroteHeader bool
+	compress    bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(status) // superfluous; let net/http report it
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	w.compress = status != http.StatusNoContent && status != http.StatusNotModified && status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type"))
+	if w.compress {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		// The compressed bytes differ, so a strong ETag becomes weak.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		// Sniff from the plain bytes: net/http would see compressed ones.
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.compress {
+		return w.ResponseWriter.Write(p)
+	}
+
+	if w.gz == nil {
+		if gz, ok := gzipWriters[w.level].Get().(*gzip.Writer); ok {
+			gz.Reset(w.ResponseWriter)
+			w.gz = gz
+		} else {
+			w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+		}
+	}
+	return w.gz.Write(p)
+}
+
+// Flush pushes out what has been compressed so far, so streaming handlers
+// such as /additions still deliver each event as it happens.
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the gzip stream, if one was started.
+func (w *gzipWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	gzipWriters[w.level].Put(w.gz)
+	w.gz = nil
+	return err
+}
+
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP", true},
+		{"br", false},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0, *", false},
+		{"identity, *;q=0", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/json":                    true,
+		"application/atom+xml; charset=utf-8": true,
+		"text/event-stream":                   true,
+		"video/x-matroska":                    false,
+		"application/octet-stream":            false,
+		"":                                    false,
+	} {
+		if got := compressible(contentType); got != want {
+			t.Errorf("compressible(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	config.GzipLevel = 6
+	t.Cleanup(func() { config.GzipLevel = 0 })
+
+	body := strings.Repeat(`{"path":"/media/movies/file.mkv"}`, 100)
+	handler := withGzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unchanged" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzip response varying on Accept-Encoding, got headers %v", w.Header())
+	}
+	if w.Body.Len() >= len(body)/5 {
+		t.Errorf("expected the repetitive body to compress well, got %d bytes from %d", w.Body.Len(), len(body))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != body {
+		t.Errorf("decompressed body differs from the original")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/list", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != body {
+		t.Errorf("expected an uncompressed body without Accept-Encoding")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/unchanged", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+		t.Errorf("expected an empty, unencoded 304, got %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	config.GzipLevel = 0
+	req = httptest.NewRequest(http.MethodGet, "/list", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected --gzip-level 0 to disable compression")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// hashAlgorithms are the content hashes ?hash=, /hash and --hash-on-scan
+// offer: sha256 for integrity checks, xxhash (XXH64) for fast dedup.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"xxhash": func() hash.Hash { return xxhash.New() },
+}
+
+// parseHashAlgorithm checks a requested algorithm name.
+func parseHashAlgorithm(algo string) error {
+	if _, ok := hashAlgorithms[algo]; !ok {
+		return fmt.Errorf("unknown hash algorithm %q (want sha256 or xxhash)", algo)
+	}
+	return nil
+}
+
+type hashKey struct {
+	path, algo string
+}
+
+// cachedHash is a computed sum and the size and mtime of the file it was
+// computed from; a file that no longer matches is hashed again.
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// hashCache remembers content hashes so each file is only read again once
+// it changes, and limits how many files are hashed at once across requests
+// and background scans.
+type hashCache struct {
+	mu      sync.Mutex
+	sums    map[hashKey]cachedHash
+	workers chan struct{} // one slot per concurrent hash
+	warming atomic.Bool   // a warm is running
+}
+
+// contentHashes is the process-wide cache, resized by --hash-workers.
+var contentHashes = newHashCache(2)
+
+func newHashCache(workers int) *hashCache {
+	return &hashCache{sums: make(map[hashKey]cachedHash), workers: make(chan struct{}, workers)}
+}
+
+// sum returns the hex-encoded algo hash of the regular file at path, from the
+// cache if the file's size and mtime are unchanged, or else by reading it
+// and charging its size to budget.
+func (c *hashCache) sum(path, algo string, budget *readBudget) (string, error) {
+	newHash, ok := hashAlgorithms[algo]
+	if !ok {
+		return "", parseHashAlgorithm(algo)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fs.ErrNotExist
+	}
+
+	key := hashKey{path, algo}
+	c.mu.Lock()
+	cached, ok := c.sums[key]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	if !budget.reserve(info.Size()) {
+		return "", errReadBudgetExceeded
+	}
+	c.workers <- struct{}{}
+	defer func() { <-c.workers }()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	c.sums[key] = cachedHash{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	c.mu.Unlock()
+	return sum, nil
+}
+
+// warm hashes files with algo in the background, for --hash-on-scan, using
+// every worker slot it can get. With prune, cached sums for paths not in
+// files are dropped, so a full scan also forgets deleted files. Only one
+// warm runs at a time; files a skipped warm would have covered are hashed
+// by the next scan or when first asked for.
+func (c *hashCache) warm(files []indexedFile, algo string, prune bool) {
+	if !c.warming.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.warming.Store(false)
+
+	if prune {
+		keep := make(map[string]bool, len(files))
+		for _, f := range files {
+			keep[f.path] = true
+		}
+		c.mu.Lock()
+		for key := range c.sums {
+			if !keep[key.path] {
+				delete(c.sums, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	start := time.Now()
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for range cap(c.workers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				if _, err := c.sum(p, algo, nil); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					slog.Warn("Error hashing file", "path", p, "err", err)
+				}
+			}
+		}()
+	}
+	for _, f := range files {
+		if f.d.Type().IsRegular() {
+			paths <- f.path
+		}
+	}
+	close(paths)
+	wg.Wait()
+	slog.Info("Hashed files", "algo", algo, "files", len(files), "duration", time.Since(start))
+}
+
+// indexedFiles returns every file in roots, for warm.
+func indexedFiles(roots map[string][]indexedFile) []indexedFile {
+	var files []indexedFile
+	for _, dir := range slices.Sorted(maps.Keys(roots)) {
+		files = append(files, roots[dir]...)
+	}
+	return files
+}
+
+type HashResponse struct {
+	Host string `json:"host"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Algo string `json:"algo"`
+	Hash string `json:"hash"`
+}
+
+// handleHash returns the content hash of one file, named by its reported
+// path as in /list, with ?algo=sha256 (the default) or xxhash. Sums are
+// cached until the file's size or mtime changes; reading it is charged to
+// the read budget, and a file over budget is refused with 413.
+func handleHash(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "missing 'path' parameter")
+		return
+	}
+	algo := r.URL.Query().Get("algo")
+	if algo == "" {
+		algo = "sha256"
+	}
+	if err := parseHashAlgorithm(algo); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'algo' parameter: %v", err))
+		return
+	}
+
+	real, ok := resolveReportedPath(p)
+	if !ok || !(listOptions{AllowedPrefixes: allowedPrefixes(r)}).allowed(p) {
+		writeError(w, http.StatusForbidden, "path is outside the served directories")
+		return
+	}
+
+	sum, err := contentHashes.sum(real, algo, newReadBudget())
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		writeError(w, http.StatusNotFound, "file not found")
+		return
+	case errors.Is(err, errReadBudgetExceeded):
+		writeError(w, http.StatusRequestEntityTooLarge, "file is larger than --max-read-bytes-per-request")
+		return
+	case err != nil:
+		requestLogger(r).Warn("Error hashing file", "path", real, "err", err)
+		writeError(w, http.StatusForbidden, "file can't be read")
+		return
+	}
+
+	var size int64
+	if info, err := os.Stat(real); err == nil {
+		size = info.Size()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HashResponse{Host: config.FriendlyName, Path: p, Size: size, Algo: algo, Hash: sum})
+}
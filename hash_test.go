@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+func TestHandleHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	movie := filepath.Join(tmpDir, "movie.mkv")
+	os.WriteFile(movie, []byte("hi"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	t.Cleanup(func() { config.MaxReadBytes = 0 })
+
+	tests := []struct {
+		query    string
+		wantCode int
+		wantHash string
+	}{
+		{"path=" + url.QueryEscape(movie), http.StatusOK, "8f434346648f6b96df89dda901c5176b10a6d83961dd3c1ac88b59b2dc327aa4"},
+		{"algo=xxhash&path=" + url.QueryEscape(movie), http.StatusOK, fmt.Sprintf("%016x", xxhash.Sum64String("hi"))},
+		{"algo=md5&path=" + url.QueryEscape(movie), http.StatusBadRequest, ""},
+		{"", http.StatusBadRequest, ""},
+		{"path=" + url.QueryEscape(filepath.Join(tmpDir, "missing.mkv")), http.StatusNotFound, ""},
+		{"path=" + url.QueryEscape(filepath.Join(tmpDir, "..", "etc")), http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleHash(w, httptest.NewRequest(http.MethodGet, "/hash?"+tt.query, nil))
+		if w.Code != tt.wantCode {
+			t.Errorf("%q: expected status %d, got %d: %s", tt.query, tt.wantCode, w.Code, w.Body.String())
+			continue
+		}
+		var resp HashResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Hash != tt.wantHash {
+			t.Errorf("%q: expected hash %q, got %q", tt.query, tt.wantHash, resp.Hash)
+		}
+	}
+
+	// Cached sums cost no budget; a file that's over it and not cached is 413.
+	config.MaxReadBytes = 1
+	w := httptest.NewRecorder()
+	handleHash(w, httptest.NewRequest(http.MethodGet, "/hash?path="+url.QueryEscape(movie), nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected a cached hash with status 200, got %d", w.Code)
+	}
+	other := filepath.Join(tmpDir, "other.mkv")
+	os.WriteFile(other, []byte("hello"), 0644)
+	w = httptest.NewRecorder()
+	handleHash(w, httptest.NewRequest(http.MethodGet, "/hash?path="+url.QueryEscape(other), nil))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 over budget, got %d", w.Code)
+	}
+}
+
+func TestListHashOption(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("hi"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	w := httptest.NewRecorder()
+	handleList(w, httptest.NewRequest(http.MethodGet, "/list?hash=xxhash", nil))
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	want := fmt.Sprintf("xxhash:%016x", xxhash.Sum64String("hi"))
+	if len(resp.Files) != 1 || resp.Files[0].Hash != want {
+		t.Errorf("expected one file with hash %s, got %+v", want, resp.Files)
+	}
+
+	w = httptest.NewRecorder()
+	handleList(w, httptest.NewRequest(http.MethodGet, "/list?hash=md5", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown algorithm, got %d", w.Code)
+	}
+}
+
+func TestHashCacheInvalidation(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "file.bin")
+	os.WriteFile(path, []byte("one"), 0644)
+
+	c := newHashCache(1)
+	first, err := c.sum(path, "sha256", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size, new content and mtime: the cached sum must not be reused.
+	os.WriteFile(path, []byte("two"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	second, err := c.sum(path, "sha256", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("expected a new sum after the file changed, got %s both times", first)
+	}
+
+	files := []indexedFile{{path: path, d: mustDirEntry(t, path)}}
+	c.warm(files, "xxhash", true)
+	if _, ok := c.sums[hashKey{path, "xxhash"}]; !ok {
+		t.Error("expected warm to cache the xxhash sum")
+	}
+	c.warm(nil, "xxhash", true)
+	if len(c.sums) != 0 {
+		t.Errorf("expected a prune with no files to empty the cache, got %d entries", len(c.sums))
+	}
+}
+
+func mustDirEntry(t *testing.T, path string) fs.DirEntry {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs.FileInfoToDirEntry(info)
+}
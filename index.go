@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// indexBatchInterval is how long filesystem events are collected before the
+// index is updated, so a burst of writes to one file costs one re-read.
+var indexBatchInterval = time.Second
+
+// fileIndex is the in-memory result of the background scanner. Each refresh
+// walks the disk once and swaps in a new per-root file list, with file info
+// already fetched, so requests are served without touching the disk.
+type fileIndex struct {
+	ready chan struct{} // closed once the first scan completes
+
+	mu        sync.RWMutex
+	dirs      []string          // the roots to index; replaced by setDirs
+	watcher   *fsnotify.Watcher // set by watch
+	roots     map[string][]indexedFile
+	failures  map[string][]WalkError // what each root's last scan couldn't read
+	scannedAt time.Time
+	scanTook  time.Duration // how long the last full scan took
+	store     *indexStore   // set by open with --index-db
+}
+
+// index is the background index, or nil when --scan-interval isn't set.
+var index *fileIndex
+
+func newFileIndex(dirs []string) *fileIndex {
+	return &fileIndex{dirs: dirs, ready: make(chan struct{})}
+}
+
+// run scans now and then every interval, forever. With a zero interval it
+// scans once, leaving later changes to watch.
+func (ix *fileIndex) run(interval time.Duration) {
+	for {
+		ix.scan()
+		if interval == 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// indexedDirs returns the roots being indexed.
+func (ix *fileIndex) indexedDirs() []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.dirs
+}
+
+// scan walks every root and replaces the index with the result.
+func (ix *fileIndex) scan() {
+	start := time.Now()
+	roots, failures, total := walkRoots(ix.indexedDirs())
+
+	ix.mu.Lock()
+	old, store := ix.roots, ix.store
+	ix.roots, ix.failures, ix.scannedAt, ix.scanTook = roots, failures, time.Now(), time.Since(start)
+	scannedAt := ix.scannedAt
+	ix.mu.Unlock()
+	if store != nil {
+		if err := store.save(roots, scannedAt); err != nil {
+			slog.Warn("Error saving index", "err", err)
+		}
+	}
+	if old != nil {
+		// Catch what the watcher missed, or everything without one.
+		for _, root := range slices.Sorted(maps.Keys(roots)) {
+			if files, ok := old[root]; ok {
+				changes.publish(diffFiles(files, roots[root]))
+			}
+		}
+	}
+	if config.HashOnScan != "" {
+		go contentHashes.warm(indexedFiles(roots), config.HashOnScan, true)
+	}
+
+	select {
+	case <-ix.ready:
+	default:
+		close(ix.ready)
+	}
+	slog.Info("Indexed files", "files", total, "duration", time.Since(start))
+}
+
+// lastScan returns when the index last read the disk, by a full scan or a
+// watch update, or the zero time if ix is nil or hasn't finished a scan.
+func (ix *fileIndex) lastScan() time.Time {
+	if ix == nil {
+		return time.Time{}
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.scannedAt
+}
+
+// rootStat is the indexed file count and total size under one root.
+type rootStat struct {
+	dir   string
+	files int
+	bytes int64
+}
+
+// stats returns the file count and size of each scanned root, in --dir
+// order, and how long the last full scan took. It returns nil and 0 if ix is
+// nil.
+func (ix *fileIndex) stats() ([]rootStat, time.Duration) {
+	if ix == nil {
+		return nil, 0
+	}
+	ix.mu.RLock()
+	dirs, roots, took := ix.dirs, ix.roots, ix.scanTook
+	ix.mu.RUnlock()
+
+	var stats []rootStat
+	for _, dir := range dirs {
+		files, ok := roots[dir]
+		if !ok {
+			continue
+		}
+		st := rootStat{dir: dir, files: len(files)}
+		for _, f := range files {
+			if info, err := f.d.Info(); err == nil {
+				st.bytes += info.Size()
+			}
+		}
+		stats = append(stats, st)
+	}
+	return stats, took
+}
+
+// walkRoots walks each of dirs from disk, fetching file info as it goes, and
+// returns the files under each, what couldn't be read under each, and the
+// total count. With --scan-workers > 1 the dirs are walked at the same time.
+func walkRoots(dirs []string) (map[string][]indexedFile, map[string][]WalkError, int) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		roots    = make(map[string][]indexedFile, len(dirs))
+		failures = make(map[string][]WalkError)
+		total    int
+	)
+	walkOne := func(dir string) {
+		var files []indexedFile
+		errs := &walkErrors{}
+		ctx := context.WithValue(context.Background(), walkErrorsKey{}, errs)
+		walkDisk(ctx, []string{dir}, func(path string, d fs.DirEntry) error {
+			if info, err := d.Info(); err == nil {
+				d = fs.FileInfoToDirEntry(info)
+			}
+			files = append(files, indexedFile{path: path, d: d})
+			return nil
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		roots[dir] = files
+		if list := errs.list(); len(list) > 0 {
+			failures[dir] = list
+		}
+		total += len(files)
+	}
+	for _, dir := range dirs {
+		if config.ScanWorkers <= 1 {
+			walkOne(dir)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			walkOne(dir)
+		}()
+	}
+	wg.Wait()
+	return roots, failures, total
+}
+
+// setDirs switches the index to a reloaded set of roots. Added roots are
+// watched and scanned in the background, and walked from disk until that
+// scan is done; removed roots drop out of the index.
+func (ix *fileIndex) setDirs(dirs []string) {
+	if ix == nil {
+		return
+	}
+
+	ix.mu.Lock()
+	old := ix.dirs
+	ix.dirs = dirs
+	w := ix.watcher
+	ix.mu.Unlock()
+
+	if w != nil {
+		rewatch(w, old, dirs)
+	}
+
+	var added []string
+	for _, dir := range dirs {
+		if !slices.Contains(old, dir) {
+			added = append(added, dir)
+		}
+	}
+
+	go func() {
+		<-ix.ready
+		start := time.Now()
+		fresh, freshFailures, total := walkRoots(added)
+
+		ix.mu.Lock()
+		defer ix.mu.Unlock()
+		roots := make(map[string][]indexedFile, len(ix.dirs))
+		failures := make(map[string][]WalkError)
+		for _, dir := range ix.dirs {
+			if files, ok := fresh[dir]; ok {
+				roots[dir], failures[dir] = files, freshFailures[dir]
+			} else if files, ok := ix.roots[dir]; ok {
+				roots[dir], failures[dir] = files, ix.failures[dir]
+			}
+		}
+		ix.roots, ix.failures = roots, failures
+		slog.Info("Indexed files in added directories", "files", total, "duration", time.Since(start))
+	}()
+}
+
+// walk replays the indexed files under dirs to fn, waiting for the first scan
+// if it hasn't finished, and adds what the last scan of each couldn't read to
+// ctx's walkErrors. It returns false, without calling fn, if ix is nil or
+// any of dirs isn't an indexed root (or is a newly added one not yet
+// scanned); the caller should walk the disk instead.
+func (ix *fileIndex) walk(ctx context.Context, dirs []string, fn walkFunc) (bool, error) {
+	if ix == nil {
+		return false, nil
+	}
+	indexed := ix.indexedDirs()
+	for _, dir := range dirs {
+		if !slices.Contains(indexed, dir) {
+			return false, nil
+		}
+	}
+
+	select {
+	case <-ix.ready:
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+	ix.mu.RLock()
+	roots, failures := ix.roots, ix.failures
+	ix.mu.RUnlock()
+
+	for _, dir := range dirs {
+		if _, ok := roots[dir]; !ok {
+			return false, nil
+		}
+	}
+	for _, dir := range dirs {
+		walkErrorsFrom(ctx).addAll(failures[dir])
+	}
+
+	for _, dir := range dirs {
+		for _, f := range roots[dir] {
+			if err := ctx.Err(); err != nil {
+				return true, err
+			}
+			if err := fn(f.path, f.d); err == fs.SkipAll {
+				return true, nil
+			} else if err != nil {
+				return true, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// watch keeps the index current between scans by watching every root with
+// fsnotify. Changed paths are batched and re-read from disk by update.
+func (ix *fileIndex) watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, dir := range ix.indexedDirs() {
+		if err := watchTree(w, dir); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	ix.mu.Lock()
+	ix.watcher = w
+	ix.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(indexBatchInterval)
+		defer ticker.Stop()
+
+		dirty := make(map[string]bool)
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) && !underExcluded(event.Name, ix.rootOf(event.Name)) {
+					if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+						if err := watchTree(w, event.Name); err != nil {
+							slog.Warn("Error watching new directory", "dir", event.Name, "err", err)
+						}
+					}
+					additions.indexCreated(event.Name)
+				}
+				dirty[event.Name] = true
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Error watching for index updates", "err", err)
+			case <-ticker.C:
+				if len(dirty) > 0 {
+					ix.update(dirty)
+					dirty = make(map[string]bool)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// watching reports whether watch has started, so other watchers can use its
+// events instead. It is false if ix is nil.
+func (ix *fileIndex) watching() bool {
+	if ix == nil {
+		return false
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.watcher != nil
+}
+
+// update replaces the index entries at and beneath each dirty path with what
+// is on disk now: removed paths drop out, and created or modified files (or
+// whole new directories) are walked and added.
+func (ix *fileIndex) update(dirty map[string]bool) {
+	<-ix.ready
+
+	fresh := make(map[string][]indexedFile)
+	for path := range dirty {
+		root := ix.rootOf(path)
+		if root == "" || underDirty(filepath.Dir(path), root, dirty) {
+			continue // outside the index, or re-read with a dirty parent
+		}
+		if underExcluded(path, root) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if config.MaxDepth > 0 {
+			// Files may sit at --max-depth, directories only above it.
+			depth := pathDepth(root, path)
+			if depth > config.MaxDepth || info.IsDir() && depth == config.MaxDepth {
+				continue
+			}
+		}
+		walkDisk(context.Background(), []string{path}, func(p string, d fs.DirEntry) error {
+			if info, err := d.Info(); err == nil {
+				d = fs.FileInfoToDirEntry(info)
+			}
+			fresh[root] = append(fresh[root], indexedFile{path: p, d: d})
+			return nil
+		})
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	// Replace rather than modify, as walk iterates the old map unlocked.
+	roots := maps.Clone(ix.roots)
+	for _, root := range ix.dirs {
+		if _, ok := roots[root]; !ok {
+			continue // added by a reload and not scanned yet
+		}
+		var files, stale []indexedFile
+		for _, f := range roots[root] {
+			if underDirty(f.path, root, dirty) {
+				stale = append(stale, f)
+			} else {
+				files = append(files, f)
+			}
+		}
+		roots[root] = append(files, fresh[root]...)
+		changes.publish(diffFiles(stale, fresh[root]))
+		if ix.store != nil {
+			if err := ix.store.apply(root, stale, fresh[root], time.Now()); err != nil {
+				slog.Warn("Error saving index update", "root", root, "err", err)
+			}
+		}
+	}
+	ix.roots, ix.scannedAt = roots, time.Now()
+	if config.HashOnScan != "" {
+		go contentHashes.warm(indexedFiles(fresh), config.HashOnScan, false)
+	}
+}
+
+// rootOf returns the indexed root containing path, or "".
+func (ix *fileIndex) rootOf(path string) string {
+	for _, dir := range ix.indexedDirs() {
+		if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(rel) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// underDirty reports whether path, or any of its ancestors below root, is in
+// dirty.
+func underDirty(path, root string, dirty map[string]bool) bool {
+	for p := path; len(p) > len(root); p = filepath.Dir(p) {
+		if dirty[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// underExcluded reports whether path, or any of its ancestors below root,
+// is excluded.
+func underExcluded(path, root string) bool {
+	if !excluding() || root == "" {
+		return false
+	}
+	for p := path; len(p) > len(root); p = filepath.Dir(p) {
+		if excluded(p) {
+			return true
+		}
+	}
+	return false
+}
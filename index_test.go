@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileIndexServesLastScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := filepath.Join(tmpDir, "first.mkv")
+	os.WriteFile(first, []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+	index.scan()
+
+	// Changes on disk aren't visible until the next scan, and sizes come
+	// from the index rather than a fresh stat.
+	os.Remove(first)
+	os.WriteFile(filepath.Join(tmpDir, "second.mkv"), []byte("test"), 0644)
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 || resp.Files[0].Name != "first.mkv" || resp.Files[0].Size != 4 {
+		t.Fatalf("expected the indexed first.mkv (4 bytes), got %+v", resp.Files)
+	}
+
+	index.scan()
+	var names []string
+	walkFiles(context.Background(), config.Dirs, func(path string, d fs.DirEntry) error {
+		names = append(names, d.Name())
+		return nil
+	})
+	if len(names) != 1 || names[0] != "second.mkv" {
+		t.Errorf("expected second.mkv after rescan, got %v", names)
+	}
+}
+
+func TestFileIndexFallsBackForOtherDirs(t *testing.T) {
+	indexed, other := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(other, "other.mkv"), []byte("test"), 0644)
+
+	index = newFileIndex([]string{indexed})
+	t.Cleanup(func() { index = nil })
+	index.scan()
+
+	count := 0
+	walkFiles(context.Background(), []string{other}, func(path string, d fs.DirEntry) error {
+		count++
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("expected an unindexed dir to be walked on disk, got %d files", count)
+	}
+}
+
+func TestFileIndexWatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "Show"), 0755)
+	old := filepath.Join(tmpDir, "Show", "old.mkv")
+	os.WriteFile(old, []byte("test"), 0644)
+
+	indexBatchInterval = 50 * time.Millisecond
+	index = newFileIndex([]string{tmpDir})
+	t.Cleanup(func() {
+		index = nil
+		indexBatchInterval = time.Second
+	})
+	if err := index.watch(); err != nil {
+		t.Fatal(err)
+	}
+	index.scan()
+
+	indexed := func() map[string]int64 {
+		files := make(map[string]int64)
+		walkFiles(context.Background(), []string{tmpDir}, func(path string, d fs.DirEntry) error {
+			info, _ := d.Info()
+			rel, _ := filepath.Rel(tmpDir, path)
+			files[filepath.ToSlash(rel)] = info.Size()
+			return nil
+		})
+		return files
+	}
+	waitFor := func(desc string, ok func(map[string]int64) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if ok(indexed()) {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s, index has %v", desc, indexed())
+	}
+
+	os.WriteFile(filepath.Join(tmpDir, "new.mkv"), []byte("new!!"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "Other", "Season 1"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "Other", "Season 1", "e01.mkv"), []byte("e01"), 0644)
+	waitFor("additions", func(files map[string]int64) bool {
+		return files["new.mkv"] == 5 && files["Other/Season 1/e01.mkv"] == 3
+	})
+
+	os.Rename(old, filepath.Join(tmpDir, "Show", "renamed.mkv"))
+	os.WriteFile(filepath.Join(tmpDir, "new.mkv"), []byte("longer now"), 0644)
+	waitFor("rename and modification", func(files map[string]int64) bool {
+		_, hasOld := files["Show/old.mkv"]
+		return !hasOld && files["Show/renamed.mkv"] == 4 && files["new.mkv"] == 10
+	})
+
+	os.RemoveAll(filepath.Join(tmpDir, "Other"))
+	waitFor("removal", func(files map[string]int64) bool {
+		return len(files) == 2
+	})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	rootsBucket  = []byte("roots")
+	metaBucket   = []byte("meta")
+	scannedAtKey = []byte("scanned_at")
+)
+
+// indexStore keeps a copy of the index in a bbolt database (--index-db), so
+// a restart serves the last known files at once instead of waiting for a
+// full scan of every root. Each root is a bucket of path to size, mtime,
+// mode and, where the platform has them, owner, link count, device and
+// inode. Queries still run over the in-memory index loaded from it; sorting,
+// filtering and paging aren't done in the database.
+type indexStore struct {
+	db *bolt.DB
+}
+
+func openIndexStore(path string) (*indexStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &indexStore{db: db}, nil
+}
+
+func (s *indexStore) close() error {
+	return s.db.Close()
+}
+
+// storedInfo is the file info kept for each path. Sys is the stored
+// *fileStat, or nil if there was none (as for archive members), so owner and
+// link filters and inode dedup work on a restored index as on a scanned one.
+type storedInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	stat    *fileStat
+}
+
+// fileStat is the part of a file's platform stat that statOf reports.
+type fileStat struct {
+	dev, ino, nlink uint64
+	uid             uint32
+}
+
+func (i storedInfo) Name() string       { return i.name }
+func (i storedInfo) Size() int64        { return i.size }
+func (i storedInfo) Mode() fs.FileMode  { return i.mode }
+func (i storedInfo) ModTime() time.Time { return i.modTime }
+func (i storedInfo) IsDir() bool        { return i.mode.IsDir() }
+
+func (i storedInfo) Sys() any {
+	if i.stat == nil {
+		return nil
+	}
+	return i.stat
+}
+
+// encodeStoredInfo packs info as size, mtime and mode (20 bytes), followed
+// by device, inode, link count and uid (28 more) if statOf has them.
+func encodeStoredInfo(info fs.FileInfo) []byte {
+	v := binary.BigEndian.AppendUint64(nil, uint64(info.Size()))
+	v = binary.BigEndian.AppendUint64(v, uint64(info.ModTime().UnixNano()))
+	v = binary.BigEndian.AppendUint32(v, uint32(info.Mode()))
+	if st, ok := statOf(info); ok {
+		v = binary.BigEndian.AppendUint64(v, st.dev)
+		v = binary.BigEndian.AppendUint64(v, st.ino)
+		v = binary.BigEndian.AppendUint64(v, st.nlink)
+		v = binary.BigEndian.AppendUint32(v, st.uid)
+	}
+	return v
+}
+
+func decodeStoredInfo(path string, v []byte) (fs.FileInfo, error) {
+	if len(v) != 20 && len(v) != 48 {
+		return nil, errors.New("corrupt index entry")
+	}
+	info := storedInfo{
+		name:    filepath.Base(path),
+		size:    int64(binary.BigEndian.Uint64(v)),
+		modTime: time.Unix(0, int64(binary.BigEndian.Uint64(v[8:]))),
+		mode:    fs.FileMode(binary.BigEndian.Uint32(v[16:])),
+	}
+	if len(v) == 48 {
+		info.stat = &fileStat{
+			dev:   binary.BigEndian.Uint64(v[20:]),
+			ino:   binary.BigEndian.Uint64(v[28:]),
+			nlink: binary.BigEndian.Uint64(v[36:]),
+			uid:   binary.BigEndian.Uint32(v[44:]),
+		}
+	}
+	return info, nil
+}
+
+// load returns the stored files of each of dirs that has been saved, and
+// when they were last read from disk.
+func (s *indexStore) load(dirs []string) (map[string][]indexedFile, time.Time, error) {
+	roots := make(map[string][]indexedFile)
+	var scannedAt time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			scannedAt.UnmarshalBinary(meta.Get(scannedAtKey))
+		}
+		all := tx.Bucket(rootsBucket)
+		if all == nil {
+			return nil
+		}
+		for _, dir := range dirs {
+			b := all.Bucket([]byte(dir))
+			if b == nil {
+				continue
+			}
+			var files []indexedFile
+			err := b.ForEach(func(k, v []byte) error {
+				info, err := decodeStoredInfo(string(k), v)
+				if err != nil {
+					return err
+				}
+				files = append(files, indexedFile{path: string(k), d: fs.FileInfoToDirEntry(info)})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			roots[dir] = files
+		}
+		return nil
+	})
+	return roots, scannedAt, err
+}
+
+// save makes the store hold exactly roots, as after a full scan. Only what
+// differs is written: entries that are gone or changed, new ones, and roots
+// no longer indexed, so a rescan that finds little new touches few pages.
+func (s *indexStore) save(roots map[string][]indexedFile, scannedAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		all, err := tx.CreateBucketIfNotExists(rootsBucket)
+		if err != nil {
+			return err
+		}
+		var dropped [][]byte
+		all.ForEachBucket(func(k []byte) error {
+			if _, ok := roots[string(k)]; !ok {
+				dropped = append(dropped, k)
+			}
+			return nil
+		})
+		for _, k := range dropped {
+			if err := all.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		for dir, files := range roots {
+			b, err := all.CreateBucketIfNotExists([]byte(dir))
+			if err != nil {
+				return err
+			}
+			if err := syncBucket(b, files); err != nil {
+				return err
+			}
+		}
+		return putScannedAt(tx, scannedAt)
+	})
+}
+
+// syncBucket makes b hold exactly files, leaving unchanged entries alone.
+// Files whose info couldn't be read are left out, as by putFile.
+func syncBucket(b *bolt.Bucket, files []indexedFile) error {
+	want := make(map[string][]byte, len(files))
+	for _, f := range files {
+		if info, err := f.d.Info(); err == nil {
+			want[f.path] = encodeStoredInfo(info)
+		}
+	}
+	// Deleting during ForEach isn't allowed, so stale keys are collected first.
+	var stale [][]byte
+	b.ForEach(func(k, v []byte) error {
+		if w, ok := want[string(k)]; !ok {
+			stale = append(stale, k)
+		} else if bytes.Equal(w, v) {
+			delete(want, string(k))
+		}
+		return nil
+	})
+	for _, k := range stale {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	for path, v := range want {
+		if err := b.Put([]byte(path), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply stores a watch update to one root: stale entries are deleted and
+// fresh ones written.
+func (s *indexStore) apply(root string, stale, fresh []indexedFile, scannedAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		all, err := tx.CreateBucketIfNotExists(rootsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := all.CreateBucketIfNotExists([]byte(root))
+		if err != nil {
+			return err
+		}
+		for _, f := range stale {
+			if err := b.Delete([]byte(f.path)); err != nil {
+				return err
+			}
+		}
+		for _, f := range fresh {
+			if err := putFile(b, f); err != nil {
+				return err
+			}
+		}
+		return putScannedAt(tx, scannedAt)
+	})
+}
+
+// putFile stores f, skipping files whose info couldn't be read.
+func putFile(b *bolt.Bucket, f indexedFile) error {
+	info, err := f.d.Info()
+	if err != nil {
+		return nil
+	}
+	return b.Put([]byte(f.path), encodeStoredInfo(info))
+}
+
+func putScannedAt(tx *bolt.Tx, scannedAt time.Time) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	v, _ := scannedAt.MarshalBinary()
+	return meta.Put(scannedAtKey, v)
+}
+
+// close detaches and closes the database attached by open, if any, once
+// writes in progress finish. Later scans and updates are kept in memory only.
+// It does nothing if ix is nil.
+func (ix *fileIndex) close() error {
+	if ix == nil {
+		return nil
+	}
+	ix.mu.Lock()
+	store := ix.store
+	ix.store = nil
+	ix.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+	return store.close()
+}
+
+// open attaches the database at path to ix and, if it holds any of ix's
+// roots, serves them until the first scan replaces them. Roots it doesn't
+// hold are walked from disk until then.
+func (ix *fileIndex) open(path string) error {
+	store, err := openIndexStore(path)
+	if err != nil {
+		return err
+	}
+	roots, scannedAt, err := store.load(ix.indexedDirs())
+	if err != nil {
+		store.close()
+		return err
+	}
+
+	ix.mu.Lock()
+	ix.store = store
+	if len(roots) > 0 {
+		ix.roots, ix.scannedAt = roots, scannedAt
+	}
+	ix.mu.Unlock()
+	if len(roots) > 0 {
+		close(ix.ready)
+		total := 0
+		for _, files := range roots {
+			total += len(files)
+		}
+		slog.Info("Loaded index", "db", path, "files", total, "scanned_at", scannedAt)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexDBRestoresIndex(t *testing.T) {
+	tmpDir := t.TempDir()
+	db := filepath.Join(t.TempDir(), "index.db")
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	kept := filepath.Join(tmpDir, "kept.mkv")
+	os.WriteFile(kept, []byte("kept"), 0644)
+	os.Chtimes(kept, mtime, mtime)
+
+	config.Dirs = []string{tmpDir}
+	t.Cleanup(func() { index = nil })
+
+	index = newFileIndex(config.Dirs)
+	if err := index.open(db); err != nil {
+		t.Fatal(err)
+	}
+	index.scan()
+	added := filepath.Join(tmpDir, "added.mkv")
+	os.WriteFile(added, []byte("added!"), 0644)
+	index.update(map[string]bool{added: true})
+	index.store.close()
+
+	// A restart serves the stored files, including the watch update, before
+	// any scan and without touching the disk.
+	want, _ := os.Lstat(kept)
+	os.Remove(kept)
+	index = newFileIndex(config.Dirs)
+	if err := index.open(db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.store.close() })
+	select {
+	case <-index.ready:
+	default:
+		t.Fatal("expected the loaded index to be ready before a scan")
+	}
+
+	indexed := func() map[string]fs.FileInfo {
+		files := make(map[string]fs.FileInfo)
+		walkFiles(context.Background(), config.Dirs, func(path string, d fs.DirEntry) error {
+			info, _ := d.Info()
+			files[filepath.Base(path)] = info
+			return nil
+		})
+		return files
+	}
+	files := indexed()
+	if len(files) != 2 || files["kept.mkv"] == nil || files["added.mkv"] == nil {
+		t.Fatalf("expected kept.mkv and added.mkv from the database, got %v", files)
+	}
+	if info := files["kept.mkv"]; info.Size() != 4 || !info.ModTime().Equal(mtime) || info.Mode() != want.Mode() {
+		t.Errorf("expected kept.mkv's size, mtime and mode restored, got %d %v %v", info.Size(), info.ModTime(), info.Mode())
+	}
+	if index.lastScan().IsZero() {
+		t.Error("expected the stored scan time to be restored")
+	}
+
+	// The first scan replaces the stored files and saves the result.
+	index.scan()
+	if files := indexed(); len(files) != 1 || files["added.mkv"] == nil {
+		t.Errorf("expected only added.mkv after a rescan, got %v", files)
+	}
+	roots, _, err := index.store.load(config.Dirs)
+	if err != nil || len(roots[tmpDir]) != 1 {
+		t.Errorf("expected one stored file after the rescan, got %v (%v)", roots, err)
+	}
+
+	// Roots no longer configured aren't loaded.
+	if roots, _, _ := index.store.load([]string{t.TempDir()}); len(roots) != 0 {
+		t.Errorf("expected nothing stored for another root, got %v", roots)
+	}
+}
+
+func TestIndexStoreSaveWritesOnlyChanges(t *testing.T) {
+	store, err := openIndexStore(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.close() })
+
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := func(path string, size int64) indexedFile {
+		return indexedFile{path: path, d: fs.FileInfoToDirEntry(storedInfo{name: filepath.Base(path), size: size, mode: 0644, modTime: mtime})}
+	}
+	var files []indexedFile
+	for i := range 2000 {
+		files = append(files, file(fmt.Sprintf("/media/%04d.mkv", i), 4))
+	}
+	writes := func(roots map[string][]indexedFile) int64 {
+		t.Helper()
+		before := store.db.Stats().TxStats
+		if err := store.save(roots, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		after := store.db.Stats().TxStats
+		return after.GetWrite() - before.GetWrite()
+	}
+
+	full := writes(map[string][]indexedFile{"/media": files, "/old": {file("/old/a.mkv", 1)}})
+	files[10] = file(files[10].path, 5)
+	files = append(files[1:], file("/media/new.mkv", 4))
+	if again := writes(map[string][]indexedFile{"/media": files}); again*4 > full {
+		t.Errorf("expected a rescan with three changes to write far less than the first save's %d pages, wrote %d", full, again)
+	}
+
+	roots, _, err := store.load([]string{"/media", "/old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := make(map[string]int64)
+	for _, f := range roots["/media"] {
+		info, _ := f.d.Info()
+		stored[f.path] = info.Size()
+	}
+	if len(stored) != 2000 || stored["/media/0000.mkv"] != 0 || stored["/media/0010.mkv"] != 5 || stored["/media/new.mkv"] != 4 || roots["/old"] != nil {
+		t.Errorf("expected the store to match the second save, got %d files, /old %v", len(stored), roots["/old"])
+	}
+}
+
+func TestServeClosesIndexDB(t *testing.T) {
+	index = newFileIndex([]string{t.TempDir()})
+	t.Cleanup(func() { index = nil })
+	if err := index.open(filepath.Join(t.TempDir(), "index.db")); err != nil {
+		t.Fatal(err)
+	}
+	store := index.store
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	if err := serve([]net.Listener{l}, http.NotFoundHandler(), stop); err != nil {
+		t.Fatal(err)
+	}
+	if index.store != nil || store.save(nil, time.Now()) == nil {
+		t.Error("expected serve to close the index database on shutdown")
+	}
+}
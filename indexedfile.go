@@ -0,0 +1,12 @@
+package main
+
+import "io/fs"
+
+// indexedFile is a file found by a walk and kept for later: by the index and
+// its database, the --min-scan-interval snapshot and the ?sample= reservoir.
+// d usually carries the info fetched during the walk, so it can be replayed
+// without touching the disk.
+type indexedFile struct {
+	path string
+	d    fs.DirEntry
+}
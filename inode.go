@@ -0,0 +1,27 @@
+package main
+
+import "io/fs"
+
+// inodeKey identifies a physical file by device and inode number.
+type inodeKey struct {
+	dev, ino uint64
+}
+
+// inodeSet records which physical files have been seen, so hardlinked files
+// reachable through several paths are only counted once in size totals.
+type inodeSet map[inodeKey]bool
+
+// firstSighting reports whether info refers to a physical file not seen
+// before, and records it. Files without inode information always count as
+// new, so deduplication degrades to plain counting where it's unavailable.
+func (s inodeSet) firstSighting(info fs.FileInfo) bool {
+	key, ok := fileKey(info)
+	if !ok {
+		return true
+	}
+	if s[key] {
+		return false
+	}
+	s[key] = true
+	return true
+}
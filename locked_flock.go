@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// isLocked reports whether another process holds an exclusive flock(2) on
+// path, which is how many writers mark a file as in progress. It is
+// best-effort: writers that don't take advisory locks go undetected.
+func isLocked(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	if err := syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return errors.Is(err, syscall.EWOULDBLOCK)
+	}
+	syscall.Flock(fd, syscall.LOCK_UN)
+	return false
+}
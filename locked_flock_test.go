@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestHandleListLocked(t *testing.T) {
+	tmpDir := t.TempDir()
+	busy := filepath.Join(tmpDir, "busy.mkv")
+	os.WriteFile(busy, []byte("partial"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "idle.mkv"), []byte("done"), 0644)
+
+	f, err := os.Open(busy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Skipf("flock not supported: %v", err)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list?locked=1", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	for _, file := range resp.Files {
+		if want := file.Name == "busy.mkv"; file.Locked != want {
+			t.Errorf("%s: expected locked=%v, got %v", file.Name, want, file.Locked)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+package main
+
+// isLocked always returns false: lock detection isn't supported on this platform.
+func isLocked(path string) bool {
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// setupLogging makes slog's default logger, which the standard log package
+// also writes through, emit format to stderr.
+func setupLogging(format string) error {
+	h, err := newLogHandler(os.Stderr, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// newLogHandler returns a handler writing one record per line to w as
+// format: "text" (key=value) or "json". Durations are logged in seconds.
+func newLogHandler(w io.Writer, format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if a.Value.Kind() == slog.KindDuration {
+			a.Value = slog.Float64Value(a.Value.Duration().Seconds())
+		}
+		return a
+	}}
+
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown format %q (want text or json)", format)
+}
+
+// fatal logs msg and its attributes as an error and exits, for problems that
+// stop the server from starting.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLogger returns the default logger with the request's ID attached,
+// when it has one, so lines logged while serving r can be tied to its
+// access log record.
+func requestLogger(r *http.Request) *slog.Logger {
+	return contextLogger(r.Context())
+}
+
+// contextLogger is requestLogger for code that only has the request's
+// context, such as a walk; outside a request it is the default logger.
+func contextLogger(ctx context.Context) *slog.Logger {
+	if id := requestID(ctx); id != "" {
+		return slog.With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// withAccessLog logs one record per request once it has been served, with
+// its method, path (and query, if any), status, duration and the client's
+// address.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		args := []any{"method", r.Method, "path", r.URL.Path}
+		if r.URL.RawQuery != "" {
+			args = append(args, "query", r.URL.RawQuery)
+		}
+		args = append(args, "status", sw.status, "duration", time.Since(start), "remote_addr", r.RemoteAddr)
+		requestLogger(r).Info("Request", args...)
+	})
+}
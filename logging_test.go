@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := newLogHandler(&buf, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slog.New(h).Info("Indexed files", "files", 3, "duration", 1500*time.Millisecond)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Indexed files" || record["files"] != 3.0 || record["duration"] != 1.5 {
+		t.Errorf("expected the message, attributes and duration in seconds, got %v", record)
+	}
+
+	if _, err := newLogHandler(&buf, "xml"); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+}
@@ -1,58 +1,333 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	pathpkg "path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 type Config struct {
-	Port         int
-	Dirs         []string
-	FriendlyName string
+	Port             int
+	Dirs             []string
+	DirSettings      map[string]dirSettings
+	FriendlyName     string
+	ExpandArchives   bool
+	PathPrefix       string
+	SizeBuckets      string
+	UnixSocket       string
+	BreadthFirst     bool
+	FollowSymlinks   bool
+	MaxReadBytes     int64
+	FieldMap         fieldMap
+	MinScanInterval  time.Duration
+	ScanIORate       int
+	ScanInterval     time.Duration
+	Watch            bool
+	MaxDepth         int
+	Excludes         []string
+	SkipHidden       bool // --include-hidden=false
+	Extensions       []string
+	GzipLevel        int
+	Peers            []string
+	MDNS             bool
+	AuthToken        string
+	BasicAuth        string
+	AuthExemptHealth bool
+	TLSCert          string
+	TLSKey           string
+	TLSSelfSigned    bool
+	ACMEDomains      []string
+	LogFormat        string
+	HashOnScan       string
+	HashWorkers      int
+	ChangeJournal    int
+	SnapshotDir      string
+	IndexDB          string
+	ScanWorkers      int
+	RequestTimeout   time.Duration
+	MaxScans         int
+	RateLimit        float64
+	RateBurst        int
 }
 
 type FileEntry struct {
-	Path string `json:"path"`
-	Name string `json:"name"`
-	Size int64  `json:"size"`
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime,omitzero"` // RFC 3339; omitted with nosize
+	Mode    string    `json:"mode,omitempty"` // permission bits in octal, e.g. "0644"
+	Symlink bool      `json:"symlink,omitempty"`
+	Target  string    `json:"target,omitempty"` // a symlink's target, as stored in the link
+	Parent  string    `json:"parent,omitempty"`
+	Preview string    `json:"preview,omitempty"`
+	Nlink   uint64    `json:"nlink,omitempty"`
+	Match   *[2]int   `json:"match,omitempty"` // byte offsets [start, end) of the match in Name (Path with scope=path)
+	Locked  bool      `json:"locked,omitempty"`
+	Score   int       `json:"score,omitempty"`
+	Hash    string    `json:"hash,omitempty"` // with ?hash=: "<algo>:<hex>"
+	Host    string    `json:"host,omitempty"` // with --peer: the lister the file is on
+
+	Xattrs map[string]string `json:"xattrs,omitempty"`
+	Media  *MediaInfo        `json:"media,omitempty"` // with ?media=1
+
+	// NameHex holds the raw bytes of a name that isn't valid UTF-8, which
+	// JSON would otherwise mangle into U+FFFD.
+	NameHex string `json:"name_hex,omitempty"`
 }
 
 type ListResponse struct {
-	Host  string      `json:"host"`
-	Files []FileEntry `json:"files"`
+	Host       string       `json:"host"`
+	InstanceID string       `json:"instance_id"`
+	Files      []FileEntry  `json:"files"`
+	DeltaFrom  string       `json:"delta_from,omitempty"` // set when Files only holds additions since this version
+	Removed    []string     `json:"removed,omitempty"`
+	Truncated  bool         `json:"truncated,omitempty"` // content reads stopped at --max-read-bytes-per-request
+	Sampled    bool         `json:"sampled,omitempty"`   // Files is a random sample of Scanned files (?sample=N)
+	Scanned    int          `json:"scanned,omitempty"`
+	Total      *int         `json:"total,omitempty"`       // set with ?limit=: number of files across all pages
+	NextOffset int          `json:"next_offset,omitempty"` // ?offset= for the next page; omitted on the last
+	Sort       string       `json:"sort,omitempty"`        // applied ?sort=, e.g. "size:desc"
+	Peers      []PeerStatus `json:"peers,omitempty"`       // with --peer: how each peer answered
+	Errors     []WalkError  `json:"errors,omitempty"`      // paths that couldn't be read
+	Partial    bool         `json:"partial,omitempty"`     // some peer failed or path couldn't be read, so Files is incomplete
+}
+
+// VersionResponse is the /version summary: enough for a poller to decide
+// whether the full listing is worth fetching.
+type VersionResponse struct {
+	Host      string    `json:"host"`
+	Version   string    `json:"version"`    // same hash as X-Content-Version on /list
+	ScannedAt time.Time `json:"scanned_at"` // when the files were read from disk
+	Files     int       `json:"files"`
+}
+
+type HealthResponse struct {
+	Status     string `json:"status"`
+	Host       string `json:"host"`
+	InstanceID string `json:"instance_id"`
+	Version    string `json:"version"`
+}
+
+type ErrorResponse struct {
+	Error  string      `json:"error"`
+	Status int         `json:"status"`
+	Errors []WalkError `json:"errors,omitempty"` // with ?strict=1: the paths that couldn't be read
 }
 
-type dirFlag []string
+// stringsFlag collects the values of a repeatable flag.
+type stringsFlag []string
 
-func (d *dirFlag) String() string { return fmt.Sprintf("%v", *d) }
-func (d *dirFlag) Set(value string) error {
+func (d *stringsFlag) String() string { return fmt.Sprintf("%v", *d) }
+func (d *stringsFlag) Set(value string) error {
 	*d = append(*d, value)
 	return nil
 }
 
 var config Config
 
+// instanceID identifies this process. Unlike FriendlyName it is unique per
+// run, so aggregators can tell apart two instances that share a name.
+var instanceID = newInstanceID()
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+// waitPollInterval is how often a ?wait-for-change request re-checks the
+// version when there is no index to say when files change. Each check walks
+// every root, so it is long.
+var waitPollInterval = 30 * time.Second
+
 func main() {
-	var dirs dirFlag
+	var dirs stringsFlag
 
 	flag.IntVar(&config.Port, "port", 8080, "Port to listen on")
-	flag.Var(&dirs, "dir", "Directory to scan (can be specified multiple times)")
+	flag.Var(&dirs, "dir", "Directory to scan (can be specified multiple times); append ,workers=N to scan it with N concurrent workers, ,mounts=skip to stay on the root's filesystem")
 	flag.StringVar(&config.FriendlyName, "friendlyname", "", "Friendly name for this host (defaults to hostname)")
+	flag.StringVar(&config.UnixSocket, "unix-socket", "", "Listen on this Unix domain socket (instead of TCP, unless --port is also given)")
+	flag.StringVar(&config.PathPrefix, "path-prefix", "", "Prefix prepended to every reported file path (e.g. /remote/hostA)")
+	flag.StringVar(&config.SizeBuckets, "size-buckets", "1MB,100MB,1GB", "Comma-separated size boundaries for /size-histogram")
+	flag.Var((*stringsFlag)(&config.Excludes), "exclude", "Glob matched against each file and directory's name and full path; matches are skipped and directories pruned (repeatable), e.g. .git or @eaDir")
+	flag.Var((*stringsFlag)(&config.Extensions), "ext", "Only list files with this extension, e.g. mkv or tar.gz (repeatable); other files are skipped without a stat")
+	includeHidden := flag.Bool("include-hidden", true, "List dotfiles and walk dot-directories; with --include-hidden=false they are skipped and hidden directories never read")
+	flag.IntVar(&config.MaxDepth, "max-depth", 0, "Only list files at most this many levels below each --dir (1 = files directly in it); 0 = unlimited")
+	flag.BoolVar(&config.BreadthFirst, "breadth-first", false, "Walk directories breadth-first so shallower files are listed before deeper ones")
+	flag.BoolVar(&config.FollowSymlinks, "follow-symlinks", false, "Follow symbolic links: list linked files with their target's size and walk linked directories, entering each directory once")
+	maxReadBytes := flag.String("max-read-bytes-per-request", "0", "Cap on file content read by one request (previews, hashes, verification), e.g. 10GB; 0 = unlimited")
+	fieldMapSpec := flag.String("fieldmap", "", "Default renaming of file entry JSON keys, e.g. path:filepath,name:filename,size:bytes")
+	flag.DurationVar(&config.ScanInterval, "scan-interval", 0, "Serve requests from an in-memory index refreshed in the background this often (e.g. 5m); 0 walks the disk on every request")
+	flag.BoolVar(&config.Watch, "watch", false, "Keep the in-memory index current with filesystem notifications (implies an index; combine with --scan-interval for periodic full rescans too)")
+	flag.StringVar(&config.IndexDB, "index-db", "", "Keep the index in this database file so a restart serves it at once while rescanning (needs --scan-interval or --watch)")
+	flag.DurationVar(&config.MinScanInterval, "min-scan-interval", 0, "Minimum time between real walks for /list; requests in between get the previous result (e.g. 30s)")
+	flag.IntVar(&config.MaxScans, "max-concurrent-scans", 0, "Disk walks allowed at once, across requests and index scans; others wait their turn (0 = unlimited)")
+	flag.Float64Var(&config.RateLimit, "rate-limit", 0, "Requests per second allowed from each client IP, e.g. 5 or 0.5; 0 = unlimited")
+	flag.IntVar(&config.RateBurst, "rate-burst", 20, "Requests a client may make at once before --rate-limit applies")
+	flag.DurationVar(&config.RequestTimeout, "request-timeout", 0, "Stop a request's walk and answer 503 once it has run this long (e.g. 2m); 0 = no limit. Streams and long-polls are exempt")
+	flag.IntVar(&config.ScanWorkers, "scan-workers", 1, "Directories read at once per --dir without its own workers=N; above 1, the --dir roots are also walked at the same time")
+	flag.IntVar(&config.ScanIORate, "scan-io-rate", 0, "Pace directory walks to at most this many entries per second across all requests; 0 = unlimited")
+	flag.Var((*stringsFlag)(&config.Peers), "peer", "Base URL of another lister (repeatable); /list and /filter then also return its files, tagged by host")
+	flag.BoolVar(&config.MDNS, "mdns", false, "Advertise this lister via mDNS (_fslister._tcp) and discover others, which /list and /filter then query like --peer")
+	flag.StringVar(&config.AuthToken, "auth-token", "", "Require this token on every request, as \"Authorization: Bearer <token>\" or an X-API-Key header")
+	flag.StringVar(&config.BasicAuth, "basic-auth", "", "Require HTTP basic auth with this user:password on every request (either credential is accepted when combined with --auth-token)")
+	flag.BoolVar(&config.AuthExemptHealth, "auth-exempt-health", false, "Serve /health without authentication, for load balancer probes")
+	flag.IntVar(&config.GzipLevel, "gzip-level", 6, "gzip level (1-9) for responses to clients that accept it; 0 = no compression")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "Serve HTTPS on the TCP port with this PEM certificate (needs --tls-key)")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "PEM private key for --tls-cert")
+	flag.BoolVar(&config.TLSSelfSigned, "tls-self-signed", false, "Serve HTTPS with a self-signed certificate, generated on first start at --tls-cert/--tls-key or in the user cache directory")
+	flag.Var((*stringsFlag)(&config.ACMEDomains), "acme-domain", "Serve HTTPS with a Let's Encrypt certificate for this domain (repeatable); the TCP port must be reachable from the internet as 443")
+	flag.StringVar(&config.LogFormat, "log-format", "text", "Log format: text (key=value) or json, one record per line on stderr")
+	flag.StringVar(&config.HashOnScan, "hash-on-scan", "", "Hash every indexed file with this algorithm (sha256 or xxhash) after each background scan, so ?hash= and /hash are served from cache")
+	flag.IntVar(&config.HashWorkers, "hash-workers", 2, "Files hashed at once, across requests and background scans")
+	flag.IntVar(&config.ChangeJournal, "change-journal", 10000, "Index changes kept for /changes and reconnecting /events clients")
+	flag.StringVar(&config.SnapshotDir, "snapshot-dir", "", "Directory where POST /snapshot saves file listings for /diff?from=&to=")
+	flag.BoolVar(&config.ExpandArchives, "expand-archives", false, "List the contents of .zip files as if they were directories")
+	flag.String("config", "", "TOML file setting any of these options by flag name, e.g. port = 8080 or dir = [\"/media\"]; FSLISTER_* environment variables override it and flags override both")
 	flag.Parse()
 
-	config.Dirs = dirs
+	if err := applyConfigSources(flag.CommandLine, os.LookupEnv); err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	if err := setupLogging(config.LogFormat); err != nil {
+		fatal("Invalid --log-format", "err", err)
+	}
+
+	var err error
+	config.Dirs, config.DirSettings, err = parseDirs(dirs)
+	if err != nil {
+		fatal("Invalid --dir", "err", err)
+	}
+
+	n, err := parseByteSize(*maxReadBytes)
+	if err != nil {
+		fatal("Invalid --max-read-bytes-per-request", "err", err)
+	}
+	config.MaxReadBytes = n
+
+	config.SkipHidden = !*includeHidden
+
+	config.FieldMap, err = parseFieldMap(*fieldMapSpec)
+	if err != nil {
+		fatal("Invalid --fieldmap", "err", err)
+	}
+
+	for _, pattern := range config.Excludes {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fatal("Invalid --exclude", "pattern", pattern, "err", err)
+		}
+	}
+
+	if config.MaxDepth < 0 {
+		fatal("Invalid --max-depth", "value", config.MaxDepth)
+	}
+
+	for _, peer := range config.Peers {
+		if err := validPeerURL(peer); err != nil {
+			fatal("Invalid --peer", "url", peer, "err", err)
+		}
+	}
+
+	if config.BasicAuth != "" {
+		if user, _, ok := strings.Cut(config.BasicAuth, ":"); !ok || user == "" {
+			fatal("Invalid --basic-auth: want user:password")
+		}
+	}
+
+	if config.GzipLevel < 0 || config.GzipLevel > 9 {
+		fatal("Invalid --gzip-level (want 0-9)", "value", config.GzipLevel)
+	}
+
+	if config.MaxScans < 0 {
+		fatal("Invalid --max-concurrent-scans", "value", config.MaxScans)
+	}
+	if config.MaxScans > 0 {
+		scanSlots = make(chan struct{}, config.MaxScans)
+	}
+	if config.RateLimit < 0 || config.RateLimit > 0 && config.RateBurst < 1 {
+		fatal("Invalid --rate-limit or --rate-burst", "rate", config.RateLimit, "burst", config.RateBurst)
+	}
+	if config.RateLimit > 0 {
+		rateLimiter = newClientLimiter(config.RateLimit, config.RateBurst)
+	}
+	if config.RequestTimeout < 0 {
+		fatal("Invalid --request-timeout", "value", config.RequestTimeout.String())
+	}
+	if config.ScanWorkers < 1 {
+		fatal("Invalid --scan-workers (want at least 1)", "value", config.ScanWorkers)
+	}
+	if config.ScanIORate < 0 {
+		fatal("Invalid --scan-io-rate", "value", config.ScanIORate)
+	}
+	if config.ScanIORate > 0 {
+		scanPacer = newPacer(config.ScanIORate)
+		slog.Info("Pacing scans", "entries_per_second", config.ScanIORate)
+	}
 
-	if len(config.Dirs) == 0 {
-		log.Fatal("At least one --dir must be specified")
+	if config.HashWorkers < 1 {
+		fatal("Invalid --hash-workers (want at least 1)", "value", config.HashWorkers)
+	}
+	contentHashes = newHashCache(config.HashWorkers)
+	if config.HashOnScan != "" {
+		if err := parseHashAlgorithm(config.HashOnScan); err != nil {
+			fatal("Invalid --hash-on-scan", "err", err)
+		}
+		if config.ScanInterval <= 0 && !config.Watch {
+			fatal("--hash-on-scan needs an index (--scan-interval or --watch)")
+		}
+	}
+
+	if config.ScanInterval < 0 {
+		fatal("Invalid --scan-interval", "value", config.ScanInterval.String())
+	}
+	if config.ChangeJournal < 1 {
+		fatal("Invalid --change-journal (want at least 1)", "value", config.ChangeJournal)
+	}
+	changes = newChangeFeed(config.ChangeJournal)
+
+	if config.IndexDB != "" && config.ScanInterval <= 0 && !config.Watch {
+		fatal("--index-db needs an index (--scan-interval or --watch)")
+	}
+	if config.ScanInterval > 0 || config.Watch {
+		index = newFileIndex(config.Dirs)
+		if config.IndexDB != "" {
+			if err := index.open(config.IndexDB); err != nil {
+				fatal("Error opening --index-db", "path", config.IndexDB, "err", err)
+			}
+		}
+		if config.Watch {
+			if err := index.watch(); err != nil {
+				fatal("Error watching directories", "err", err)
+			}
+		}
+		go index.run(config.ScanInterval)
+	}
+
+	if _, err := parseSizeBuckets(config.SizeBuckets); err != nil {
+		fatal("Invalid --size-buckets", "err", err)
 	}
 
 	if config.FriendlyName == "" {
@@ -64,140 +339,1366 @@ func main() {
 		}
 	}
 
-	http.HandleFunc("/list", handleList)
-	http.HandleFunc("/filter", handleFilter)
-	http.HandleFunc("/health", handleHealth)
+	registerRoutes(http.DefaultServeMux)
+
+	// With --unix-socket, TCP is only used if --port was also given explicitly.
+	listenTCP := config.UnixSocket == ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "port" {
+			listenTCP = true
+		}
+	})
+
+	tlsConf, err := tlsConfig()
+	if err != nil {
+		fatal("Invalid TLS configuration", "err", err)
+	}
+	if tlsConf != nil && !listenTCP {
+		fatal("TLS needs a TCP --port; the Unix socket is always plain HTTP")
+	}
+
+	var listeners []net.Listener
+	if config.UnixSocket != "" {
+		l, err := listenUnix(config.UnixSocket)
+		if err != nil {
+			fatal("Error listening", "addr", "unix:"+config.UnixSocket, "err", err)
+		}
+		slog.Info("Starting filesystem-lister", "addr", "unix:"+config.UnixSocket, "host", config.FriendlyName)
+		listeners = append(listeners, l)
+	}
+	if listenTCP {
+		addr := fmt.Sprintf(":%d", config.Port)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			fatal("Error listening", "addr", addr, "err", err)
+		}
+		if tlsConf != nil {
+			l = tls.NewListener(l, tlsConf)
+		}
+		slog.Info("Starting filesystem-lister", "addr", addr, "host", config.FriendlyName)
+		listeners = append(listeners, l)
+	}
+	slog.Info("Scanning directories", "dirs", config.Dirs)
+
+	if config.MDNS {
+		if !listenTCP {
+			fatal("--mdns needs a TCP --port to advertise")
+		}
+		server, err := advertise(config.Port, tlsConf != nil)
+		if err != nil {
+			fatal("Error advertising via mDNS", "err", err)
+		}
+		defer server.Shutdown()
+		go discovered.browse()
+	}
 
-	addr := fmt.Sprintf(":%d", config.Port)
-	log.Printf("Starting filesystem-lister on %s (host: %s)", addr, config.FriendlyName)
-	log.Printf("Scanning directories: %v", config.Dirs)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	// SIGHUP re-reads the --config file and environment for a new --dir list.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := reloadConfig(flag.CommandLine, os.LookupEnv); err != nil {
+				slog.Error("Reload failed, keeping the current directories", "err", err)
+			}
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	handler := withRateLimit(withAuth(withGzip(withRequestTimeout(withWalkErrors(http.DefaultServeMux)))))
+	handler = withRequestID(withAccessLog(withMetrics(http.DefaultServeMux, handler)))
+	if err := serve(listeners, handler, stop); err != nil {
+		fatal("Server error", "err", err)
+	}
+	slog.Info("Shut down")
+}
+
+// listenUnix listens on a Unix domain socket at path, first removing a stale
+// socket left behind by an unclean exit. Anything other than a socket at
+// path is left alone and reported as an error.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
+	version := computeVersion(r.Context())
+	if walkCancelled(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:     "ok",
+		Host:       config.FriendlyName,
+		InstanceID: instanceID,
+		Version:    version,
+	})
+}
+
+// handleVersion reports the content version, the number of files it covers
+// and when they were scanned. Without an index the disk is walked for the
+// request, so that time is now.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	scannedAt := time.Now()
+	var paths []string
+	walkFiles(r.Context(), configuredDirs(), func(path string, d fs.DirEntry) error {
+		paths = append(paths, path)
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+	if t := index.lastScan(); !t.IsZero() {
+		scannedAt = t
+	}
+
+	version := hashPaths(paths)
+	w.Header().Set("X-Content-Version", version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "ok",
-		"host":    config.FriendlyName,
-		"version": computeVersion(),
+	json.NewEncoder(w).Encode(VersionResponse{
+		Host:      config.FriendlyName,
+		Version:   version,
+		ScannedAt: scannedAt.UTC(),
+		Files:     len(paths),
 	})
 }
 
 func handleList(w http.ResponseWriter, r *http.Request) {
-	var files []FileEntry
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	for _, dir := range config.Dirs {
-		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				log.Printf("Error accessing %s: %v", path, err)
-				return nil
-			}
+	if token := r.URL.Query().Get("wait-for-change"); token != "" {
+		version, err := waitForChange(r, token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("X-Content-Version", version)
+		if version == token {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
 
-			if d.IsDir() {
-				return nil
-			}
+	if v := r.URL.Query().Get("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'sample' parameter: %q", v))
+			return
+		}
+		if r.URL.Query().Get("delta-from") != "" {
+			writeError(w, http.StatusBadRequest, "'sample' cannot be combined with 'delta-from'")
+			return
+		}
 
-			info, err := d.Info()
-			if err != nil {
-				log.Printf("Error getting info for %s: %v", path, err)
-				return nil
-			}
+		files, scanned := sampleFiles(r.Context(), n, opts)
+		if walkCancelled(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		writeListResponse(w, r, ListResponse{
+			Host:       config.FriendlyName,
+			InstanceID: instanceID,
+			Files:      files,
+			Sampled:    true,
+			Scanned:    scanned,
+		}, opts)
+		return
+	}
 
-			files = append(files, FileEntry{
-				Path: path,
-				Name: d.Name(),
-				Size: info.Size(),
-			})
+	var files []FileEntry
+	var scanned, reported []string
 
+	age, _ := walkFilesAged(withoutFileInfo(r.Context(), opts.needsInfo()), opts.Dirs, func(path string, d fs.DirEntry) error {
+		rp := reportedPath(path)
+		scanned = append(scanned, path)
+		reported = append(reported, rp)
+		if !opts.allowed(rp) {
 			return nil
-		})
+		}
+
+		entry, err := newFileEntry(path, d, opts)
 		if err != nil {
-			log.Printf("Error walking directory %s: %v", dir, err)
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
 		}
+
+		files = append(files, entry)
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+	if config.MinScanInterval > 0 {
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
 	}
 
 	response := ListResponse{
-		Host:  config.FriendlyName,
-		Files: files,
+		Host:       config.FriendlyName,
+		InstanceID: instanceID,
+		Files:      files,
+	}
+
+	version := hashPaths(scanned)
+	if from := r.URL.Query().Get("delta-from"); from != "" {
+		if previous, ok := listHistory.get(from); ok {
+			response.Files, response.Removed = diffEntries(opts.allowedPaths(previous), files)
+			response.DeltaFrom = from
+		}
+	}
+	listHistory.add(version, reported)
+	if response.DeltaFrom == "" {
+		fanOut(r, &response)
 	}
 
+	w.Header().Set("X-Content-Version", version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	writeListResponse(w, r, response, opts)
+}
+
+// waitForChange blocks until the content version differs from token, the
+// ?timeout= duration elapses (default 30s, capped at maxWaitTimeout), the
+// client goes away or the server starts shutting down. It returns the latest
+// version seen. With an index the version is re-checked whenever the change
+// feed reports something; without one, every waitPollInterval.
+func waitForChange(r *http.Request, token string) (string, error) {
+	timeout := defaultWaitTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return "", fmt.Errorf("invalid 'timeout' parameter: %q", t)
+		}
+		timeout = min(d, maxWaitTimeout)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	var changed <-chan ChangeEvent
+	var tick <-chan time.Time
+	if index != nil {
+		ch := changes.subscribe()
+		defer changes.unsubscribe(ch)
+		changed = ch
+	} else {
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		version := computeVersion(r.Context())
+		if version != token {
+			return version, nil
+		}
+
+		select {
+		case <-changed:
+		case <-tick:
+		case <-deadline.C:
+			return version, nil
+		case <-r.Context().Done():
+			return version, nil
+		case <-shutdownStarted(r.Context()):
+			return version, nil
+		}
+	}
+}
+
+// writeListResponse encodes a /list or /filter response, applying the
+// request's sort order, pagination, path separator, field renaming and
+// format, and sends it with an ETag (see writeWithETag). Truncated is set if reading
+// content (?hash=, ?preview=) ran out of read budget. Paths the request's
+// walks couldn't read are added to Errors and make the listing Partial, or
+// with ?strict=1 turn it into a 503.
+func writeListResponse(w http.ResponseWriter, r *http.Request, resp ListResponse, opts listOptions) {
+	if errs := walkErrorsFrom(r.Context()).list(); len(errs) > 0 {
+		if resp.Peers != nil {
+			for i := range errs {
+				errs[i].Host = resp.Host
+			}
+		}
+		resp.Errors = append(errs, resp.Errors...)
+	}
+	if len(resp.Errors) > 0 {
+		resp.Partial = true
+	}
+	if resp.Partial && opts.Strict {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:  "listing incomplete: some directories or peers couldn't be read",
+			Status: http.StatusServiceUnavailable,
+			Errors: resp.Errors,
+		})
+		return
+	}
+
+	resp.Truncated = resp.Truncated || opts.Budget.Exhausted()
+	opts.sortFiles(&resp)
+	opts.paginate(&resp)
+	opts.applySeparator(&resp)
+
+	var body bytes.Buffer
+	switch {
+	case opts.Format == "csv":
+		body.Write(encodeDelimited(resp, opts, ','))
+	case opts.Format == "tsv":
+		body.Write(encodeDelimited(resp, opts, '\t'))
+	case opts.Format == "xml":
+		body.Write(encodeXML(resp))
+	case opts.FieldMap == nil:
+		json.NewEncoder(&body).Encode(resp)
+	default:
+		mapped := mappedListResponse{ListResponse: resp, Files: make([]mappedEntry, len(resp.Files))}
+		for i, f := range resp.Files {
+			mapped.Files[i] = mappedEntry{entry: f, fields: opts.FieldMap}
+		}
+		json.NewEncoder(&body).Encode(mapped)
+	}
+	if ct, ok := listFormatTypes[opts.Format]; ok {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Add("Vary", "Accept")
+	writeWithETag(w, r, body.Bytes())
 }
 
 func handleFilter(w http.ResponseWriter, r *http.Request) {
-	pattern := r.URL.Query().Get("q")
-	if pattern == "" {
-		http.Error(w, "missing 'q' parameter", http.StatusBadRequest)
+	matcher, err := parseNameQuery(r.URL.Query()["q"], r.URL.Query().Get("mode"), r.URL.Query().Get("op"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ext := r.URL.Query().Get("ext")
+	uidParam := r.URL.Query().Get("uid")
+	extsParam := r.URL.Query().Get("exts")
+	invalidUTF8 := queryFlag(r, "invalidutf8")
+	ranges, err := parseRangeFilter(r, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	media, err := parseMediaFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if matcher.empty() && ext == "" && uidParam == "" && extsParam == "" && !invalidUTF8 && !ranges.active() && !media.active() {
+		writeError(w, http.StatusBadRequest, "missing 'q' parameter")
 		return
 	}
 
+	previewBytes := 0
+	if p := r.URL.Query().Get("preview"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'preview' parameter: %q", p))
+			return
+		}
+		previewBytes = min(n, maxPreviewBytes)
+	}
+
+	var exts []string
+	if extsParam != "" {
+		var err error
+		if exts, err = parseExtensionSet(extsParam); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'exts' parameter: %v", err))
+			return
+		}
+	}
+
+	var uid uint32
+	if uidParam != "" {
+		if !ownerSupported {
+			writeError(w, http.StatusNotImplemented, "'uid' is not supported on this platform")
+			return
+		}
+		n, err := strconv.ParseUint(uidParam, 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'uid' parameter: %q", uidParam))
+			return
+		}
+		uid = uint32(n)
+	}
+
+	// scope=path matches q against the whole reported path, with '/'
+	// separators on every platform, instead of the base name.
+	var scopePath bool
+	switch scope := r.URL.Query().Get("scope"); scope {
+	case "", "name":
+	case "path":
+		scopePath = true
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'scope' parameter: %q (want name or path)", scope))
+		return
+	}
+
+	highlight := queryFlag(r, "highlight")
+	rank := queryFlag(r, "rank") && !matcher.empty()
+
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	var files []FileEntry
 
-	for _, dir := range config.Dirs {
-		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil || d.IsDir() {
+	ctx := withoutFileInfo(r.Context(), opts.needsInfo() || uidParam != "" || ranges.active())
+	walkFiles(ctx, opts.Dirs, func(path string, d fs.DirEntry) error {
+		subject := d.Name()
+		if scopePath {
+			subject = filepath.ToSlash(reportedPath(path))
+		}
+		if !matcher.empty() && !matcher.match(subject) {
+			return nil
+		}
+		if ext != "" && !matchExtension(d.Name(), ext) {
+			return nil
+		}
+		if exts != nil && !slices.ContainsFunc(exts, func(e string) bool { return matchExtension(d.Name(), e) }) {
+			return nil
+		}
+		if invalidUTF8 && utf8.ValidString(d.Name()) {
+			return nil
+		}
+		if media.active() && !media.match(parseMediaName(d.Name())) {
+			return nil
+		}
+		if !opts.allowed(reportedPath(path)) {
+			return nil
+		}
+		if uidParam != "" || ranges.active() {
+			info, err := d.Info()
+			if err != nil {
 				return nil
 			}
-
-			if matchPattern(d.Name(), pattern) {
-				info, _ := d.Info()
-				size := int64(0)
-				if info != nil {
-					size = info.Size()
+			if uidParam != "" {
+				if owner, ok := fileOwner(info); !ok || owner != uid {
+					return nil
 				}
-				files = append(files, FileEntry{
-					Path: path,
-					Name: d.Name(),
-					Size: size,
-				})
 			}
+			if !ranges.match(info) {
+				return nil
+			}
+		}
+
+		entry, err := newFileEntry(path, d, opts)
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
 			return nil
-		})
+		}
+		if invalidUTF8 {
+			entry.NameHex = hex.EncodeToString([]byte(d.Name()))
+		}
+		if previewBytes > 0 {
+			entry.Preview, _ = readPreview(path, previewBytes, opts.Budget)
+		}
+		if highlight && !matcher.empty() {
+			if start, end, ok := matcher.offsets(subject); ok {
+				entry.Match = &[2]int{start, end}
+			}
+		}
+		if rank {
+			entry.Score = matcher.score(subject)
+		}
+		files = append(files, entry)
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	response := ListResponse{Host: config.FriendlyName, InstanceID: instanceID, Files: files}
+	fanOut(r, &response)
+	if rank {
+		sortByScore(response.Files)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(ListResponse{Host: config.FriendlyName, Files: files})
+	writeListResponse(w, r, response, opts)
+}
+
+// listOptions holds the per-request options shared by /list and /filter.
+type listOptions struct {
+	NoSize bool // skip the per-file stat and report Size as 0
+	Parent bool // include the name of each file's containing directory
+	Links  bool // include the hard link count (Unix only)
+	Locked bool // best-effort check for files locked by a writer
+	Xattrs bool // include extended attributes where supported
+	Strict bool // answer 503 instead of a partial listing (?strict=1)
+	Media  bool // include what parseMediaName makes of each name
+
+	// Hash, when set, adds each file's content hash with this algorithm.
+	Hash string
+
+	// Budget is charged for reading file content (hashes, previews).
+	Budget *readBudget
+
+	// Sep, when set, replaces the separators in reported paths with '/' or '\'.
+	Sep byte
+
+	// FieldMap renames FileEntry keys in the response (?fieldmap= or --fieldmap).
+	FieldMap fieldMap
+
+	// Format is the response encoding: "" for JSON, or a listFormatTypes key.
+	Format string
+
+	// AllowedPrefixes restricts results to paths under these prefixes, as set
+	// by an auth proxy in X-Allowed-Prefixes. nil means no restriction.
+	AllowedPrefixes []string
+
+	// Limit and Offset select one page of the results; Limit 0 means all.
+	Limit, Offset int
+
+	// Sort orders the results by "name", "path", "size" or "mtime"; empty
+	// keeps the walk (or rank) order.
+	Sort string
+	Desc bool
+
+	// Dirs are the configured directories to walk: all of them, or those
+	// picked with ?dir=.
+	Dirs []string
+}
+
+// needsInfo reports whether building an entry requires a stat call.
+func (o listOptions) needsInfo() bool {
+	return !o.NoSize || o.Links || o.Sort == "mtime"
 }
 
-// matchPattern does DOS-style wildcard matching (case-insensitive)
-// *word* = contains, word* = prefix, *word = suffix, word = exact
+func parseListOptions(r *http.Request) (listOptions, error) {
+	opts := listOptions{
+		NoSize:          queryFlag(r, "nosize"),
+		Parent:          queryFlag(r, "parent"),
+		Links:           queryFlag(r, "links"),
+		Locked:          queryFlag(r, "locked"),
+		Xattrs:          queryFlag(r, "xattrs"),
+		Strict:          queryFlag(r, "strict"),
+		Media:           queryFlag(r, "media"),
+		AllowedPrefixes: allowedPrefixes(r),
+		Budget:          newReadBudget(),
+		Dirs:            configuredDirs(),
+	}
+
+	if want := r.URL.Query()["dir"]; len(want) > 0 {
+		dirs, err := selectDirs(want)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'dir' parameter: %v", err)
+		}
+		opts.Dirs = dirs
+	}
+
+	if opts.Hash = r.URL.Query().Get("hash"); opts.Hash != "" {
+		if err := parseHashAlgorithm(opts.Hash); err != nil {
+			return opts, fmt.Errorf("invalid 'hash' parameter: %v", err)
+		}
+	}
+
+	opts.FieldMap = config.FieldMap
+	if spec := r.URL.Query().Get("fieldmap"); spec != "" {
+		m, err := parseFieldMap(spec)
+		if err != nil {
+			return opts, fmt.Errorf("invalid 'fieldmap' parameter: %v", err)
+		}
+		opts.FieldMap = m
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &opts.Limit}, {"offset", &opts.Offset}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return opts, fmt.Errorf("invalid '%s' parameter: %q", p.name, v)
+			}
+			*p.dst = n
+		}
+	}
+
+	switch opts.Sort = r.URL.Query().Get("sort"); opts.Sort {
+	case "", "name", "path", "mtime":
+	case "size":
+		if opts.NoSize {
+			return opts, fmt.Errorf("'sort=size' cannot be combined with 'nosize'")
+		}
+	default:
+		return opts, fmt.Errorf("invalid 'sort' parameter: %q (want name, path, size or mtime)", opts.Sort)
+	}
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("invalid 'order' parameter: %q (want asc or desc)", order)
+	}
+
+	switch opts.Format = r.URL.Query().Get("format"); opts.Format {
+	case "":
+		if prefersXML(r.Header.Get("Accept")) {
+			opts.Format = "xml"
+		}
+	case "json":
+		opts.Format = ""
+	case "csv", "tsv", "xml":
+	default:
+		return opts, fmt.Errorf("invalid 'format' parameter: %q (want json, csv, tsv or xml)", opts.Format)
+	}
+
+	switch sep := r.URL.Query().Get("sep"); sep {
+	case "":
+	case "/", "\\":
+		opts.Sep = sep[0]
+	default:
+		return opts, fmt.Errorf("invalid 'sep' parameter: %q (want / or \\)", sep)
+	}
+
+	return opts, nil
+}
+
+// sortFiles orders resp.Files by the requested key, breaking ties by path so
+// the order is total and pages don't overlap. Names compare
+// case-insensitively.
+func (o listOptions) sortFiles(resp *ListResponse) {
+	if o.Sort == "" {
+		return
+	}
+
+	compare := func(a, b FileEntry) int {
+		var c int
+		switch o.Sort {
+		case "name":
+			c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "mtime":
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Path, b.Path)
+		}
+		if o.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(resp.Files, compare)
+
+	resp.Sort = o.Sort + ":asc"
+	if o.Desc {
+		resp.Sort = o.Sort + ":desc"
+	}
+}
+
+// paginate cuts resp.Files down to the requested page and records the total
+// and the offset of the next page. Pages follow the response's order, which
+// is only stable between requests when the walk order is (not with workers>1
+// unless served from the index).
+func (o listOptions) paginate(resp *ListResponse) {
+	if o.Limit == 0 {
+		return
+	}
+	total := len(resp.Files)
+	start := min(o.Offset, total)
+	end := min(start+o.Limit, total)
+
+	resp.Files = resp.Files[start:end]
+	resp.Total = &total
+	if end < total {
+		resp.NextOffset = end
+	}
+}
+
+// applySeparator rewrites the paths in resp to use the requested separator.
+// It runs last, once filtering and delta computation (which work on native
+// paths) are done.
+func (o listOptions) applySeparator(resp *ListResponse) {
+	if o.Sep == 0 {
+		return
+	}
+	for i := range resp.Files {
+		resp.Files[i].Path = convertSeparators(resp.Files[i].Path, o.Sep)
+	}
+	for i := range resp.Removed {
+		resp.Removed[i] = convertSeparators(resp.Removed[i], o.Sep)
+	}
+}
+
+// convertSeparators replaces '/' and the native separator in p with sep.
+// On Unix a backslash is an ordinary filename character and is left alone.
+func convertSeparators(p string, sep byte) string {
+	b := []byte(p)
+	for i, c := range b {
+		if c == '/' || c == filepath.Separator {
+			b[i] = sep
+		}
+	}
+	return string(b)
+}
+
+// allowedPrefixes parses the comma-separated X-Allowed-Prefixes header. It
+// returns nil when the header is absent, and an empty (non-nil) slice when it
+// is present but lists nothing, so that nothing is visible.
+func allowedPrefixes(r *http.Request) []string {
+	values, ok := r.Header[http.CanonicalHeaderKey("X-Allowed-Prefixes")]
+	if !ok {
+		return nil
+	}
+
+	prefixes := []string{}
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return prefixes
+}
+
+// allowed reports whether a reported path may be shown for this request.
+func (o listOptions) allowed(path string) bool {
+	if o.AllowedPrefixes == nil {
+		return true
+	}
+	for _, prefix := range o.AllowedPrefixes {
+		if hasPathPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedPaths returns the subset of paths that may be shown for this request.
+func (o listOptions) allowedPaths(paths []string) []string {
+	if o.AllowedPrefixes == nil {
+		return paths
+	}
+	var allowed []string
+	for _, p := range paths {
+		if o.allowed(p) {
+			allowed = append(allowed, p)
+		}
+	}
+	return allowed
+}
+
+// reaches reports whether path may be shown or lies above something that
+// may, so that a root of /media still heads a /tree limited to /media/tv.
+func (o listOptions) reaches(path string) bool {
+	return o.allowed(path) || slices.ContainsFunc(o.AllowedPrefixes, func(prefix string) bool { return hasPathPrefix(prefix, path) })
+}
+
+// walkAllowed is walkFiles for a request's handler: files hidden from it by
+// X-Allowed-Prefixes are skipped before fn sees them.
+func walkAllowed(r *http.Request, dirs []string, fn walkFunc) error {
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	if opts.AllowedPrefixes == nil {
+		return walkFiles(r.Context(), dirs, fn)
+	}
+	return walkFiles(r.Context(), dirs, func(path string, d fs.DirEntry) error {
+		if !opts.allowed(reportedPath(path)) {
+			return nil
+		}
+		return fn(path, d)
+	})
+}
+
+// hasPathPrefix reports whether path is prefix or lies beneath it. Matching
+// is by whole path components, so /media/tv does not match /media/tvshows.
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" || !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	if len(path) == len(prefix) || os.IsPathSeparator(prefix[len(prefix)-1]) {
+		return true
+	}
+	return os.IsPathSeparator(path[len(prefix)]) || path[len(prefix)] == '/'
+}
+
+// queryFlag reports whether the boolean query parameter name is set, e.g. ?nosize=1.
+func queryFlag(r *http.Request, name string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return v
+}
+
+// newFileEntry builds the FileEntry reported for a walked file. If the file's
+// info can't be read the entry is still returned, with a zero Size, alongside
+// the error.
+func newFileEntry(path string, d fs.DirEntry, opts listOptions) (FileEntry, error) {
+	entry := FileEntry{
+		Path: reportedPath(path),
+		Name: d.Name(),
+	}
+
+	if d.Type()&fs.ModeSymlink != 0 {
+		entry.Symlink = true
+		entry.Target, _ = os.Readlink(path)
+	}
+	if opts.Parent {
+		entry.Parent = filepath.Base(filepath.Dir(path))
+	}
+	if opts.Locked {
+		entry.Locked = isLocked(path)
+	}
+	if opts.Xattrs {
+		entry.Xattrs = readXattrs(path)
+	}
+	if opts.Media {
+		media := parseMediaName(d.Name())
+		entry.Media = &media
+	}
+	if opts.Hash != "" {
+		if sum, err := contentHashes.sum(path, opts.Hash, opts.Budget); err == nil {
+			entry.Hash = opts.Hash + ":" + sum
+		}
+	}
+
+	if !opts.needsInfo() {
+		return entry, nil
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return entry, err
+	}
+	if !opts.NoSize {
+		entry.Size = info.Size()
+	}
+	entry.ModTime = info.ModTime()
+	entry.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	if opts.Links {
+		entry.Nlink = fileLinks(info)
+	}
+
+	return entry, nil
+}
+
+// reportedPath returns path as it should appear in responses, with
+// --path-prefix prepended. Joining is done with forward slashes so the
+// prefix and path never produce a double separator.
+func reportedPath(p string) string {
+	if config.PathPrefix == "" {
+		return p
+	}
+	return pathpkg.Join(config.PathPrefix, filepath.ToSlash(p))
+}
+
+// newInstanceID returns a random 128-bit hex identifier.
+func newInstanceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		fatal("Error generating instance ID", "err", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// writeError sends a JSON error body of the form {"error": "...", "status": N}
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Status: status})
+}
+
+// walkCancelled reports whether r was cancelled, by the client going away,
+// --request-timeout or the server shutting down, and if so writes a 503. Handlers check it after
+// walking so a listing cut short is never sent as if it were complete.
+func walkCancelled(w http.ResponseWriter, r *http.Request) bool {
+	err := r.Context().Err()
+	if err == nil {
+		return false
+	}
+	requestLogger(r).Info("Walk stopped early", "reason", context.Cause(r.Context()))
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, http.StatusServiceUnavailable, "request timed out before the walk finished")
+		return true
+	}
+	writeError(w, http.StatusServiceUnavailable, "request cancelled before the walk finished")
+	return true
+}
+
+// rangeFilter holds /filter's size and modification time bounds. Zero
+// values are unset, except maxSize, which is unset when negative so that
+// max_size=0 finds empty files. Sizes are inclusive; times form the
+// half-open range [modified_after, modified_before).
+type rangeFilter struct {
+	minSize, maxSize int64
+	after, before    time.Time
+}
+
+// parseRangeFilter reads min_size and max_size (e.g. 5GB, as for
+// --size-buckets) and modified_after and modified_before from r. Times are
+// RFC 3339, a date (midnight, server time) or an age before now such as 36h
+// or 7d.
+func parseRangeFilter(r *http.Request, now time.Time) (rangeFilter, error) {
+	f := rangeFilter{maxSize: -1}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"min_size", &f.minSize}, {"max_size", &f.maxSize}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			n, err := parseByteSize(v)
+			if err != nil {
+				return f, fmt.Errorf("invalid '%s' parameter: %v", p.name, err)
+			}
+			*p.dst = n
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"modified_after", &f.after}, {"modified_before", &f.before}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			t, err := parseTimeParam(v, now)
+			if err != nil {
+				return f, fmt.Errorf("invalid '%s' parameter: %q (want RFC 3339, YYYY-MM-DD or an age like 7d)", p.name, v)
+			}
+			*p.dst = t
+		}
+	}
+	if f.maxSize >= 0 && f.minSize > f.maxSize {
+		return f, fmt.Errorf("'min_size' is larger than 'max_size'")
+	}
+	if !f.after.IsZero() && !f.before.IsZero() && !f.after.Before(f.before) {
+		return f, fmt.Errorf("'modified_after' is not before 'modified_before'")
+	}
+	return f, nil
+}
+
+// parseTimeParam parses an RFC 3339 time, a YYYY-MM-DD date in the server's
+// time zone, or a Go duration or whole number of days ("7d") before now.
+func parseTimeParam(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+func (f rangeFilter) active() bool {
+	return f.minSize > 0 || f.maxSize >= 0 || !f.after.IsZero() || !f.before.IsZero()
+}
+
+func (f rangeFilter) match(info fs.FileInfo) bool {
+	size, mtime := info.Size(), info.ModTime()
+	return (f.minSize == 0 || size >= f.minSize) &&
+		(f.maxSize < 0 || size <= f.maxSize) &&
+		(f.after.IsZero() || !mtime.Before(f.after)) &&
+		(f.before.IsZero() || mtime.Before(f.before))
+}
+
+// nameQuery is /filter's q parameters: all of them must match (op=and, the
+// default) or any one (op=or). Empty values are ignored.
+type nameQuery struct {
+	patterns []namePattern
+	any      bool
+}
+
+func parseNameQuery(qs []string, mode, op string) (nameQuery, error) {
+	var q nameQuery
+	switch op {
+	case "", "and":
+	case "or":
+		q.any = true
+	default:
+		return q, fmt.Errorf("invalid 'op' parameter: %q (want and or or)", op)
+	}
+	for _, pattern := range qs {
+		if pattern == "" {
+			continue
+		}
+		p, err := parseNamePattern(pattern, mode)
+		if err != nil {
+			return q, err
+		}
+		q.patterns = append(q.patterns, p)
+	}
+	return q, nil
+}
+
+func (q nameQuery) empty() bool {
+	return len(q.patterns) == 0
+}
+
+func (q nameQuery) match(name string) bool {
+	if q.any {
+		return slices.ContainsFunc(q.patterns, func(p namePattern) bool { return p.match(name) })
+	}
+	for _, p := range q.patterns {
+		if !p.match(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// offsets reports the match of the first pattern that matches name.
+func (q nameQuery) offsets(name string) (start, end int, ok bool) {
+	for _, p := range q.patterns {
+		if start, end, ok := p.offsets(name); ok {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// score is the best score of any of the patterns.
+func (q nameQuery) score(name string) int {
+	best := 0
+	for _, p := range q.patterns {
+		best = max(best, p.score(name))
+	}
+	return best
+}
+
+// namePattern is one of /filter's q parameters compiled for its mode: a DOS-style
+// wildcard (mode=wildcard, the default) or a regular expression (mode=regex).
+type namePattern struct {
+	wildcard string
+	re       *regexp.Regexp
+}
+
+// parseNamePattern compiles pattern for mode. Regular expressions use RE2
+// syntax, match anywhere in the name unless anchored, and are
+// case-insensitive like wildcards unless they turn that off with (?-i).
+func parseNamePattern(pattern, mode string) (namePattern, error) {
+	switch mode {
+	case "", "wildcard":
+		return namePattern{wildcard: pattern}, nil
+	case "regex":
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return namePattern{}, fmt.Errorf("invalid 'q' regex: %v", err)
+		}
+		return namePattern{re: re}, nil
+	}
+	return namePattern{}, fmt.Errorf("invalid 'mode' parameter: %q (want wildcard or regex)", mode)
+}
+
+func (p namePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	return matchPattern(name, p.wildcard)
+}
+
+// offsets is matchOffsets for either mode; a regex reports its leftmost
+// match.
+func (p namePattern) offsets(name string) (start, end int, ok bool) {
+	if p.re != nil {
+		loc := p.re.FindStringIndex(name)
+		if loc == nil {
+			return 0, 0, false
+		}
+		return loc[0], loc[1], true
+	}
+	return matchOffsets(name, p.wildcard)
+}
+
+// score is matchScore for either mode; a regex is scored by where its
+// leftmost match lies in the name.
+func (p namePattern) score(name string) int {
+	if p.re == nil {
+		return matchScore(name, p.wildcard)
+	}
+	start, end, ok := p.offsets(name)
+	switch {
+	case !ok:
+		return 0
+	case start == 0 && end == len(name):
+		return scoreExact
+	case start == 0:
+		return scorePrefix
+	case end == len(name):
+		return scoreSuffix
+	default:
+		return scoreContains
+	}
+}
+
+// matchPattern does DOS-style wildcard matching (case-insensitive): * matches
+// any run of characters and ? any single one, anywhere in the pattern, e.g.
+// movie*1080p*.mkv. Everything else, brackets included, is literal.
 func matchPattern(name, pattern string) bool {
-	name = strings.ToLower(name)
-	pattern = strings.ToLower(pattern)
+	_, _, ok := globSpan(strings.ToLower(name), strings.ToLower(pattern))
+	return ok
+}
+
+// matchOffsets returns the byte range [start, end) of name matched by
+// pattern without its leading and trailing *, using the same rules as
+// matchPattern. ok is false if there's no match, or if lowercasing name
+// changes its length so offsets can't be mapped back.
+func matchOffsets(name, pattern string) (start, end int, ok bool) {
+	lower := strings.ToLower(name)
+	if len(lower) != len(name) {
+		return 0, 0, false
+	}
+	return globSpan(lower, strings.ToLower(pattern))
+}
 
-	hasPrefix := strings.HasPrefix(pattern, "*")
-	hasSuffix := strings.HasSuffix(pattern, "*")
+// globSpan matches pattern against the whole of name and returns the span
+// from the start of pattern's first literal part to the end of its last, so
+// leading and trailing * are left out. Parts between * are placed leftmost
+// first, which finds a match whenever there is one.
+func globSpan(name, pattern string) (start, end int, ok bool) {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		if partAt(name, 0, pattern) != len(name) {
+			return 0, 0, false
+		}
+		return 0, len(name), true
+	}
 
+	start, pos := -1, 0
+	if first := parts[0]; first != "" {
+		if pos = partAt(name, 0, first); pos < 0 {
+			return 0, 0, false
+		}
+		start = 0
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		if part == "" {
+			continue
+		}
+		i, e := partIndex(name, pos, part)
+		if i < 0 {
+			return 0, 0, false
+		}
+		if start < 0 {
+			start = i
+		}
+		pos = e
+	}
+	if last := parts[len(parts)-1]; last != "" {
+		// The last part is anchored to the end, and ? is one character, so
+		// there is only one place it can start.
+		i := len(name)
+		for range utf8.RuneCountInString(last) {
+			if i <= pos {
+				return 0, 0, false
+			}
+			_, size := utf8.DecodeLastRuneInString(name[:i])
+			i -= size
+		}
+		if i < pos || partAt(name, i, last) != len(name) {
+			return 0, 0, false
+		}
+		if start < 0 {
+			start = i
+		}
+		pos = len(name)
+	}
+	if start < 0 {
+		start = pos // all *: an empty span
+	}
+	return start, pos, true
+}
+
+// partIndex finds the first place at or after from where part, a piece of
+// pattern between *, matches name, returning its start and end.
+func partIndex(name string, from int, part string) (start, end int) {
+	if !strings.ContainsRune(part, '?') {
+		if i := strings.Index(name[from:], part); i >= 0 {
+			return from + i, from + i + len(part)
+		}
+		return -1, -1
+	}
+	for i := from; i < len(name); {
+		if e := partAt(name, i, part); e >= 0 {
+			return i, e
+		}
+		_, size := utf8.DecodeRuneInString(name[i:])
+		i += size
+	}
+	return -1, -1
+}
+
+// partAt matches part, where ? stands for any one character, against name
+// starting at i, returning where the match ends or -1.
+func partAt(name string, i int, part string) int {
+	for _, pr := range part {
+		if i >= len(name) {
+			return -1
+		}
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if pr != '?' && pr != r {
+			return -1
+		}
+		i += size
+	}
+	return i
+}
+
+// Match scores used by ?rank=1, best first.
+const (
+	scoreExact    = 4
+	scorePrefix   = 3
+	scoreSuffix   = 2
+	scoreContains = 1
+)
+
+// matchScore rates how well name matches the core of pattern (the pattern
+// without its leading and trailing *), regardless of which of those were
+// given: an exact name beats a prefix match, which beats a suffix match,
+// which beats a match somewhere in the middle.
+func matchScore(name, pattern string) int {
 	core := strings.Trim(pattern, "*")
 
 	switch {
-	case hasPrefix && hasSuffix:
-		return strings.Contains(name, core)
-	case hasPrefix:
-		return strings.HasSuffix(name, core)
-	case hasSuffix:
-		return strings.HasPrefix(name, core)
+	case matchPattern(name, core):
+		return scoreExact
+	case matchPattern(name, core+"*"):
+		return scorePrefix
+	case matchPattern(name, "*"+core):
+		return scoreSuffix
+	case matchPattern(name, "*"+core+"*"):
+		return scoreContains
 	default:
-		return name == pattern
+		return 0
 	}
 }
 
+// sortByScore orders files by descending Score, then shorter names first,
+// then by path so the order is stable between requests.
+func sortByScore(files []FileEntry) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Name) != len(b.Name) {
+			return len(a.Name) < len(b.Name)
+		}
+		return a.Path < b.Path
+	})
+}
+
+// matchExtension reports whether name ends with the extension ext
+// (case-insensitive). ext may be given with or without its leading dot and
+// may be compound, e.g. "tar.gz" matches "backup.tar.gz" but not "backup.gz".
+func matchExtension(name, ext string) bool {
+	name = strings.ToLower(name)
+	ext = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+
+	return len(name) > len(ext) && strings.HasSuffix(name, ext)
+}
+
+// maxBraceExpansions caps how many alternatives a brace pattern may expand to.
+const maxBraceExpansions = 256
+
+// expandBraces expands shell-style brace alternatives, e.g. "a{b,c{d,e}}"
+// becomes [ab acd ace]. Unbalanced braces are an error.
+func expandBraces(s string) ([]string, error) {
+	open := strings.IndexAny(s, "{}")
+	if open < 0 {
+		return []string{s}, nil
+	}
+	if s[open] == '}' {
+		return nil, fmt.Errorf("unexpected '}' at offset %d", open)
+	}
+
+	// Find the matching close brace, splitting on commas at this depth.
+	depth, start := 0, open+1
+	var alternatives []string
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, s[start:i])
+				start = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			alternatives = append(alternatives, s[start:i])
+
+			rest, err := expandBraces(s[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			var out []string
+			for _, alt := range alternatives {
+				expanded, err := expandBraces(alt)
+				if err != nil {
+					return nil, err
+				}
+				for _, e := range expanded {
+					for _, r := range rest {
+						out = append(out, s[:open]+e+r)
+					}
+				}
+				if len(out) > maxBraceExpansions {
+					return nil, fmt.Errorf("expands to more than %d alternatives", maxBraceExpansions)
+				}
+			}
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("unclosed '{' at offset %d", open)
+}
+
+// parseExtensionSet expands an ?exts= pattern such as "*.{mkv,mp4,avi}" into
+// its set of extensions, lowercased and without leading "*." or ".".
+func parseExtensionSet(spec string) ([]string, error) {
+	expanded, err := expandBraces(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var exts []string
+	for _, e := range expanded {
+		e = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(e, "*"), "."))
+		if e == "" || strings.ContainsAny(e, "*?/") {
+			return nil, fmt.Errorf("invalid extension %q", e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			exts = append(exts, e)
+		}
+	}
+	return exts, nil
+}
+
 // computeVersion returns a hash of all file paths in the configured directories.
 // The hash changes when files are added or removed.
-func computeVersion() string {
+func computeVersion(ctx context.Context) string {
 	var paths []string
 
-	for _, dir := range config.Dirs {
-		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-			if err != nil || d.IsDir() {
-				return nil
-			}
-			paths = append(paths, path)
-			return nil
-		})
-	}
+	walkFiles(ctx, configuredDirs(), func(path string, d fs.DirEntry) error {
+		paths = append(paths, path)
+		return nil
+	})
+
+	return hashPaths(paths)
+}
 
+// hashPaths returns the version string for a set of file paths, independent
+// of their order. paths is sorted in place.
+func hashPaths(paths []string) string {
 	sort.Strings(paths)
 
 	h := sha256.New()
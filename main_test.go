@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMatchPattern(t *testing.T) {
@@ -36,6 +43,32 @@ func TestMatchPattern(t *testing.T) {
 		{"Movie.mkv", "movie.mkv", true},
 		{"Movie.mkv", "MOVIE.MKV", true},
 		{"Movie.mkv", "other.mkv", false},
+
+		// * in the middle
+		{"Movie.2024.1080p.mkv", "movie*1080p*.mkv", true},
+		{"Movie.2024.1080p.mkv", "movie*720p*.mkv", false},
+		{"Movie.2024.1080p.mkv", "*2024*1080p*", true},
+		{"Movie.2024.1080p.mkv", "*1080p*2024*", false},
+		{"Movie.mkv", "movie*mkv", true},
+		{"Movie.mkv", "movie*.mkv*", true},
+		{"Movie.mkv", "movie.mkv*mkv", false},
+		{"aXbXb", "a*b", true},
+		{"ab", "ab*b", false},
+		{"Movie.mkv", "**movie**mkv**", true},
+		{"Movie.mkv", "*", true},
+
+		// ? = any single character
+		{"Show.S01E02.mkv", "show.s??e??.mkv", true},
+		{"Show.S01E2.mkv", "show.s??e??.mkv", false},
+		{"Show.S01E02.mkv", "*s0?e*", true},
+		{"Café.mkv", "caf?.mkv", true},
+		{"Movie.mkv", "?ovie.mkv", true},
+		{"Movie.mkv", "movie.mkv?", false},
+
+		// everything else is literal
+		{"Movie [1080p].mkv", "*[1080p]*", true},
+		{"Movie 1.mkv", "*[1080p]*", false},
+		{"Movie\\1.mkv", "movie\\1.mkv", true},
 	}
 
 	for _, tt := range tests {
@@ -48,6 +81,93 @@ func TestMatchPattern(t *testing.T) {
 	}
 }
 
+func TestMatchOffsets(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		wantStart int
+		wantEnd   int
+		wantOK    bool
+	}{
+		{"Movie.2024.1080p.mkv", "*1080*", 11, 15, true},
+		{"Movie.2024.1080p.mkv", "MOVIE*", 0, 5, true},
+		{"Movie.2024.1080p.mkv", "*.MKV", 16, 20, true},
+		{"Movie.mkv", "movie.mkv", 0, 9, true},
+		{"Movie.2024.1080p.mkv", "*720*", 0, 0, false},
+		{"Movie.2024.1080p.mkv", "*2024*1080p*", 6, 16, true},
+		{"Movie.2024.1080p.mkv", "movie*1080p*", 0, 16, true},
+		{"Movie.2024.1080p.mkv", "*.20??.*.mkv", 5, 20, true},
+		{"Movie.2024.1080p.mkv", "*", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"_"+tt.pattern, func(t *testing.T) {
+			start, end, ok := matchOffsets(tt.name, tt.pattern)
+			if start != tt.wantStart || end != tt.wantEnd || ok != tt.wantOK {
+				t.Errorf("matchOffsets(%q, %q) = %d, %d, %v; want %d, %d, %v",
+					tt.name, tt.pattern, start, end, ok, tt.wantStart, tt.wantEnd, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMatchExtension(t *testing.T) {
+	tests := []struct {
+		name string
+		ext  string
+		want bool
+	}{
+		{"Movie.2024.1080p.mkv", ".mkv", true},
+		{"Movie.2024.1080p.mkv", "mkv", true},
+		{"Movie.2024.1080p.MKV", ".mkv", true},
+		{"Movie.2024.1080p.mkv", ".MKV", true},
+		{"Movie.2024.1080p.mkv", ".avi", false},
+		{"backup.tar.gz", ".tar.gz", true},
+		{"backup.TAR.GZ", "tar.gz", true},
+		{"backup.tar.gz", ".gz", true},
+		{"backup.gz", ".tar.gz", false},
+		{"backuptar.gz", ".tar.gz", false},
+		{".mkv", ".mkv", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+"_"+tt.ext, func(t *testing.T) {
+			got := matchExtension(tt.name, tt.ext)
+			if got != tt.want {
+				t.Errorf("matchExtension(%q, %q) = %v, want %v", tt.name, tt.ext, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+		wantErr bool
+	}{
+		{"mkv", []string{"mkv"}, false},
+		{"*.{mkv,mp4,avi}", []string{"*.mkv", "*.mp4", "*.avi"}, false},
+		{"{tar.,}gz", []string{"tar.gz", "gz"}, false},
+		{"{a,b{c,d}}{1,2}", []string{"a1", "a2", "bc1", "bc2", "bd1", "bd2"}, false},
+		{"*.{mkv", nil, true},
+		{"*.mkv}", nil, true},
+		{"{a,b}{c,d}{e,f}{g,h}{i,j}{k,l}{m,n}{o,p}{q,r}", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := expandBraces(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandBraces(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandBraces(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestHandleHealth(t *testing.T) {
 	tmpDir := t.TempDir()
 	os.WriteFile(filepath.Join(tmpDir, "test.mkv"), []byte("test"), 0644)
@@ -73,6 +193,9 @@ func TestHandleHealth(t *testing.T) {
 	if resp["host"] != "test-host" {
 		t.Errorf("expected host test-host, got %s", resp["host"])
 	}
+	if resp["instance_id"] != instanceID || len(instanceID) != 32 {
+		t.Errorf("expected instance_id %s, got %s", instanceID, resp["instance_id"])
+	}
 	if resp["version"] == "" {
 		t.Error("expected version in health response")
 	}
@@ -86,11 +209,11 @@ func TestVersionChangesWhenFilesChange(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "file1.mkv"), []byte("test"), 0644)
 	config.Dirs = []string{tmpDir}
 
-	v1 := computeVersion()
+	v1 := computeVersion(context.Background())
 
 	// Add a new file
 	os.WriteFile(filepath.Join(tmpDir, "file2.mkv"), []byte("test2"), 0644)
-	v2 := computeVersion()
+	v2 := computeVersion(context.Background())
 
 	if v1 == v2 {
 		t.Error("version should change when files are added")
@@ -98,7 +221,7 @@ func TestVersionChangesWhenFilesChange(t *testing.T) {
 
 	// Remove a file
 	os.Remove(filepath.Join(tmpDir, "file2.mkv"))
-	v3 := computeVersion()
+	v3 := computeVersion(context.Background())
 
 	if v2 == v3 {
 		t.Error("version should change when files are removed")
@@ -114,8 +237,8 @@ func TestVersionIsDeterministic(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "b.mkv"), []byte("test"), 0644)
 	config.Dirs = []string{tmpDir}
 
-	v1 := computeVersion()
-	v2 := computeVersion()
+	v1 := computeVersion(context.Background())
+	v2 := computeVersion(context.Background())
 
 	if v1 != v2 {
 		t.Error("version should be deterministic for same file set")
@@ -148,6 +271,9 @@ func TestHandleList(t *testing.T) {
 	if resp.Host != "test-host" {
 		t.Errorf("expected host test-host, got %s", resp.Host)
 	}
+	if resp.InstanceID != instanceID {
+		t.Errorf("expected instance_id %s, got %s", instanceID, resp.InstanceID)
+	}
 	if len(resp.Files) != 3 {
 		t.Errorf("expected 3 files, got %d", len(resp.Files))
 	}
@@ -157,6 +283,7 @@ func TestHandleFilter(t *testing.T) {
 	tmpDir := t.TempDir()
 	os.WriteFile(filepath.Join(tmpDir, "Edge.of.Darkness.2010.1080p.mkv"), []byte("test"), 0644)
 	os.WriteFile(filepath.Join(tmpDir, "Other.Movie.720p.mkv"), []byte("test2"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Backup.2010.tar.gz"), []byte("test3"), 0644)
 
 	config.FriendlyName = "test-host"
 	config.Dirs = []string{tmpDir}
@@ -171,6 +298,12 @@ func TestHandleFilter(t *testing.T) {
 		{"*.mkv", 2, http.StatusOK},
 		{"*1080*", 1, http.StatusOK},
 		{"*notfound*", 0, http.StatusOK},
+		{"*edge*&ext=.mkv", 1, http.StatusOK},
+		{"&ext=tar.gz", 1, http.StatusOK},
+		{"&ext=.MKV", 2, http.StatusOK},
+		{"&exts=*.{MKV,gz}", 3, http.StatusOK},
+		{"&exts=*.{tar.gz,avi}", 1, http.StatusOK},
+		{"&exts=*.{mkv", 0, http.StatusBadRequest},
 		{"", 0, http.StatusBadRequest},
 	}
 
@@ -199,3 +332,941 @@ func TestHandleFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleListDir(t *testing.T) {
+	movies, tv := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(movies, "movie.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tv, "episode1.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tv, "episode2.mkv"), []byte("test"), 0644)
+
+	config.Dirs = []string{movies, tv}
+	config.PathPrefix = "/media"
+	t.Cleanup(func() { config.PathPrefix = "" })
+
+	tests := []struct {
+		handler   http.HandlerFunc
+		query     string
+		wantCount int
+		wantCode  int
+	}{
+		{handleList, "", 3, http.StatusOK},
+		{handleList, "dir=" + url.QueryEscape(tv), 2, http.StatusOK},
+		{handleList, "dir=" + url.QueryEscape(reportedPath(movies)), 1, http.StatusOK},
+		{handleList, "dir=" + url.QueryEscape(movies) + "&dir=" + url.QueryEscape(tv), 3, http.StatusOK},
+		{handleList, "dir=" + url.QueryEscape(tv) + "&sample=5", 2, http.StatusOK},
+		{handleFilter, "q=*1*&dir=" + url.QueryEscape(tv), 1, http.StatusOK},
+		{handleFilter, "q=*1*&dir=" + url.QueryEscape(movies), 0, http.StatusOK},
+		{handleList, "dir=" + url.QueryEscape(filepath.Dir(tv)), 0, http.StatusBadRequest},
+		{handleList, "dir=" + url.QueryEscape(filepath.Join(tv, "..", "..")), 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodGet, "/list?"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if len(resp.Files) != tt.wantCount {
+				t.Errorf("expected %d files, got %d", tt.wantCount, len(resp.Files))
+			}
+		})
+	}
+}
+
+func TestHandleListPagination(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv", "e.mkv"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	var names []string
+	offset, pages := 0, 0
+	for {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/list?limit=2&offset=%d", offset), nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Total == nil || *resp.Total != 5 {
+			t.Fatalf("offset %d: expected total 5, got %v", offset, resp.Total)
+		}
+		for _, f := range resp.Files {
+			names = append(names, f.Name)
+		}
+		pages++
+		if resp.NextOffset == 0 {
+			break
+		}
+		offset = resp.NextOffset
+	}
+
+	if pages != 3 || !reflect.DeepEqual(names, []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv", "e.mkv"}) {
+		t.Errorf("expected 3 pages covering every file once, got %d pages: %v", pages, names)
+	}
+
+	for _, q := range []string{"limit=-1", "limit=ten", "limit=2&offset=-2"} {
+		req := httptest.NewRequest(http.MethodGet, "/filter?q=*&"+q, nil)
+		w := httptest.NewRecorder()
+		handleFilter(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestHandleListModTimeAndMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	movie := filepath.Join(tmpDir, "movie.mkv")
+	os.WriteFile(movie, []byte("test"), 0640)
+	os.Chmod(movie, 0640)
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(movie, mtime, mtime)
+	if err := os.Symlink(movie, filepath.Join(tmpDir, "link.mkv")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	if !strings.Contains(w.Body.String(), `"mtime":"2024-03-01T12:00:00Z"`) {
+		t.Errorf("expected an RFC 3339 mtime in %s", w.Body.String())
+	}
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	for _, f := range resp.Files {
+		switch f.Name {
+		case "movie.mkv":
+			if f.Symlink || f.Mode != "0640" || !f.ModTime.Equal(mtime) {
+				t.Errorf("unexpected movie.mkv entry %+v", f)
+			}
+		case "link.mkv":
+			if !f.Symlink {
+				t.Errorf("expected link.mkv to be reported as a symlink")
+			}
+		}
+	}
+}
+
+func TestHandleListSort(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, f := range []struct {
+		name string
+		size int
+	}{{"b.mkv", 30}, {"C.mkv", 10}, {"a.mkv", 20}} {
+		path := filepath.Join(tmpDir, f.name)
+		os.WriteFile(path, make([]byte, f.size), 0644)
+		mtime := base.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query    string
+		want     []string
+		wantSort string
+	}{
+		{"sort=name", []string{"a.mkv", "b.mkv", "C.mkv"}, "name:asc"},
+		{"sort=size&order=desc", []string{"b.mkv", "a.mkv", "C.mkv"}, "size:desc"},
+		{"sort=mtime&nosize=1", []string{"b.mkv", "C.mkv", "a.mkv"}, "mtime:asc"},
+		{"sort=size&limit=1&offset=1", []string{"a.mkv"}, "size:asc"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/list?"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		var names []string
+		for _, f := range resp.Files {
+			names = append(names, f.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) || resp.Sort != tt.wantSort {
+			t.Errorf("%q: expected %v sorted %s, got %v sorted %q", tt.query, tt.want, tt.wantSort, names, resp.Sort)
+		}
+	}
+
+	for _, q := range []string{"sort=colour", "sort=name&order=up", "sort=size&nosize=1"} {
+		req := httptest.NewRequest(http.MethodGet, "/list?"+q, nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestHandleFilterInvalidUTF8(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "Caf\xe9.mkv"), []byte("test"), 0644)
+	if err := os.WriteFile(filepath.Join(tmpDir, "Café.mkv"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 2 {
+		t.Skip("filesystem rejects names that aren't valid UTF-8")
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/filter?invalidutf8=1", nil)
+	w := httptest.NewRecorder()
+	handleFilter(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 {
+		t.Fatalf("expected 1 file, got %+v", resp.Files)
+	}
+	if resp.Files[0].NameHex != "436166e92e6d6b76" {
+		t.Errorf("expected name_hex 436166e92e6d6b76, got %q", resp.Files[0].NameHex)
+	}
+}
+
+func TestHandleFilterMissingQueryReturnsJSONError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/filter", nil)
+	w := httptest.NewRecorder()
+
+	handleFilter(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %s", ct)
+	}
+
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected JSON error body, got %q", w.Body.String())
+	}
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("expected status field 400, got %d", resp.Status)
+	}
+	if resp.Error != "missing 'q' parameter" {
+		t.Errorf("unexpected error message %q", resp.Error)
+	}
+}
+
+func TestReportedPath(t *testing.T) {
+	t.Cleanup(func() { config.PathPrefix = "" })
+
+	tests := []struct {
+		prefix string
+		path   string
+		want   string
+	}{
+		{"", "/mnt/media/movie.mkv", "/mnt/media/movie.mkv"},
+		{"/remote/hostA", "/mnt/media/movie.mkv", "/remote/hostA/mnt/media/movie.mkv"},
+		{"/remote/hostA/", "/mnt/media/movie.mkv", "/remote/hostA/mnt/media/movie.mkv"},
+		{"/remote/hostA", "media/movie.mkv", "/remote/hostA/media/movie.mkv"},
+	}
+
+	for _, tt := range tests {
+		config.PathPrefix = tt.prefix
+		if got := reportedPath(tt.path); got != tt.want {
+			t.Errorf("reportedPath(%q) with prefix %q = %q, want %q", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestHandleListWaitForChange(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "file1.mkv"), []byte("test"), 0644)
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	oldInterval := waitPollInterval
+	waitPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { waitPollInterval = oldInterval })
+
+	current := computeVersion(context.Background())
+
+	t.Run("stale token returns immediately", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/list?wait-for-change=sha256:old", nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+		if got := w.Header().Get("X-Content-Version"); got != current {
+			t.Errorf("expected X-Content-Version %s, got %s", current, got)
+		}
+	})
+
+	t.Run("unchanged returns 304 after timeout", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/list?wait-for-change="+current+"&timeout=50ms", nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		if w.Code != http.StatusNotModified {
+			t.Errorf("expected status 304, got %d", w.Code)
+		}
+	})
+
+	t.Run("change wakes the request", func(t *testing.T) {
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			os.WriteFile(filepath.Join(tmpDir, "file2.mkv"), []byte("test2"), 0644)
+		}()
+
+		req := httptest.NewRequest(http.MethodGet, "/list?wait-for-change="+current+"&timeout=5s", nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Files) != 2 {
+			t.Errorf("expected 2 files, got %d", len(resp.Files))
+		}
+	})
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/list?wait-for-change="+current+"&timeout=soon", nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("invalid options fail before waiting", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/list?wait-for-change="+current+"&timeout=5m&sort=colour", nil)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		handleList(w, req)
+
+		if w.Code != http.StatusBadRequest || time.Since(start) > time.Second {
+			t.Errorf("expected an immediate 400, got %d after %s", w.Code, time.Since(start))
+		}
+	})
+}
+
+func TestHandleListWaitForChangeIndexed(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "file1.mkv"), []byte("test"), 0644)
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	// The change feed, not polling, wakes the request.
+	oldInterval := waitPollInterval
+	waitPollInterval = time.Hour
+	t.Cleanup(func() { waitPollInterval = oldInterval })
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+	index.scan()
+	current := computeVersion(context.Background())
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		added := filepath.Join(tmpDir, "file2.mkv")
+		os.WriteFile(added, []byte("test2"), 0644)
+		index.update(map[string]bool{added: true})
+	}()
+
+	req := httptest.NewRequest(http.MethodGet, "/list?wait-for-change="+current+"&timeout=5s", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || len(resp.Files) != 2 {
+		t.Errorf("expected 2 files once the index changed, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleListNoSize(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie1.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		url      string
+		wantSize int64
+	}{
+		{"/list", 4},
+		{"/list?nosize=1", 0},
+		{"/list?nosize=true", 0},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Files) != 1 {
+			t.Fatalf("%s: expected 1 file, got %d", tt.url, len(resp.Files))
+		}
+		if resp.Files[0].Size != tt.wantSize {
+			t.Errorf("%s: expected size %d, got %d", tt.url, tt.wantSize, resp.Files[0].Size)
+		}
+	}
+}
+
+func TestHandleListParent(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "ShowA"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "ShowA", "e01.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "loose.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list?parent=1", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := map[string]string{
+		"e01.mkv":   "ShowA",
+		"loose.mkv": filepath.Base(tmpDir),
+	}
+	for _, f := range resp.Files {
+		if f.Parent != want[f.Name] {
+			t.Errorf("%s: expected parent %q, got %q", f.Name, want[f.Name], f.Parent)
+		}
+	}
+}
+
+func TestHasPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/media/tv/show.mkv", "/media/tv", true},
+		{"/media/tv/show.mkv", "/media/tv/", true},
+		{"/media/tv", "/media/tv", true},
+		{"/media/tvshows/show.mkv", "/media/tv", false},
+		{"/media/movies/film.mkv", "/media/tv", false},
+	}
+
+	for _, tt := range tests {
+		if got := hasPathPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("hasPathPrefix(%q, %q) = %v, want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedPrefixesHeader(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "kids"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "adults"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "kids", "cartoon.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "adults", "thriller.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		name      string
+		header    *string
+		wantCount int
+	}{
+		{"absent", nil, 2},
+		{"one prefix", ptr(filepath.Join(tmpDir, "kids")), 1},
+		{"two prefixes", ptr(filepath.Join(tmpDir, "kids") + ", " + filepath.Join(tmpDir, "adults")), 2},
+		{"empty", ptr(""), 0},
+	}
+
+	for _, tt := range tests {
+		for _, url := range []string{"/list", "/filter?q=*.mkv"} {
+			t.Run(tt.name+url, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, url, nil)
+				if tt.header != nil {
+					req.Header.Set("X-Allowed-Prefixes", *tt.header)
+				}
+				w := httptest.NewRecorder()
+				if strings.HasPrefix(url, "/list") {
+					handleList(w, req)
+				} else {
+					handleFilter(w, req)
+				}
+
+				var resp ListResponse
+				json.Unmarshal(w.Body.Bytes(), &resp)
+				if len(resp.Files) != tt.wantCount {
+					t.Errorf("expected %d files, got %d", tt.wantCount, len(resp.Files))
+				}
+			})
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestHandleListSeparator(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "show"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "show", "e01.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	native := filepath.Join(tmpDir, "show", "e01.mkv")
+
+	tests := []struct {
+		query    string
+		wantPath string
+		wantCode int
+	}{
+		{"", native, http.StatusOK},
+		{"?sep=/", filepath.ToSlash(native), http.StatusOK},
+		{"?sep=%5C", strings.ReplaceAll(filepath.ToSlash(native), "/", `\`), http.StatusOK},
+		{"?sep=:", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/list"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleList(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if len(resp.Files) != 1 || resp.Files[0].Path != tt.wantPath {
+				t.Errorf("expected path %s, got %+v", tt.wantPath, resp.Files)
+			}
+		})
+	}
+}
+
+func TestHandleFilterHighlight(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "Edge.of.Darkness.2010.1080p.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/filter?q=*darkness*&highlight=1", nil)
+	w := httptest.NewRecorder()
+	handleFilter(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 || resp.Files[0].Match == nil {
+		t.Fatalf("expected one highlighted result, got %+v", resp.Files)
+	}
+	m := resp.Files[0].Match
+	if got := resp.Files[0].Name[m[0]:m[1]]; got != "Darkness" {
+		t.Errorf("expected match to cover Darkness, got %q", got)
+	}
+
+	// XML carries the same offsets as a match attribute.
+	w = httptest.NewRecorder()
+	handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?q=*darkness*&highlight=1&format=xml", nil))
+	var doc xmlListing
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Files) != 1 || doc.Files[0].Match != "8,16" {
+		t.Errorf("expected match=\"8,16\" in XML, got %+v", doc.Files)
+	}
+}
+
+func TestHandleFilterRegex(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"Show.S01E02.mkv", "show.s10e11.720p.mkv", "Show.Special.mkv", "Edge.of.Darkness.2010.1080p.mkv"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query     string
+		wantCount int
+		wantCode  int
+		wantError string
+	}{
+		{`S\d{2}E\d{2}`, 2, http.StatusOK, ""},
+		{`(?-i)S\d{2}E\d{2}`, 1, http.StatusOK, ""},
+		{`^edge\.`, 1, http.StatusOK, ""},
+		{`\.mkv$`, 4, http.StatusOK, ""},
+		{`S(\d{2}`, 0, http.StatusBadRequest, "missing closing )"},
+		{``, 0, http.StatusBadRequest, "missing 'q' parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/filter?mode=regex&highlight=1&q="+url.QueryEscape(tt.query), nil)
+			w := httptest.NewRecorder()
+			handleFilter(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantError != "" && !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("expected error mentioning %q, got %s", tt.wantError, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if len(resp.Files) != tt.wantCount {
+				t.Errorf("expected %d files, got %d", tt.wantCount, len(resp.Files))
+			}
+			for _, f := range resp.Files {
+				if f.Match == nil {
+					t.Errorf("expected %s to be highlighted", f.Name)
+				}
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/filter?mode=fuzzy&q=*.mkv", nil)
+	w := httptest.NewRecorder()
+	handleFilter(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown mode to be rejected, got %d", w.Code)
+	}
+}
+
+func TestHandleFilterScopePath(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"Show A/Season 01", "Show B/Season 02"} {
+		os.MkdirAll(filepath.Join(tmpDir, dir), 0755)
+		os.WriteFile(filepath.Join(tmpDir, dir, "S01E01.mkv"), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	get := func(query string) (*httptest.ResponseRecorder, ListResponse) {
+		w := httptest.NewRecorder()
+		handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?"+query, nil))
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	q := "q=" + url.QueryEscape("*/season 01/*")
+	if _, resp := get(q); len(resp.Files) != 0 {
+		t.Errorf("expected the default name scope to ignore directories, got %d files", len(resp.Files))
+	}
+
+	_, resp := get(q + "&scope=path&highlight=1")
+	if len(resp.Files) != 1 || !strings.Contains(resp.Files[0].Path, "Show A") {
+		t.Fatalf("expected only the Season 01 episode, got %+v", resp.Files)
+	}
+	if m := resp.Files[0].Match; m == nil || resp.Files[0].Path[m[0]:m[1]] != string(filepath.Separator)+"Season 01"+string(filepath.Separator) {
+		t.Errorf("expected the match to cover the directory in path, got %v", m)
+	}
+
+	if _, resp := get("mode=regex&scope=path&q=" + url.QueryEscape(`show b/.*\.mkv$`)); len(resp.Files) != 1 {
+		t.Errorf("expected a regex to match paths too, got %d files", len(resp.Files))
+	}
+
+	if w, _ := get(q + "&scope=dir"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown scope to be rejected, got %d", w.Code)
+	}
+}
+
+func TestHandleFilterRanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Now()
+	for _, f := range []struct {
+		name  string
+		size  int
+		mtime time.Time
+	}{
+		{"empty.nfo", 0, now.Add(-time.Hour)},
+		{"small.mkv", 100, now.Add(-48 * time.Hour)},
+		{"big.mkv", 3000, now.Add(-time.Hour)},
+		{"old.mkv", 3000, time.Date(2020, 6, 1, 12, 0, 0, 0, time.Local)},
+	} {
+		path := filepath.Join(tmpDir, f.name)
+		os.WriteFile(path, make([]byte, f.size), 0644)
+		os.Chtimes(path, f.mtime, f.mtime)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query    string
+		want     []string
+		wantCode int
+	}{
+		{"min_size=1K", []string{"big.mkv", "old.mkv"}, http.StatusOK},
+		{"max_size=0", []string{"empty.nfo"}, http.StatusOK},
+		{"min_size=50&max_size=200", []string{"small.mkv"}, http.StatusOK},
+		{"modified_after=1d", []string{"big.mkv", "empty.nfo"}, http.StatusOK},
+		{"modified_after=1d&q=*.mkv", []string{"big.mkv"}, http.StatusOK},
+		{"modified_before=2021-01-01", []string{"old.mkv"}, http.StatusOK},
+		{"modified_after=2020-06-01&modified_before=2020-06-02", []string{"old.mkv"}, http.StatusOK},
+		{"modified_after=2020-06-01T12:00:01Z&modified_before=36h&min_size=1K", nil, http.StatusOK},
+		{"min_size=lots", nil, http.StatusBadRequest},
+		{"min_size=2K&max_size=1K", nil, http.StatusBadRequest},
+		{"modified_after=yesterday", nil, http.StatusBadRequest},
+		{"modified_after=2021-01-01&modified_before=2020-01-01", nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?sort=name&"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			var got []string
+			for _, f := range resp.Files {
+				got = append(got, f.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleFilterMultiplePatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"Movie.1080p.x265.mkv", "Movie.1080p.x264.mkv", "Movie.720p.x265.mkv", "Movie.480p.avi"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query    string
+		want     []string
+		wantCode int
+	}{
+		{"q=*1080p*&q=*x265*", []string{"Movie.1080p.x265.mkv"}, http.StatusOK},
+		{"q=*1080p*&q=*x265*&op=and", []string{"Movie.1080p.x265.mkv"}, http.StatusOK},
+		{"q=*1080p*&q=*x265*&op=or", []string{"Movie.1080p.x264.mkv", "Movie.1080p.x265.mkv", "Movie.720p.x265.mkv"}, http.StatusOK},
+		{"q=*.avi&q=&op=or", []string{"Movie.480p.avi"}, http.StatusOK},
+		{"mode=regex&q=" + url.QueryEscape(`\d{3,4}p`) + "&q=" + url.QueryEscape(`x26[45]`), []string{"Movie.1080p.x264.mkv", "Movie.1080p.x265.mkv", "Movie.720p.x265.mkv"}, http.StatusOK},
+		{"mode=regex&q=*.mkv&q=(", nil, http.StatusBadRequest},
+		{"q=*.mkv&op=xor", nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?sort=name&"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			var got []string
+			for _, f := range resp.Files {
+				got = append(got, f.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "lister.sock")
+
+	l, err := listenUnix(sock)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+
+	go http.Serve(l, http.HandlerFunc(handleHealth))
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://unix/health")
+	if err != nil {
+		t.Fatalf("request over socket failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	// Simulate a stale socket from an unclean exit: the file stays behind.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if _, err := os.Stat(sock); err != nil {
+		t.Fatalf("expected stale socket file to remain: %v", err)
+	}
+
+	l, err = listenUnix(sock)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	l.Close()
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("expected socket file to be removed on close, got %v", err)
+	}
+
+	regular := filepath.Join(t.TempDir(), "not-a-socket")
+	os.WriteFile(regular, []byte("data"), 0644)
+	if _, err := listenUnix(regular); err == nil {
+		t.Error("expected error when path is a regular file")
+	}
+}
+
+func TestHandleFilterRank(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"The.Matrix.Reloaded.mkv",
+		"matrix",
+		"Matrix.1999.mkv",
+		"Matrix.mkv",
+		"Behind.The.Matrix",
+	} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/filter?q=*matrix*&rank=1", nil)
+	w := httptest.NewRecorder()
+	handleFilter(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := []struct {
+		name  string
+		score int
+	}{
+		{"matrix", scoreExact},
+		{"Matrix.mkv", scorePrefix},
+		{"Matrix.1999.mkv", scorePrefix},
+		{"Behind.The.Matrix", scoreSuffix},
+		{"The.Matrix.Reloaded.mkv", scoreContains},
+	}
+	if len(resp.Files) != len(want) {
+		t.Fatalf("expected %d files, got %d", len(want), len(resp.Files))
+	}
+	for i, w := range want {
+		if resp.Files[i].Name != w.name || resp.Files[i].Score != w.score {
+			t.Errorf("position %d: expected %s (score %d), got %s (score %d)",
+				i, w.name, w.score, resp.Files[i].Name, resp.Files[i].Score)
+		}
+	}
+}
+
+func TestHandleListETag(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("test"), 0644)
+	config.Dirs = []string{tmpDir}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(target, "/filter") {
+			handleFilter(w, req)
+		} else {
+			handleList(w, req)
+		}
+		return w
+	}
+
+	for _, target := range []string{"/list", "/filter?q=*.mkv"} {
+		first := get(target, "")
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: expected 200 with an ETag, got %d %q", target, first.Code, etag)
+		}
+
+		for _, header := range []string{etag, "W/" + etag, `"other", ` + etag} {
+			w := get(target, header)
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+				t.Errorf("%s: expected an empty 304 for If-None-Match %s, got %d", target, header, w.Code)
+			}
+		}
+		if w := get(target, `"other"`); w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200 for a stale ETag, got %d", target, w.Code)
+		}
+	}
+
+	// A size change keeps the path set, and so X-Content-Version, but not the ETag.
+	etag := get("/list", "").Header().Get("ETag")
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("longer"), 0644)
+	if w := get("/list", etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected a new ETag after the file changed, got %d %s", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestHandleVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.mkv"), []byte("test"), 0644)
+	config.Dirs = []string{tmpDir}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	get := func() VersionResponse {
+		w := httptest.NewRecorder()
+		handleVersion(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+		var resp VersionResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Header().Get("X-Content-Version") != resp.Version {
+			t.Errorf("expected X-Content-Version to match the body, got %q and %q", w.Header().Get("X-Content-Version"), resp.Version)
+		}
+		return resp
+	}
+
+	resp := get()
+	if resp.Version != computeVersion(context.Background()) || resp.Files != 2 {
+		t.Errorf("expected the current version over 2 files, got %+v", resp)
+	}
+	if time.Since(resp.ScannedAt) > time.Minute {
+		t.Errorf("expected a fresh scan time without an index, got %s", resp.ScannedAt)
+	}
+
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+	index.scan()
+	if resp := get(); !resp.ScannedAt.Equal(index.lastScan()) {
+		t.Errorf("expected the index scan time %s, got %s", index.lastScan(), resp.ScannedAt)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// mdnsService is the DNS-SD service type listers advertise and browse for.
+const mdnsService = "_fslister._tcp"
+
+// mdnsBrowseInterval is how long each discovery round listens. Instances not
+// seen for mdnsExpiryRounds rounds are forgotten.
+var mdnsBrowseInterval = time.Minute
+
+const mdnsExpiryRounds = 3
+
+// DiscoveredPeer is another lister found on the local network.
+type DiscoveredPeer struct {
+	Name       string    `json:"name"` // its advertised friendly name
+	URL        string    `json:"url"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+type PeersResponse struct {
+	Host       string           `json:"host"`
+	Configured []string         `json:"configured"` // --peer URLs
+	Discovered []DiscoveredPeer `json:"discovered"` // found via --mdns
+}
+
+// peerDirectory holds the listers found by mDNS, keyed by instance ID (or
+// name, for instances that don't advertise one).
+type peerDirectory struct {
+	mu    sync.Mutex
+	peers map[string]DiscoveredPeer
+}
+
+var discovered = &peerDirectory{peers: make(map[string]DiscoveredPeer)}
+
+// advertise announces this lister on the local network as a _fslister._tcp
+// service on port, named after --friendlyname, with scheme=https in its TXT
+// record when serving TLS. Shut the server down on exit so other instances
+// forget it straight away.
+func advertise(port int, https bool) (*zeroconf.Server, error) {
+	txt := []string{"instance_id=" + instanceID}
+	if https {
+		txt = append(txt, "scheme=https")
+	}
+	return zeroconf.Register(config.FriendlyName, mdnsService, "local.", port, txt, nil)
+}
+
+// browse looks for other listers forever, one mdnsBrowseInterval round at a
+// time, expiring instances that stop answering.
+func (d *peerDirectory) browse() {
+	for {
+		start := time.Now()
+		if err := d.browseOnce(); err != nil {
+			slog.Warn("Error browsing for peers", "err", err)
+		}
+		d.expire(time.Now().Add(-mdnsExpiryRounds * mdnsBrowseInterval))
+		time.Sleep(time.Until(start.Add(mdnsBrowseInterval)))
+	}
+}
+
+func (d *peerDirectory) browseOnce() error {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mdnsBrowseInterval)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, mdnsService, "local.", entries); err != nil {
+		return err
+	}
+	for {
+		select {
+		case e, ok := <-entries:
+			if !ok {
+				return nil
+			}
+			d.seen(e, time.Now())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// seen records an answer from another lister, ignoring this one's own.
+func (d *peerDirectory) seen(e *zeroconf.ServiceEntry, at time.Time) {
+	id, scheme := "", "http"
+	for _, txt := range e.Text {
+		if v, ok := strings.CutPrefix(txt, "instance_id="); ok {
+			id = v
+		}
+		if txt == "scheme=https" {
+			scheme = "https"
+		}
+	}
+	if id == instanceID {
+		return
+	}
+
+	addrs := append(slices.Clone(e.AddrIPv4), e.AddrIPv6...)
+	if len(addrs) == 0 || e.Port == 0 {
+		return
+	}
+
+	key := id
+	if key == "" {
+		key = e.Instance
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.TTL == 0 {
+		delete(d.peers, key) // a goodbye: the instance is shutting down
+		return
+	}
+	d.peers[key] = DiscoveredPeer{
+		Name:       e.Instance,
+		URL:        scheme + "://" + net.JoinHostPort(addrs[0].String(), strconv.Itoa(e.Port)),
+		InstanceID: id,
+		LastSeen:   at,
+	}
+}
+
+// expire forgets instances last seen before cutoff.
+func (d *peerDirectory) expire(cutoff time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, p := range d.peers {
+		if p.LastSeen.Before(cutoff) {
+			delete(d.peers, key)
+		}
+	}
+}
+
+// list returns the discovered instances, sorted by name.
+func (d *peerDirectory) list() []DiscoveredPeer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peers := make([]DiscoveredPeer, 0, len(d.peers))
+	for _, p := range d.peers {
+		peers = append(peers, p)
+	}
+	slices.SortFunc(peers, func(a, b DiscoveredPeer) int { return strings.Compare(a.Name, b.Name) })
+	return peers
+}
+
+// peerURLs returns every peer to fan out to: the --peer URLs, then any
+// discovered instances not among them.
+func peerURLs() []string {
+	urls := slices.Clone(config.Peers)
+	for _, p := range discovered.list() {
+		if !slices.Contains(urls, p.URL) {
+			urls = append(urls, p.URL)
+		}
+	}
+	return urls
+}
+
+func handlePeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PeersResponse{
+		Host:       config.FriendlyName,
+		Configured: append([]string{}, config.Peers...),
+		Discovered: discovered.list(),
+	})
+}
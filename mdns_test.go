@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func TestPeerDirectory(t *testing.T) {
+	d := &peerDirectory{peers: make(map[string]DiscoveredPeer)}
+	entry := func(name, id string, ttl uint32) *zeroconf.ServiceEntry {
+		e := zeroconf.NewServiceEntry(name, mdnsService, "local.")
+		e.AddrIPv4 = []net.IP{net.ParseIP("192.168.1.20")}
+		e.Port = 8080
+		e.Text = []string{"instance_id=" + id}
+		e.TTL = ttl
+		return e
+	}
+
+	now := time.Now()
+	d.seen(entry("nas", "abc", 120), now)
+	d.seen(entry("self", instanceID, 120), now)
+	d.seen(entry("old", "def", 120), now.Add(-time.Hour))
+
+	peers := d.list()
+	if len(peers) != 2 || peers[0].Name != "nas" || peers[0].URL != "http://192.168.1.20:8080" {
+		t.Fatalf("expected nas and old but not this instance, got %+v", peers)
+	}
+
+	secure := entry("pi", "ghi", 120)
+	secure.Text = append(secure.Text, "scheme=https")
+	d.seen(secure, now)
+	if peer := d.peers["ghi"]; peer.URL != "https://192.168.1.20:8080" {
+		t.Errorf("expected an https URL for a lister advertising scheme=https, got %q", peer.URL)
+	}
+	delete(d.peers, "ghi")
+
+	d.expire(now.Add(-time.Minute))
+	d.seen(entry("nas", "abc", 0), now)
+	if peers := d.list(); len(peers) != 0 {
+		t.Errorf("expected expiry and a goodbye to empty the directory, got %+v", peers)
+	}
+}
+
+func TestHandlePeers(t *testing.T) {
+	discovered.peers["abc"] = DiscoveredPeer{Name: "nas", URL: "http://192.168.1.20:8080", InstanceID: "abc", LastSeen: time.Now()}
+	config.Peers = []string{"http://pi:8080", "http://192.168.1.20:8080"}
+	t.Cleanup(func() {
+		delete(discovered.peers, "abc")
+		config.Peers = nil
+	})
+
+	w := httptest.NewRecorder()
+	handlePeers(w, httptest.NewRequest(http.MethodGet, "/peers", nil))
+
+	var resp PeersResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Configured) != 2 || len(resp.Discovered) != 1 || resp.Discovered[0].Name != "nas" {
+		t.Errorf("expected 2 configured and 1 discovered peer, got %+v", resp)
+	}
+
+	if urls := peerURLs(); !slices.Equal(urls, config.Peers) {
+		t.Errorf("expected a discovered peer that is also configured to be queried once, got %v", urls)
+	}
+}
@@ -11,6 +11,7 @@ Usage:
 
 import argparse
 import json
+import ssl
 import sys
 from pathlib import Path
 
@@ -51,10 +52,25 @@ def store_versions(collection, versions: dict[str, str]):
     collection.modify(metadata={"versions": json.dumps(versions)})
 
 
+def host_headers(host: dict) -> dict[str, str]:
+    """Headers for requests to a host, with its --auth-token if configured."""
+    if token := host.get("token"):
+        return {"Authorization": f"Bearer {token}"}
+    return {}
+
+
+def host_verify(host: dict) -> ssl.SSLContext | bool:
+    """TLS verification for a host: trust only its "ca" certificate file if
+    given (e.g. a --tls-self-signed server's cert.pem), else the system CAs."""
+    if ca := host.get("ca"):
+        return ssl.create_default_context(cafile=ca)
+    return True
+
+
 def fetch_host_version(host: dict) -> str | None:
     """Fetch version from a host's health endpoint."""
     try:
-        resp = httpx.get(f"{host['url']}/health", timeout=5)
+        resp = httpx.get(f"{host['url']}/health", headers=host_headers(host), verify=host_verify(host), timeout=5)
         resp.raise_for_status()
         return resp.json().get("version")
     except Exception as e:
@@ -147,7 +163,7 @@ def cmd_index(args):
         # Fetch full file list
         print(f"  Changes detected, fetching files...")
         try:
-            resp = httpx.get(f"{host['url']}/list", timeout=30)
+            resp = httpx.get(f"{host['url']}/list", headers=host_headers(host), verify=host_verify(host), timeout=30)
             resp.raise_for_status()
             data = resp.json()
             files = [{"name": f["name"], "path": f["path"]} for f in data["files"]]
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo is what parseMediaName makes of a file name. Fields it couldn't
+// find are omitted.
+type MediaInfo struct {
+	Title      string `json:"title,omitempty"`
+	Year       int    `json:"year,omitempty"`
+	Resolution string `json:"resolution,omitempty"` // e.g. 1080p; 4K and UHD are 2160p
+	Codec      string `json:"codec,omitempty"`      // h264, h265, xvid, divx, av1 or vp9
+	Season     *int   `json:"season,omitempty"`
+	Episode    *int   `json:"episode,omitempty"`
+}
+
+var (
+	mediaEpisodePattern    = regexp.MustCompile(`(?i)\bs(\d{1,2})[ .]?e(\d{1,3})|\b(\d{1,2})x(\d{2,3})\b`)
+	mediaYearPattern       = regexp.MustCompile(`\b(?:19|20)\d\d\b`)
+	mediaResolutionPattern = regexp.MustCompile(`(?i)\b(\d{3,4}[pi]|4k|uhd)\b`)
+	mediaCodecPattern      = regexp.MustCompile(`(?i)\b(x26[45]|h\.?26[45]|hevc|avc|xvid|divx|av1|vp9)\b`)
+	// mediaTagPattern finds release tags that end a title even when nothing
+	// else is recognised, e.g. Some.Film.BluRay.mkv.
+	mediaTagPattern = regexp.MustCompile(`(?i)\b(bluray|blu-ray|bdrip|brrip|web-?dl|webrip|hdtv|dvdrip|remux|proper|repack)\b`)
+	// mediaGroupPattern is a leading [release group].
+	mediaGroupPattern = regexp.MustCompile(`^\[[^\]]*\]\s*`)
+)
+
+// parseMediaName extracts the title, year, resolution, video codec, season
+// and episode from a scene-style file name such as
+// Edge.of.Darkness.2010.1080p.BluRay.x264.mkv or Show.Name.S02E05.720p.mkv.
+// The title is everything before the first of the others, with dots and
+// underscores read as spaces. A year at the very start is taken as part of
+// the title (2012.2009.mkv is 2012, from 2009).
+func parseMediaName(name string) MediaInfo {
+	if ext := filepath.Ext(name); len(ext) > 1 && len(ext) <= 5 {
+		name = strings.TrimSuffix(name, ext)
+	}
+	name = mediaGroupPattern.ReplaceAllString(name, "")
+	// Underscores are word characters, which would hide the \b boundaries.
+	name = strings.ReplaceAll(name, "_", " ")
+
+	var info MediaInfo
+	end := len(name)
+	cut := func(loc []int) {
+		if loc != nil && loc[0] > 0 {
+			end = min(end, loc[0])
+		}
+	}
+
+	if m := mediaEpisodePattern.FindStringSubmatchIndex(name); m != nil {
+		g := m[2:6] // S01E02
+		if g[0] < 0 {
+			g = m[6:10] // 1x02
+		}
+		s, e := name[g[0]:g[1]], name[g[2]:g[3]]
+		season, _ := strconv.Atoi(s)
+		episode, _ := strconv.Atoi(e)
+		info.Season, info.Episode = &season, &episode
+		cut(m)
+	}
+	// The last year is the release year: 2001.A.Space.Odyssey.1968.
+	if years := mediaYearPattern.FindAllStringIndex(name, -1); len(years) > 0 {
+		if loc := years[len(years)-1]; loc[0] > 0 {
+			info.Year, _ = strconv.Atoi(name[loc[0]:loc[1]])
+			cut(loc)
+		}
+	}
+	if loc := mediaResolutionPattern.FindStringIndex(name); loc != nil {
+		info.Resolution = normalizeResolution(name[loc[0]:loc[1]])
+		cut(loc)
+	}
+	if loc := mediaCodecPattern.FindStringIndex(name); loc != nil {
+		info.Codec = normalizeCodec(name[loc[0]:loc[1]])
+		cut(loc)
+	}
+	cut(mediaTagPattern.FindStringIndex(name))
+
+	title := strings.ReplaceAll(name[:end], ".", " ")
+	info.Title = strings.Join(strings.Fields(strings.TrimRight(title, " -([")), " ")
+	return info
+}
+
+// normalizeResolution lowercases a resolution and turns 4K and UHD into
+// 2160p, for both parsed names and ?resolution=.
+func normalizeResolution(s string) string {
+	s = strings.ToLower(s)
+	if s == "4k" || s == "uhd" {
+		return "2160p"
+	}
+	return s
+}
+
+// normalizeCodec names a video codec the same however a release spells it:
+// x264, H.264 and AVC are all h264.
+func normalizeCodec(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), ".", "")
+	switch s {
+	case "x264", "avc":
+		return "h264"
+	case "x265", "hevc":
+		return "h265"
+	}
+	return s
+}
+
+// mediaFilter holds /filter's year, resolution, codec, season and episode
+// parameters, matched against each name's MediaInfo. Zero values are unset,
+// except season and episode, which are unset when negative so that season=0
+// finds specials.
+type mediaFilter struct {
+	year            int
+	resolution      string
+	codec           string
+	season, episode int
+}
+
+func parseMediaFilter(r *http.Request) (mediaFilter, error) {
+	f := mediaFilter{
+		resolution: normalizeResolution(r.URL.Query().Get("resolution")),
+		codec:      normalizeCodec(r.URL.Query().Get("codec")),
+		season:     -1,
+		episode:    -1,
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"year", &f.year}, {"season", &f.season}, {"episode", &f.episode}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return f, fmt.Errorf("invalid '%s' parameter: %q", p.name, v)
+			}
+			*p.dst = n
+		}
+	}
+	return f, nil
+}
+
+func (f mediaFilter) active() bool {
+	return f.year > 0 || f.resolution != "" || f.codec != "" || f.season >= 0 || f.episode >= 0
+}
+
+func (f mediaFilter) match(info MediaInfo) bool {
+	return (f.year == 0 || info.Year == f.year) &&
+		(f.resolution == "" || info.Resolution == f.resolution) &&
+		(f.codec == "" || info.Codec == f.codec) &&
+		(f.season < 0 || info.Season != nil && *info.Season == f.season) &&
+		(f.episode < 0 || info.Episode != nil && *info.Episode == f.episode)
+}
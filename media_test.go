@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseMediaName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // title|year|resolution|codec|season|episode
+	}{
+		{"Edge.of.Darkness.2010.1080p.BluRay.x264.mkv", "Edge of Darkness|2010|1080p|h264|-|-"},
+		{"Other.Movie.720p.mkv", "Other Movie|0|720p||-|-"},
+		{"Show.Name.S02E05.720p.HDTV.x265.mkv", "Show Name|0|720p|h265|2|5"},
+		{"Show Name - 3x07 - Episode Title.avi", "Show Name|0|||3|7"},
+		{"Show.Name.S00E01.Special.mkv", "Show Name|0|||0|1"},
+		{"The_Film_(1999)_H.264_4K.mp4", "The Film|1999|2160p|h264|-|-"},
+		{"2001.A.Space.Odyssey.1968.UHD.HEVC.mkv", "2001 A Space Odyssey|1968|2160p|h265|-|-"},
+		{"[Group] Film.2015.mkv", "Film|2015|||-|-"},
+		{"Some.Film.BluRay.mkv", "Some Film|0|||-|-"},
+		{"holiday.jpg", "holiday|0|||-|-"},
+	}
+
+	ptr := func(p *int) string {
+		if p == nil {
+			return "-"
+		}
+		return fmt.Sprint(*p)
+	}
+	for _, tt := range tests {
+		m := parseMediaName(tt.name)
+		got := fmt.Sprintf("%s|%d|%s|%s|%s|%s", m.Title, m.Year, m.Resolution, m.Codec, ptr(m.Season), ptr(m.Episode))
+		if got != tt.want {
+			t.Errorf("parseMediaName(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleFilterMedia(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"Edge.of.Darkness.2010.1080p.BluRay.x264.mkv",
+		"Inception.2010.720p.mkv",
+		"Heat.1995.1080p.x265.mkv",
+		"Show.S01E02.1080p.mkv",
+	} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query     string
+		wantCount int
+		wantCode  int
+	}{
+		{"year=2010", 2, http.StatusOK},
+		{"year=2010&resolution=1080P", 1, http.StatusOK},
+		{"resolution=1080p", 3, http.StatusOK},
+		{"codec=avc", 1, http.StatusOK},
+		{"codec=hevc", 1, http.StatusOK},
+		{"season=1&episode=2", 1, http.StatusOK},
+		{"q=*darkness*&year=1995", 0, http.StatusOK},
+		{"year=twenty-ten", 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?"+tt.query, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if len(resp.Files) != tt.wantCount {
+				t.Errorf("expected %d files, got %d", tt.wantCount, len(resp.Files))
+			}
+		})
+	}
+
+	// ?media=1 adds the parsed fields to each entry.
+	w := httptest.NewRecorder()
+	handleFilter(w, httptest.NewRequest(http.MethodGet, "/filter?season=1&media=1", nil))
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 || resp.Files[0].Media == nil || resp.Files[0].Media.Title != "Show" || resp.Files[0].Media.Resolution != "1080p" {
+		t.Errorf("expected media fields on Show.S01E02, got %s", w.Body.String())
+	}
+}
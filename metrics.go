@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request latency
+// histogram: Prometheus's defaults stretched to a minute for slow walks.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// requestMetrics counts requests and their latencies per route.
+type requestMetrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]int64
+	durations map[string]*histogram
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+type histogram struct {
+	buckets []int64 // per durationBuckets; not cumulative
+	count   int64
+	sum     float64
+}
+
+var httpMetrics = newRequestMetrics()
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{requests: make(map[requestKey]int64), durations: make(map[string]*histogram)}
+}
+
+// withMetrics records each request's status and duration against the mux
+// pattern that serves it, so paths with query strings or typos don't each
+// get their own series. Requests no route matches count as "none".
+func withMetrics(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		handler := "none"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			handler = pattern
+		}
+		httpMetrics.observe(handler, sw.status, time.Since(start))
+	})
+}
+
+func (m *requestMetrics) observe(handler string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestKey{handler, code}]++
+
+	h, ok := m.durations[handler]
+	if !ok {
+		h = &histogram{buckets: make([]int64, len(durationBuckets))}
+		m.durations[handler] = h
+	}
+	secs := d.Seconds()
+	if i, _ := slices.BinarySearch(durationBuckets, secs); i < len(durationBuckets) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += secs
+}
+
+// write renders the counters in the Prometheus text format, sorted so
+// scrapes are stable.
+func (m *requestMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := slices.SortedFunc(maps.Keys(m.requests), func(a, b requestKey) int {
+		return cmp.Or(strings.Compare(a.handler, b.handler), cmp.Compare(a.code, b.code))
+	})
+	fmt.Fprintln(w, "# HELP fslister_http_requests_total Requests served, by route and status code.")
+	fmt.Fprintln(w, "# TYPE fslister_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "fslister_http_requests_total{handler=%s,code=\"%d\"} %d\n", labelValue(k.handler), k.code, m.requests[k])
+	}
+
+	handlers := slices.Sorted(maps.Keys(m.durations))
+	fmt.Fprintln(w, "# HELP fslister_http_request_duration_seconds Time to serve a request, by route.")
+	fmt.Fprintln(w, "# TYPE fslister_http_request_duration_seconds histogram")
+	for _, handler := range handlers {
+		h, label := m.durations[handler], labelValue(handler)
+		var cumulative int64
+		for i, le := range durationBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(w, "fslister_http_request_duration_seconds_bucket{handler=%s,le=\"%s\"} %d\n", label, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "fslister_http_request_duration_seconds_bucket{handler=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "fslister_http_request_duration_seconds_sum{handler=%s} %s\n", label, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "fslister_http_request_duration_seconds_count{handler=%s} %d\n", label, h.count)
+	}
+}
+
+// labelValue quotes s as a Prometheus label value.
+func labelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// handleMetrics serves request, index and scan metrics in the Prometheus
+// text exposition format. The index metrics are only present with an
+// in-memory index (--scan-interval or --watch).
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	httpMetrics.write(w)
+
+	fmt.Fprintln(w, "# HELP fslister_scan_errors_total Files and directories a walk couldn't read.")
+	fmt.Fprintln(w, "# TYPE fslister_scan_errors_total counter")
+	fmt.Fprintf(w, "fslister_scan_errors_total %d\n", scanErrors.Load())
+
+	if index == nil {
+		return
+	}
+	stats, took := index.stats()
+	fmt.Fprintln(w, "# HELP fslister_indexed_files Files in the in-memory index, by --dir.")
+	fmt.Fprintln(w, "# TYPE fslister_indexed_files gauge")
+	for _, st := range stats {
+		fmt.Fprintf(w, "fslister_indexed_files{dir=%s} %d\n", labelValue(st.dir), st.files)
+	}
+	fmt.Fprintln(w, "# HELP fslister_indexed_bytes Total size of the indexed files, by --dir.")
+	fmt.Fprintln(w, "# TYPE fslister_indexed_bytes gauge")
+	for _, st := range stats {
+		fmt.Fprintf(w, "fslister_indexed_bytes{dir=%s} %d\n", labelValue(st.dir), st.bytes)
+	}
+	if scanned := index.lastScan(); !scanned.IsZero() {
+		fmt.Fprintln(w, "# HELP fslister_last_scan_duration_seconds How long the last full index scan took.")
+		fmt.Fprintln(w, "# TYPE fslister_last_scan_duration_seconds gauge")
+		fmt.Fprintf(w, "fslister_last_scan_duration_seconds %s\n", strconv.FormatFloat(took.Seconds(), 'g', -1, 64))
+		fmt.Fprintln(w, "# HELP fslister_last_scan_timestamp_seconds When the index last read the disk, by a scan or a watch update.")
+		fmt.Fprintln(w, "# TYPE fslister_last_scan_timestamp_seconds gauge")
+		fmt.Fprintf(w, "fslister_last_scan_timestamp_seconds %d\n", scanned.Unix())
+	}
+}
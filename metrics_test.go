@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), make([]byte, 1000), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "show.mkv"), make([]byte, 234), 0644)
+
+	index = newFileIndex([]string{tmpDir})
+	index.scan()
+	saved := httpMetrics
+	httpMetrics = newRequestMetrics()
+	t.Cleanup(func() { index, httpMetrics = nil, saved })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	handler := withMetrics(mux, mux)
+	for _, target := range []string{"/list", "/list?q=x", "/list?fail=1", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		`fslister_http_requests_total{handler="/list",code="200"} 2`,
+		`fslister_http_requests_total{handler="/list",code="400"} 1`,
+		`fslister_http_requests_total{handler="none",code="404"} 1`,
+		`fslister_http_request_duration_seconds_bucket{handler="/list",le="+Inf"} 3`,
+		`fslister_http_request_duration_seconds_count{handler="/list"} 3`,
+		"fslister_indexed_files{dir=" + labelValue(tmpDir) + "} 2",
+		"fslister_indexed_bytes{dir=" + labelValue(tmpDir) + "} 1234",
+		"fslister_last_scan_duration_seconds ",
+		"fslister_scan_errors_total ",
+		"# TYPE fslister_http_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in metrics, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	if got := labelValue("C:\\media\n\"x\""); got != `"C:\\media\n\"x\""` {
+		t.Errorf("unexpected escaping: %s", got)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// route is one endpoint: main registers it and /openapi.json describes it
+// from the same entry, so the two can't drift apart. Response is a value of
+// the JSON type the handler encodes, whose schema is derived by reflection;
+// handlers that write something else give its ContentType instead.
+type route struct {
+	Pattern     string
+	Method      string // GET unless set
+	Handler     http.HandlerFunc
+	Summary     string
+	Params      []apiParam
+	Body        any // JSON request body, for POST
+	Response    any
+	ContentType string
+}
+
+// apiParam is a query parameter. Type is an OpenAPI primitive: string,
+// integer, number or boolean (the handlers accept 1/true via queryFlag).
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+}
+
+// listParams are the options parseListOptions reads for /list, /filter and
+// /search.
+var listParams = []apiParam{
+	{Name: "nosize", Type: "boolean", Description: "Skip the per-file stat; size is reported as 0"},
+	{Name: "parent", Type: "boolean", Description: "Include the name of each file's directory"},
+	{Name: "links", Type: "boolean", Description: "Include the hard link count (Unix)"},
+	{Name: "locked", Type: "boolean", Description: "Flag files locked by a writer"},
+	{Name: "xattrs", Type: "boolean", Description: "Include extended attributes"},
+	{Name: "media", Type: "boolean", Description: "Include the title, year, resolution, codec, season and episode read from each name"},
+	{Name: "hash", Type: "string", Description: "Add each file's content hash: sha256 or xxhash"},
+	{Name: "fieldmap", Type: "string", Description: "Rename file entry keys, e.g. path:filepath,size:bytes"},
+	{Name: "limit", Type: "integer", Description: "Page size; 0 returns everything"},
+	{Name: "offset", Type: "integer", Description: "Files to skip before the page"},
+	{Name: "sort", Type: "string", Description: "name, path, size or mtime"},
+	{Name: "order", Type: "string", Description: "asc or desc"},
+	{Name: "sep", Type: "string", Description: "Path separator to report: / or \\"},
+	{Name: "format", Type: "string", Description: "json (default), csv, tsv or xml; Accept: application/xml also selects xml"},
+	{Name: "dir", Type: "string", Description: "Walk only this configured directory (repeatable); default all"},
+	{Name: "strict", Type: "boolean", Description: "Answer 503 instead of a partial listing when directories or peers couldn't be read"},
+}
+
+// rangeParams are the size and time bounds parseRangeFilter reads.
+var rangeParams = []apiParam{
+	{Name: "min_size", Type: "string", Description: "Smallest size, e.g. 500MB"},
+	{Name: "max_size", Type: "string", Description: "Largest size, e.g. 5GB"},
+	{Name: "modified_after", Type: "string", Description: "RFC 3339 time, YYYY-MM-DD or an age like 7d"},
+	{Name: "modified_before", Type: "string", Description: "RFC 3339 time, YYYY-MM-DD or an age like 7d"},
+}
+
+// apiRoutes returns every endpoint the server serves. It is a function
+// rather than a variable because /openapi.json is one of them.
+func apiRoutes() []route {
+	return []route{
+		{Pattern: "/{$}", Handler: handleUI, Summary: "Web UI", ContentType: "text/html"},
+		{Pattern: "/list", Handler: handleList, Summary: "List all files", Response: ListResponse{}, Params: append([]apiParam{
+			{Name: "sample", Type: "integer", Description: "Return a random sample of this many files"},
+			{Name: "delta-from", Type: "string", Description: "Only files added since this X-Content-Version, plus removed paths"},
+			{Name: "wait-for-change", Type: "string", Description: "Long-poll until the content version differs from this one"},
+			{Name: "timeout", Type: "string", Description: "Longest wait for wait-for-change, e.g. 30s"},
+		}, listParams...)},
+		{Pattern: "/filter", Handler: handleFilter, Summary: "Filter files by name, extension, owner, size, time or media details", Response: ListResponse{}, Params: append(append([]apiParam{
+			{Name: "q", Type: "string", Description: "Pattern; repeat to combine with op"},
+			{Name: "mode", Type: "string", Description: "wildcard (default) or regex"},
+			{Name: "op", Type: "string", Description: "and (default) or or, for several q"},
+			{Name: "scope", Type: "string", Description: "Match q against the name (default) or the whole path"},
+			{Name: "ext", Type: "string", Description: "Extension, e.g. mkv or tar.gz"},
+			{Name: "exts", Type: "string", Description: "Brace-expanded extension set, e.g. {mkv,mp4}"},
+			{Name: "uid", Type: "integer", Description: "Owner uid (Unix)"},
+			{Name: "invalidutf8", Type: "boolean", Description: "Only names that aren't valid UTF-8"},
+			{Name: "preview", Type: "integer", Description: "Include the first N bytes of text files"},
+			{Name: "highlight", Type: "boolean", Description: "Include the offsets of the match"},
+			{Name: "rank", Type: "boolean", Description: "Score and sort by match quality"},
+			{Name: "year", Type: "integer", Description: "Release year read from the name"},
+			{Name: "resolution", Type: "string", Description: "Resolution read from the name, e.g. 1080p or 4k"},
+			{Name: "codec", Type: "string", Description: "Video codec read from the name: h264 (x264, avc), h265 (x265, hevc), xvid, divx, av1 or vp9"},
+			{Name: "season", Type: "integer", Description: "Season read from an S01E02 or 1x02 name"},
+			{Name: "episode", Type: "integer", Description: "Episode read from an S01E02 or 1x02 name"},
+		}, rangeParams...), listParams...)},
+		{Pattern: "/search", Handler: handleSearch, Summary: "Typo-tolerant name search, best match first", Response: ListResponse{}, Params: append([]apiParam{
+			{Name: "q", Type: "string", Description: "Words to look for", Required: true},
+		}, listParams...)},
+		{Pattern: "/health", Handler: handleHealth, Summary: "Health check", Response: HealthResponse{}},
+		{Pattern: "/version", Handler: handleVersion, Summary: "Content version, file count and scan time", Response: VersionResponse{}},
+		{Pattern: "/peers", Handler: handlePeers, Summary: "Configured and discovered peers", Response: PeersResponse{}},
+		{Pattern: "/latest-per-dir", Handler: handleLatestPerDir, Summary: "Newest file in each top-level directory", Response: LatestResponse{}},
+		{Pattern: "/counts-by-subdir", Handler: handleCountsBySubdir, Summary: "File count and size per top-level directory", Response: CountsResponse{}},
+		{Pattern: "/by-date", Handler: handleByDate, Summary: "Files per modification hour, day or month", Response: ByDateResponse{}, Params: []apiParam{
+			{Name: "granularity", Type: "string", Description: "hour, day (default) or month"},
+			{Name: "tz", Type: "string", Description: "IANA time zone, e.g. Europe/London"},
+		}},
+		{Pattern: "/longest-paths", Handler: handleLongestPaths, Summary: "Files with the longest paths", Response: LongestPathsResponse{}, Params: []apiParam{
+			{Name: "n", Type: "integer", Description: "How many (default 20, max 1000)"},
+		}},
+		{Pattern: "/size-histogram", Handler: handleSizeHistogram, Summary: "File counts and sizes by size bucket", Response: HistogramResponse{}, Params: []apiParam{
+			{Name: "buckets", Type: "string", Description: "Bucket boundaries, e.g. 1MB,100MB,1GB"},
+			{Name: "dedup", Type: "string", Description: "inode: count hardlinked bytes once"},
+		}},
+		{Pattern: "/verify", Method: http.MethodPost, Handler: handleVerify, Summary: "Check files against a path to sha256 manifest", Body: map[string]string{}, Response: VerifyResponse{}},
+		{Pattern: "/additions", Handler: handleAdditions, Summary: "Server-Sent Events for newly created files", ContentType: "text/event-stream"},
+		{Pattern: "/changes", Handler: handleChanges, Summary: "Journalled index changes since a seq or time (needs an index)", Response: ChangesResponse{}, Params: []apiParam{
+			{Name: "since", Type: "string", Description: "A seq from an earlier response, or an RFC 3339 time (default: since the process started)"},
+		}},
+		{Pattern: "/events", Handler: handleEvents, Summary: "Server-Sent Events of index changes, resumable with Last-Event-ID (needs an index)", ContentType: "text/event-stream", Params: []apiParam{
+			{Name: "last-event-id", Type: "integer", Description: "Replay journalled events after this seq, like the Last-Event-ID header"},
+		}},
+		{Pattern: "/ws", Handler: handleWS, Summary: "WebSocket of index changes, one ChangeEvent per message (needs an index)", Response: ChangeEvent{}},
+		{Pattern: "/bloom", Handler: handleBloom, Summary: "Bloom filter of file names", Response: BloomResponse{}, Params: []apiParam{
+			{Name: "bits", Type: "integer", Description: "Filter size in bits"},
+			{Name: "fpr", Type: "number", Description: "Target false positive rate (default 0.01)"},
+		}},
+		{Pattern: "/feed.xml", Handler: handleFeed, Summary: "Atom feed of recently modified files", ContentType: "application/atom+xml", Params: []apiParam{
+			{Name: "n", Type: "integer", Description: "How many entries (default 20)"},
+		}},
+		{Pattern: "/diff", Handler: handleDiff, Summary: "Compare two configured directories, or two snapshots (from/to; SnapshotDiffResponse)", Response: DiffResponse{}, Params: []apiParam{
+			{Name: "a", Type: "string", Description: "First directory (without from)"},
+			{Name: "b", Type: "string", Description: "Second directory (without from)"},
+			{Name: "from", Type: "string", Description: "Snapshot to compare from"},
+			{Name: "to", Type: "string", Description: "Snapshot to compare to, or now (default) for the live files"},
+		}},
+		{Pattern: "/snapshot", Method: http.MethodPost, Handler: handleSnapshot, Summary: "Save every file's path, size and mtime under --snapshot-dir", Response: SnapshotResponse{}, Params: []apiParam{
+			{Name: "name", Type: "string", Description: "Snapshot name (default: the UTC time, e.g. 20240501T120000Z)"},
+		}},
+		{Pattern: "/duplicates", Handler: handleDuplicates, Summary: "Probable duplicate files", Response: DuplicatesResponse{}, Params: []apiParam{
+			{Name: "hash", Type: "boolean", Description: "Confirm by sha256"},
+			{Name: "min_size", Type: "string", Description: "Ignore smaller files, e.g. 100MB"},
+		}},
+		{Pattern: "/hash", Handler: handleHash, Summary: "Content hash of one file", Response: HashResponse{}, Params: []apiParam{
+			{Name: "path", Type: "string", Description: "Reported path, as in /list", Required: true},
+			{Name: "algo", Type: "string", Description: "sha256 (default) or xxhash"},
+		}},
+		{Pattern: "/tree", Handler: handleTree, Summary: "Directory tree with file counts and sizes", Response: TreeResponse{}, Params: []apiParam{
+			{Name: "depth", Type: "integer", Description: "Levels to show; 0 (default) is all"},
+		}},
+		{Pattern: "/stats", Handler: handleStats, Summary: "Totals, largest files, extensions and disk space per directory", Response: StatsResponse{}, Params: []apiParam{
+			{Name: "top", Type: "integer", Description: "How many largest files (default 10)"},
+			{Name: "dedup", Type: "string", Description: "inode: count hardlinked bytes once"},
+		}},
+		{Pattern: "/download", Handler: handleDownload, Summary: "Download one file (Range supported)", ContentType: "application/octet-stream", Params: []apiParam{
+			{Name: "path", Type: "string", Description: "Reported path, as in /list", Required: true},
+		}},
+		{Pattern: "/metrics", Handler: handleMetrics, Summary: "Prometheus metrics", ContentType: "text/plain"},
+		{Pattern: "/openapi.json", Handler: handleOpenAPI, Summary: "This OpenAPI document", ContentType: "application/json"},
+	}
+}
+
+// registerRoutes adds every route to mux.
+func registerRoutes(mux *http.ServeMux) {
+	for _, rt := range apiRoutes() {
+		mux.HandleFunc(rt.Pattern, rt.Handler)
+	}
+}
+
+// handleOpenAPI serves an OpenAPI 3 description of apiRoutes.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPIDocument(apiRoutes()))
+}
+
+// openAPIDocument builds the document for routes. Response and body types
+// become component schemas named after their Go types.
+func openAPIDocument(routes []route) map[string]any {
+	schemas := map[string]any{}
+	errorSchema := schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	paths := map[string]any{}
+	for _, rt := range routes {
+		content := map[string]any{rt.ContentType: map[string]any{}}
+		if rt.Response != nil {
+			content = map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(rt.Response), schemas)}}
+		}
+		op := map[string]any{
+			"summary": rt.Summary,
+			"responses": map[string]any{
+				"200":     map[string]any{"description": "OK", "content": content},
+				"default": map[string]any{"description": "Error", "content": map[string]any{"application/json": map[string]any{"schema": errorSchema}}},
+			},
+		}
+		var params []any
+		for _, p := range rt.Params {
+			params = append(params, map[string]any{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      map[string]any{"type": p.Type},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(rt.Body), schemas)}},
+			}
+		}
+
+		method := rt.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		path := strings.TrimSuffix(rt.Pattern, "{$}")
+		paths[path] = map[string]any{strings.ToLower(method): op}
+	}
+
+	doc := map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": "filesystem-lister", "version": "1"},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+	if config.AuthToken != "" || config.BasicAuth != "" {
+		security := map[string]any{}
+		var requirements []any
+		if config.AuthToken != "" {
+			security["bearer"] = map[string]any{"type": "http", "scheme": "bearer"}
+			security["apiKey"] = map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"}
+			requirements = append(requirements, map[string]any{"bearer": []string{}}, map[string]any{"apiKey": []string{}})
+		}
+		if config.BasicAuth != "" {
+			security["basic"] = map[string]any{"type": "http", "scheme": "basic"}
+			requirements = append(requirements, map[string]any{"basic": []string{}})
+		}
+		doc["components"].(map[string]any)["securitySchemes"] = security
+		doc["security"] = requirements
+	}
+	return doc
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema of values of t as encoding/json writes
+// them. Named structs are added to schemas and referenced, so recursive
+// types such as TreeNode terminate.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return schemaFor(t.Elem(), schemas)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]any{"type": "string", "format": "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; ok {
+			return ref
+		}
+		schemas[t.Name()] = nil // placeholder while fields are walked
+		properties := map[string]any{}
+		var required []string
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = schemaFor(f.Type, schemas)
+			if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if required != nil {
+			schema["required"] = required
+		}
+		schemas[t.Name()] = schema
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestHandleOpenAPI(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name string `json:"name"`
+			} `json:"parameters"`
+			RequestBody any `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]any `json:"properties"`
+				Required   []string                  `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+		Security any `json:"security"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("expected openapi 3.0.3, got %q", doc.OpenAPI)
+	}
+
+	// Every registered route is described.
+	for _, rt := range apiRoutes() {
+		path := rt.Pattern
+		if path == "/{$}" {
+			path = "/"
+		}
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("route %s missing from the document", rt.Pattern)
+		}
+	}
+	if _, ok := doc.Paths["/verify"]["post"]; !ok || doc.Paths["/verify"]["post"].RequestBody == nil {
+		t.Error("expected /verify to be a POST with a request body")
+	}
+	var names []string
+	for _, p := range doc.Paths["/filter"]["get"].Parameters {
+		names = append(names, p.Name)
+	}
+	for _, want := range []string{"q", "mode", "min_size", "limit"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("expected /filter parameter %s, got %v", want, names)
+		}
+	}
+
+	// Schemas follow the Go types' JSON encoding.
+	entry := doc.Components.Schemas["FileEntry"]
+	if entry.Properties["mtime"]["format"] != "date-time" {
+		t.Errorf("expected mtime as date-time, got %v", entry.Properties["mtime"])
+	}
+	if !slices.Contains(entry.Required, "path") || slices.Contains(entry.Required, "score") {
+		t.Errorf("expected path required and omitempty score optional, got %v", entry.Required)
+	}
+	if ref := doc.Components.Schemas["TreeNode"].Properties["children"]["items"]; ref.(map[string]any)["$ref"] != "#/components/schemas/TreeNode" {
+		t.Errorf("expected TreeNode children to refer to TreeNode, got %v", ref)
+	}
+	if doc.Security != nil {
+		t.Errorf("expected no security requirement without auth, got %v", doc.Security)
+	}
+
+	config.AuthToken = "s3cret"
+	t.Cleanup(func() { config.AuthToken = "" })
+	w = httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	doc.Security = nil
+	json.Unmarshal(w.Body.Bytes(), &doc)
+	if doc.Security == nil {
+		t.Error("expected a security requirement with --auth-token")
+	}
+}
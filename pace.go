@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pacer spaces out operations to a steady rate. It is shared by every walk,
+// so concurrent scans split the budget instead of each getting the full rate.
+type pacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// scanPacer limits walk entries to --scan-io-rate per second; nil when unset.
+var scanPacer *pacer
+
+func newPacer(perSecond int) *pacer {
+	return &pacer{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the caller's slot comes up. A nil pacer never waits.
+func (p *pacer) wait() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	slot := p.next
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPacerSpacesOperations(t *testing.T) {
+	p := newPacer(100)
+
+	start := time.Now()
+	for range 6 {
+		p.wait()
+	}
+	// The first slot is immediate, the other five are 10ms apart.
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected at least 50ms for 6 operations at 100/s, took %s", elapsed)
+	}
+
+	var nilPacer *pacer
+	nilPacer.wait()
+}
+
+func TestWalkFilesScanIORate(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv", "d.mkv"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	scanPacer = newPacer(50)
+	t.Cleanup(func() { scanPacer = nil })
+
+	start := time.Now()
+	count := 0
+	walkFiles(context.Background(), []string{tmpDir}, func(path string, d fs.DirEntry) error {
+		count++
+		return nil
+	})
+	if elapsed := time.Since(start); count != 4 || elapsed < 60*time.Millisecond {
+		t.Errorf("expected 4 files paced over at least 60ms, got %d in %s", count, elapsed)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveReportedPath maps a path as reported in listings (including any
+// --path-prefix) back to the file on disk. It fails for paths that don't lie
+// under a configured directory: ones that use ".." to climb out, that reach
+// outside through a symlink, or that carry encoded separators (see
+// suspiciousPath), and for files a walk wouldn't list (see listed). Every
+// endpoint taking a path from the client goes through
+// here before touching the disk.
+func resolveReportedPath(p string) (string, bool) {
+	if suspiciousPath(p) {
+		return "", false
+	}
+	for _, root := range configuredDirs() {
+		root = filepath.Clean(root)
+		rr := reportedPath(root)
+		if !hasPathPrefix(p, rr) {
+			continue
+		}
+
+		real := filepath.Join(root, filepath.FromSlash(p[len(rr):]))
+		if hasPathPrefix(real, root) && underRoots(real) && listed(root, real) {
+			return real, true
+		}
+	}
+	return "", false
+}
+
+// listed reports whether a walk of root would list path: it is within
+// --max-depth, no directory between root and it is excluded or hidden, and
+// it passes scanIncludes itself. The root itself is always listed.
+func listed(root, path string) bool {
+	if path == root {
+		return true
+	}
+	if config.MaxDepth > 0 && pathDepth(root, path) > config.MaxDepth {
+		return false
+	}
+	for dir := filepath.Dir(path); dir != root && hasPathPrefix(dir, root); dir = filepath.Dir(dir) {
+		if excluded(dir) {
+			return false
+		}
+	}
+	return scanIncludes(path)
+}
+
+// suspiciousPath reports whether p holds a NUL byte or a percent-encoded
+// slash, backslash or dot. Query parameters are decoded once already, so
+// these only survive from double encoding, typically an attempt to get "../"
+// past a proxy that checks the path; no listed path needs them.
+func suspiciousPath(p string) bool {
+	if strings.ContainsRune(p, 0) {
+		return true
+	}
+	lower := strings.ToLower(p)
+	for _, enc := range []string{"%00", "%2e", "%2f", "%5c"} {
+		if strings.Contains(lower, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+// underRoots reports whether path, with every symlink along it resolved,
+// still lies under a configured directory (itself resolved). A link from one
+// root into another is fine; a link out of them all is not.
+func underRoots(path string) bool {
+	canonical, err := evalSymlinksExisting(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range configuredDirs() {
+		if root, err := evalSymlinksExisting(filepath.Clean(root)); err == nil && hasPathPrefix(canonical, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// evalSymlinksExisting is filepath.EvalSymlinks for paths that may not exist:
+// the longest existing ancestor is resolved and the rest joined on, so a
+// missing file is still checked against where it would be. A dangling link is
+// followed to its target, which could be created later.
+func evalSymlinksExisting(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return resolved, err
+	}
+	if target, err := os.Readlink(path); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return evalSymlinksExisting(target)
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolvedParent, err := evalSymlinksExisting(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolvedParent, filepath.Base(path)), nil
+}
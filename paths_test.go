@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveReportedPathEscapes(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "media")
+	other := filepath.Join(base, "backup")
+	outside := filepath.Join(base, "secret")
+	for _, dir := range []string{root, other, outside} {
+		os.Mkdir(dir, 0755)
+	}
+	os.WriteFile(filepath.Join(root, "movie.mkv"), []byte("movie"), 0644)
+	os.WriteFile(filepath.Join(other, "copy.mkv"), []byte("copy"), 0644)
+	os.WriteFile(filepath.Join(outside, "passwd"), []byte("secret"), 0644)
+
+	links := map[string]string{
+		"escape":     outside,
+		"escape.txt": filepath.Join(outside, "passwd"),
+		"to-backup":  other,
+		"to-movie":   filepath.Join(root, "movie.mkv"),
+		"dangling":   filepath.Join(outside, "gone"),
+		"via-dotdot": filepath.Join(root, "..", "secret"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	config.Dirs = []string{root, other}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	in := func(parts ...string) string { return filepath.Join(append([]string{root}, parts...)...) }
+	tests := []struct {
+		name string
+		path string
+		ok   bool
+	}{
+		{"plain file", in("movie.mkv"), true},
+		{"root itself", root, true},
+		{"missing file", in("gone.mkv"), true},
+		{"dotdot staying inside", root + "/season1/../movie.mkv", true},
+		{"dotdot out of the root", root + "/../secret/passwd", false},
+		{"dotdot into a sibling root", root + "/../backup/copy.mkv", false},
+		{"repeated dotdot", root + "/../../../../etc/passwd", false},
+		{"sibling with root as prefix", root + "-old/movie.mkv", false},
+		{"relative path", "movie.mkv", false},
+		{"encoded slash", root + "/..%2fsecret/passwd", false},
+		{"encoded dots", root + "/%2e%2e/secret/passwd", false},
+		{"upper-case encoding", root + "/%2E%2E%2Fsecret", false},
+		{"encoded backslash", root + "/..%5csecret", false},
+		{"NUL byte", in("movie.mkv\x00.txt"), false},
+		{"symlinked directory out", in("escape", "passwd"), false},
+		{"symlinked file out", in("escape.txt"), false},
+		{"missing file behind a link out", in("escape", "new.txt"), false},
+		{"dangling link out", in("dangling"), false},
+		{"link with dotdot target", in("via-dotdot", "passwd"), false},
+		{"link into another root", in("to-backup", "copy.mkv"), true},
+		{"link within the root", in("to-movie"), true},
+	}
+
+	for _, tt := range tests {
+		got, ok := resolveReportedPath(tt.path)
+		if ok != tt.ok {
+			t.Errorf("%s: resolveReportedPath(%q) = %q, %v; want ok=%v", tt.name, tt.path, got, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// peerTimeout bounds each fan-out request to a --peer, so one slow or hung
+// box delays a merged listing by at most this long.
+var peerTimeout = 30 * time.Second
+
+// noFanoutHeader marks requests made by an aggregator. A lister receiving one
+// answers with its own files only, so peers listing each other don't loop.
+const noFanoutHeader = "X-Lister-No-Fanout"
+
+// peerClient makes the fan-out requests; it decompresses gzip transparently.
+var peerClient = &http.Client{}
+
+// PeerStatus reports how one --peer answered a fanned-out request.
+type PeerStatus struct {
+	URL   string `json:"url"`
+	Host  string `json:"host,omitempty"`
+	Files int    `json:"files"`
+	Error string `json:"error,omitempty"`
+}
+
+// fanOut sends r to every peer (see peerURLs) concurrently and merges their files into
+// resp, tagging every file, local ones included, with the host it is on.
+// Unreachable or failing peers are listed in resp.Peers with their error and
+// set resp.Partial instead of failing the request; paths a peer couldn't read
+// join resp.Errors. Requests that came from an
+// aggregator are left alone.
+func fanOut(r *http.Request, resp *ListResponse) {
+	if r.Header.Get(noFanoutHeader) != "" {
+		return
+	}
+	peers := peerURLs()
+	if len(peers) == 0 {
+		return
+	}
+
+	for i := range resp.Files {
+		resp.Files[i].Host = resp.Host
+	}
+
+	results := make([]ListResponse, len(peers))
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = queryPeer(r, peer)
+		}()
+	}
+	wg.Wait()
+
+	resp.Peers = make([]PeerStatus, len(peers))
+	for i, peer := range peers {
+		status := PeerStatus{URL: peer, Host: results[i].Host, Files: len(results[i].Files)}
+		if errs[i] != nil {
+			requestLogger(r).Warn("Error querying peer", "peer", peer, "err", errs[i])
+			status.Error = errs[i].Error()
+			resp.Partial = true
+		}
+		for _, f := range results[i].Files {
+			f.Host = results[i].Host
+			resp.Files = append(resp.Files, f)
+		}
+		for _, e := range results[i].Errors {
+			e.Host = results[i].Host
+			resp.Errors = append(resp.Errors, e)
+		}
+		resp.Truncated = resp.Truncated || results[i].Truncated
+		resp.Peers[i] = status
+	}
+}
+
+// queryPeer repeats r against peer. Paging, sorting, separators and the
+// output format are stripped from the query, since they apply to the merged
+// listing, as are long-poll and delta parameters tied to this host's
+// version. The peer's own --fieldmap default is overridden with an
+// identity mapping so its entries decode as FileEntry. X-Allowed-Prefixes
+// and the request ID are passed on.
+func queryPeer(r *http.Request, peer string) (ListResponse, error) {
+	query := r.URL.Query()
+	for _, name := range []string{"limit", "offset", "sort", "order", "sep", "format", "wait-for-change", "timeout", "delta-from"} {
+		query.Del(name)
+	}
+	query.Set("fieldmap", "path:path")
+
+	ctx, cancel := context.WithTimeout(r.Context(), peerTimeout)
+	defer cancel()
+	u := strings.TrimSuffix(peer, "/") + r.URL.Path + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	req.Header.Set(noFanoutHeader, "1")
+	if prefixes, ok := r.Header["X-Allowed-Prefixes"]; ok {
+		req.Header["X-Allowed-Prefixes"] = prefixes
+	}
+	if id := requestID(r.Context()); id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return ListResponse{}, fmt.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		return ListResponse{}, fmt.Errorf("%s", resp.Status)
+	}
+
+	var list ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return ListResponse{}, fmt.Errorf("decoding response: %w", err)
+	}
+	return list, nil
+}
+
+// validPeerURL checks a --peer value is an absolute http(s) URL.
+func validPeerURL(peer string) error {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("want an http:// or https:// URL")
+	}
+	return nil
+}
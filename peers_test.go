@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleListFansOutToPeers(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "local.mkv"), []byte("test"), 0644)
+
+	var forwarded *http.Request
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+		json.NewEncoder(w).Encode(ListResponse{Host: "nas2", Files: []FileEntry{{Path: "/media/remote.mkv", Name: "remote.mkv", Size: 9}}})
+	}))
+	defer peer.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config.FriendlyName = "nas1"
+	config.Dirs = []string{tmpDir}
+	config.Peers = []string{peer.URL, down.URL}
+	t.Cleanup(func() { config.Dirs, config.Peers = nil, nil })
+
+	req := httptest.NewRequest(http.MethodGet, "/list?limit=10&sort=name", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 2 || resp.Files[0].Name != "local.mkv" || resp.Files[0].Host != "nas1" || resp.Files[1].Name != "remote.mkv" || resp.Files[1].Host != "nas2" {
+		t.Fatalf("expected local.mkv on nas1 and remote.mkv on nas2, got %+v", resp.Files)
+	}
+	if !resp.Partial || len(resp.Peers) != 2 || resp.Peers[0].Error != "" || resp.Peers[0].Files != 1 || resp.Peers[1].Error == "" {
+		t.Errorf("expected a partial result with the second peer failing, got partial=%v %+v", resp.Partial, resp.Peers)
+	}
+
+	if forwarded.URL.Query().Has("limit") || forwarded.URL.Query().Get("fieldmap") != "path:path" || forwarded.Header.Get(noFanoutHeader) == "" {
+		t.Errorf("expected an unpaged, unrenamed, no-fanout peer request, got %s %v", forwarded.URL, forwarded.Header)
+	}
+
+	// A request from another aggregator gets local files only.
+	req = httptest.NewRequest(http.MethodGet, "/filter?q=*.mkv", nil)
+	req.Header.Set(noFanoutHeader, "1")
+	w = httptest.NewRecorder()
+	handleFilter(w, req)
+	resp = ListResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 || resp.Files[0].Host != "" || resp.Peers != nil {
+		t.Errorf("expected only the untagged local file, got %+v", resp)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxPreviewBytes caps ?preview= so a search can't pull large chunks of every
+// matching file into the response.
+const maxPreviewBytes = 4096
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// isTextMIME reports whether a content type is something worth previewing.
+func isTextMIME(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/json", mediaType == "application/xml",
+		mediaType == "application/javascript", strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	return false
+}
+
+// readPreview returns up to n bytes from the start of the file at path as
+// valid UTF-8, or "" if the file isn't text or can't be read. The type comes
+// from the extension when known, otherwise from sniffing the content. The
+// bytes read are charged to budget; errReadBudgetExceeded is returned
+// without reading anything if it can't cover them.
+func readPreview(path string, n int, budget *readBudget) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+
+	want := max(n, sniffLen)
+	if !budget.reserve(int64(want)) {
+		return "", errReadBudgetExceeded
+	}
+
+	buf := make([]byte, want)
+	read, _ := io.ReadFull(f, buf)
+	budget.refund(int64(want - read))
+	buf = buf[:read]
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(buf)
+	}
+	if !isTextMIME(contentType) {
+		return "", nil
+	}
+
+	if len(buf) > n {
+		buf = buf[:n]
+	}
+	return strings.ToValidUTF8(string(buf), "\uFFFD"), nil
+}
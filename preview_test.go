@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadPreview(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name string, body []byte) string {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, body, 0644)
+		return path
+	}
+
+	tests := []struct {
+		name string
+		path string
+		n    int
+		want string
+	}{
+		{"text by extension", write("notes.txt", []byte("Hello, world")), 5, "Hello"},
+		{"text by sniffing", write("README", []byte("plain words")), 100, "plain words"},
+		{"binary", write("movie.mkv", []byte{0x1a, 0x45, 0xdf, 0xa3, 0x00}), 100, ""},
+		{"split rune", write("accent.txt", []byte("caf\xc3\xa9")), 4, "caf\uFFFD"},
+		{"missing", filepath.Join(tmpDir, "nope.txt"), 10, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := readPreview(tt.path, tt.n, nil); got != tt.want {
+				t.Errorf("readPreview() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFilterPreview(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "show.nfo.txt"), []byte(strings.Repeat("a", maxPreviewBytes+10)), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/filter?q=*show*&preview=100000", nil)
+	w := httptest.NewRecorder()
+	handleFilter(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 || len(resp.Files[0].Preview) != maxPreviewBytes {
+		t.Errorf("expected preview capped at %d bytes, got %+v", maxPreviewBytes, resp.Files)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/filter?q=*show*&preview=lots", nil)
+	w = httptest.NewRecorder()
+	handleFilter(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid preview, got %d", w.Code)
+	}
+}
+
+func TestHandleFilterPreviewReadBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte(strings.Repeat("text ", 200)), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	config.MaxReadBytes = 2 * sniffLen
+	t.Cleanup(func() { config.MaxReadBytes = 0 })
+
+	req := httptest.NewRequest(http.MethodGet, "/filter?q=*.txt&preview=100", nil)
+	w := httptest.NewRecorder()
+	handleFilter(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.Truncated {
+		t.Error("expected response to be marked truncated")
+	}
+	if len(resp.Files) != 3 {
+		t.Fatalf("expected listing to complete with 3 files, got %d", len(resp.Files))
+	}
+	previews := 0
+	for _, f := range resp.Files {
+		if f.Preview != "" {
+			previews++
+		}
+	}
+	if previews != 2 {
+		t.Errorf("expected 2 previews within budget, got %d", previews)
+	}
+}
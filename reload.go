@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// configMu guards config.Dirs and config.DirSettings, which reloadConfig can
+// replace while requests are running. They are only ever swapped for new
+// values, never modified, so a request can keep using the directories it
+// started with after a reload.
+var configMu sync.RWMutex
+
+// configuredDirs returns the directories currently being served.
+func configuredDirs() []string {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config.Dirs
+}
+
+func dirSettingsFor(dir string) dirSettings {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config.DirSettings[dir]
+}
+
+// reloadConfig re-reads the --config file and FSLISTER_* environment and
+// switches to the --dir list they now give. Requests already running finish
+// with the old directories; the index and watchers are moved to the new ones.
+// Only the directories are reloaded: other options need a restart, and a
+// --dir given on the command line can't be changed at all.
+func reloadConfig(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	values, err := configValues(fs, lookupEnv)
+	if err != nil {
+		return err
+	}
+	spec, ok := values["dir"]
+	if !ok {
+		slog.Info("Reload: --dir isn't set by the config file or environment, nothing to do")
+		return nil
+	}
+	dirs, settings, err := parseDirs(spec.values)
+	if err != nil {
+		return err
+	}
+
+	configMu.Lock()
+	old := config.Dirs
+	config.Dirs, config.DirSettings = dirs, settings
+	configMu.Unlock()
+
+	if slices.Equal(old, dirs) {
+		slog.Info("Reload: directories unchanged")
+		return nil
+	}
+	index.setDirs(dirs)
+	additions.setDirs(old, dirs)
+	slog.Info("Reload: now scanning directories", "dirs", dirs)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReloadConfigSwitchesDirs(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(a, "a.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(b, "b.mkv"), []byte("test"), 0644)
+	path := filepath.Join(t.TempDir(), "lister.toml")
+	os.WriteFile(path, []byte(`dir = ["`+filepath.ToSlash(a)+`"]`), 0644)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Var(new(stringsFlag), "dir", "")
+	flags.String("config", "", "")
+	flags.Parse([]string{"--config", path})
+	noEnv := func(string) (string, bool) { return "", false }
+
+	config.Dirs, config.DirSettings = []string{a}, map[string]dirSettings{}
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { config.Dirs, config.DirSettings, index = nil, nil, nil })
+	index.scan()
+
+	os.WriteFile(path, []byte(`dir = ["`+filepath.ToSlash(b)+`,workers=2"]`), 0644)
+	if err := reloadConfig(flags, noEnv); err != nil {
+		t.Fatal(err)
+	}
+	if dirs := configuredDirs(); !slices.Equal(dirs, []string{filepath.ToSlash(b)}) || dirSettingsFor(dirs[0]).Workers != 2 {
+		t.Fatalf("expected %s with 2 workers after reload, got %v", b, dirs)
+	}
+
+	// The added root is served from the index once its background scan ends.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		index.mu.RLock()
+		_, scanned := index.roots[configuredDirs()[0]]
+		index.mu.RUnlock()
+		if scanned || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var names []string
+	served, _ := index.walk(context.Background(), configuredDirs(), func(path string, d fs.DirEntry) error {
+		names = append(names, d.Name())
+		return nil
+	})
+	if !served || !slices.Equal(names, []string{"b.mkv"}) {
+		t.Errorf("expected the index to serve b.mkv, got served=%v %v", served, names)
+	}
+}
+
+func TestReloadConfigKeepsCommandLineDirs(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	path := filepath.Join(t.TempDir(), "lister.toml")
+	os.WriteFile(path, []byte(`dir = ["`+filepath.ToSlash(b)+`"]`), 0644)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Var(new(stringsFlag), "dir", "")
+	flags.String("config", "", "")
+	flags.Parse([]string{"--config", path, "--dir", a})
+	noEnv := func(string) (string, bool) { return "", false }
+
+	config.Dirs = []string{a}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	if err := reloadConfig(flags, noEnv); err != nil {
+		t.Fatal(err)
+	}
+	if dirs := configuredDirs(); !slices.Equal(dirs, []string{a}) {
+		t.Errorf("expected the command-line --dir to survive a reload, got %v", dirs)
+	}
+}
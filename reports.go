@@ -0,0 +1,550 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LatestEntry struct {
+	Directory string    `json:"directory"`
+	File      FileEntry `json:"file"`
+	ModTime   time.Time `json:"mtime"`
+}
+
+type LatestResponse struct {
+	Host        string        `json:"host"`
+	Directories []LatestEntry `json:"directories"`
+}
+
+// topLevelDir returns the immediate subdirectory of root that contains path,
+// or root itself for files sitting directly in root.
+func topLevelDir(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return root
+	}
+	first, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+	if !found {
+		return root
+	}
+	return filepath.Join(root, first)
+}
+
+// handleLatestPerDir returns the most recently modified file in each
+// immediate subdirectory of the configured roots (e.g. the newest episode of
+// each show). Files directly in a root are grouped under the root itself.
+func handleLatestPerDir(w http.ResponseWriter, r *http.Request) {
+	latest := make(map[string]LatestEntry)
+
+	for _, root := range configuredDirs() {
+		walkAllowed(r, []string{root}, func(path string, d fs.DirEntry) error {
+			info, err := d.Info()
+			if err != nil {
+				requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+				return nil
+			}
+
+			dir := topLevelDir(root, path)
+			if current, ok := latest[dir]; ok && !info.ModTime().After(current.ModTime) {
+				return nil
+			}
+
+			latest[dir] = LatestEntry{
+				Directory: reportedPath(dir),
+				File: FileEntry{
+					Path: reportedPath(path),
+					Name: d.Name(),
+					Size: info.Size(),
+				},
+				ModTime: info.ModTime(),
+			}
+			return nil
+		})
+	}
+	if walkCancelled(w, r) {
+		return
+	}
+
+	entries := make([]LatestEntry, 0, len(latest))
+	for _, e := range latest {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Directory < entries[j].Directory })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LatestResponse{Host: config.FriendlyName, Directories: entries})
+}
+
+type HistogramBucket struct {
+	Label     string `json:"label"`
+	Min       int64  `json:"min"`
+	Max       int64  `json:"max,omitempty"` // exclusive; omitted for the open-ended last bucket
+	Count     int    `json:"count"`
+	TotalSize int64  `json:"total_size"`
+}
+
+type HistogramResponse struct {
+	Host    string            `json:"host"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// byteUnits maps size suffixes to multipliers. Units are binary (1KB = 1024 bytes).
+var byteUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes like "512", "100MB" or "1.5GiB" into bytes. The
+// number must be plain decimal: ParseFloat's NaN, Inf, exponents and hex
+// floats are refused, as is anything that doesn't fit in an int64.
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+
+	if strings.Trim(value, "0123456789.") != "" {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	// float64(math.MaxInt64) rounds up to 2^63, which doesn't fit.
+	if err != nil || n < 0 || n*float64(mult) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// parseSizeBuckets turns a comma-separated list of increasing boundaries such
+// as "1MB,100MB,1GB" into histogram buckets: <1MB, 1MB-100MB, 100MB-1GB, >=1GB.
+func parseSizeBuckets(spec string) ([]HistogramBucket, error) {
+	parts := strings.Split(spec, ",")
+	buckets := make([]HistogramBucket, 0, len(parts)+1)
+
+	var lower int64
+	lowerLabel := ""
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		bound, err := parseByteSize(part)
+		if err != nil {
+			return nil, err
+		}
+		if bound <= lower {
+			return nil, fmt.Errorf("bucket boundaries must be positive and increasing, got %q", spec)
+		}
+
+		label := "<" + part
+		if lowerLabel != "" {
+			label = lowerLabel + "-" + part
+		}
+		buckets = append(buckets, HistogramBucket{Label: label, Min: lower, Max: bound})
+		lower, lowerLabel = bound, part
+	}
+
+	buckets = append(buckets, HistogramBucket{Label: ">=" + lowerLabel, Min: lower})
+	return buckets, nil
+}
+
+// parseDedup handles ?dedup=inode, returning an inodeSet to count each
+// physical file's size once, or nil when deduplication wasn't asked for.
+func parseDedup(r *http.Request) (inodeSet, error) {
+	switch dedup := r.URL.Query().Get("dedup"); dedup {
+	case "":
+		return nil, nil
+	case "inode":
+		return inodeSet{}, nil
+	default:
+		return nil, fmt.Errorf("invalid 'dedup' parameter: %q (want inode)", dedup)
+	}
+}
+
+// handleSizeHistogram counts files into size buckets. The boundaries come from
+// ?buckets= or, failing that, --size-buckets. With ?dedup=inode, hardlinked
+// files still count once per path but their bytes are only added once.
+func handleSizeHistogram(w http.ResponseWriter, r *http.Request) {
+	spec := r.URL.Query().Get("buckets")
+	if spec == "" {
+		spec = config.SizeBuckets
+	}
+
+	buckets, err := parseSizeBuckets(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	seen, err := parseDedup(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
+		}
+
+		size := info.Size()
+		i := sort.Search(len(buckets)-1, func(i int) bool { return size < buckets[i].Max })
+		buckets[i].Count++
+		if seen == nil || seen.firstSighting(info) {
+			buckets[i].TotalSize += size
+		}
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HistogramResponse{Host: config.FriendlyName, Buckets: buckets})
+}
+
+type SubdirCount struct {
+	Count     int   `json:"count"`
+	TotalSize int64 `json:"total_size"`
+}
+
+type RootCounts struct {
+	Root    string                 `json:"root"`
+	Subdirs map[string]SubdirCount `json:"subdirs"`
+}
+
+type CountsResponse struct {
+	Host  string       `json:"host"`
+	Roots []RootCounts `json:"roots"`
+}
+
+// handleCountsBySubdir returns, for each configured root, the number of files
+// and total bytes under each of its immediate subdirectories. Files sitting
+// directly in a root are counted under ".".
+func handleCountsBySubdir(w http.ResponseWriter, r *http.Request) {
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	dirs := configuredDirs()
+	roots := make([]RootCounts, 0, len(dirs))
+
+	for _, root := range dirs {
+		if !opts.reaches(reportedPath(root)) {
+			continue
+		}
+		subdirs := make(map[string]SubdirCount)
+
+		walkAllowed(r, []string{root}, func(path string, d fs.DirEntry) error {
+			info, err := d.Info()
+			if err != nil {
+				requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+				return nil
+			}
+
+			name := "."
+			if dir := topLevelDir(root, path); dir != root {
+				name = filepath.Base(dir)
+			}
+
+			c := subdirs[name]
+			c.Count++
+			c.TotalSize += info.Size()
+			subdirs[name] = c
+			return nil
+		})
+
+		roots = append(roots, RootCounts{Root: reportedPath(root), Subdirs: subdirs})
+	}
+	if walkCancelled(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CountsResponse{Host: config.FriendlyName, Roots: roots})
+}
+
+// dateLayouts maps /by-date granularities to the layout used for bucket keys.
+var dateLayouts = map[string]string{
+	"hour":  "2006-01-02T15",
+	"day":   "2006-01-02",
+	"month": "2006-01",
+}
+
+type DateCount struct {
+	Count     int   `json:"count"`
+	TotalSize int64 `json:"total_size"`
+}
+
+type ByDateResponse struct {
+	Host        string               `json:"host"`
+	Granularity string               `json:"granularity"`
+	Timezone    string               `json:"timezone"`
+	Dates       map[string]DateCount `json:"dates"`
+}
+
+// handleByDate buckets files by modification time, truncated to the hour, day
+// (default) or month in the server's local timezone or the one given by ?tz=.
+func handleByDate(w http.ResponseWriter, r *http.Request) {
+	granularity := r.URL.Query().Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+	layout, ok := dateLayouts[granularity]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'granularity' parameter: %q (want hour, day or month)", granularity))
+		return
+	}
+
+	loc := time.Local
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'tz' parameter: %q", tz))
+			return
+		}
+	}
+
+	dates := make(map[string]DateCount)
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
+		}
+
+		key := info.ModTime().In(loc).Format(layout)
+		c := dates[key]
+		c.Count++
+		c.TotalSize += info.Size()
+		dates[key] = c
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ByDateResponse{
+		Host:        config.FriendlyName,
+		Granularity: granularity,
+		Timezone:    loc.String(),
+		Dates:       dates,
+	})
+}
+
+const (
+	defaultLongestPaths = 20
+	maxLongestPaths     = 1000
+)
+
+type PathLength struct {
+	Path   string `json:"path"`
+	Length int    `json:"length"` // bytes in the on-disk path
+}
+
+type LongestPathsResponse struct {
+	Host  string       `json:"host"`
+	Paths []PathLength `json:"paths"`
+}
+
+// pathLengthHeap is a min-heap on Length, so the shortest of the current top
+// N is the one evicted when a longer path turns up.
+type pathLengthHeap []PathLength
+
+func (h pathLengthHeap) Len() int           { return len(h) }
+func (h pathLengthHeap) Less(i, j int) bool { return h[i].Length < h[j].Length }
+func (h pathLengthHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *pathLengthHeap) Push(x any)        { *h = append(*h, x.(PathLength)) }
+func (h *pathLengthHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// handleLongestPaths returns the ?n= (default 20) files with the longest
+// on-disk paths, to find ones that will break on systems with shorter path
+// limits. Memory is bounded by n however many files are walked.
+func handleLongestPaths(w http.ResponseWriter, r *http.Request) {
+	n := defaultLongestPaths
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'n' parameter: %q", v))
+			return
+		}
+		n = min(n, maxLongestPaths)
+	}
+
+	h := make(pathLengthHeap, 0, n)
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		p := PathLength{Path: path, Length: len(path)}
+		if h.Len() < n {
+			heap.Push(&h, p)
+		} else if p.Length > h[0].Length {
+			h[0] = p
+			heap.Fix(&h, 0)
+		}
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	paths := make([]PathLength, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		paths[i] = heap.Pop(&h).(PathLength)
+		paths[i].Path = reportedPath(paths[i].Path)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LongestPathsResponse{Host: config.FriendlyName, Paths: paths})
+}
+
+const (
+	defaultStatsTop = 10
+	maxStatsTop     = 1000
+)
+
+type FileSize struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type ExtensionCount struct {
+	Count     int   `json:"count"`
+	TotalSize int64 `json:"total_size"`
+}
+
+// DiskUsage is the space on the filesystem holding a root, in bytes.
+// Available is what unprivileged users can still write, which may be less
+// than Total minus Used.
+type DiskUsage struct {
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Available uint64 `json:"available"`
+}
+
+type RootStats struct {
+	Root       string                    `json:"root"`
+	Files      int                       `json:"files"`
+	TotalSize  int64                     `json:"total_size"`
+	Largest    []FileSize                `json:"largest"`
+	Extensions map[string]ExtensionCount `json:"extensions"`
+	Disk       *DiskUsage                `json:"disk,omitempty"`
+}
+
+type StatsResponse struct {
+	Host  string      `json:"host"`
+	Roots []RootStats `json:"roots"`
+}
+
+// fileSizeHeap is a min-heap on Size, like pathLengthHeap.
+type fileSizeHeap []FileSize
+
+func (h fileSizeHeap) Len() int           { return len(h) }
+func (h fileSizeHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h fileSizeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *fileSizeHeap) Push(x any)        { *h = append(*h, x.(FileSize)) }
+func (h *fileSizeHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// handleStats summarises each configured root: its file count and total
+// size, the ?top= (default 10) largest files, counts and sizes by lowercased
+// extension ("" for none), and the filesystem's total, used and available
+// space where the platform reports it. With ?dedup=inode, hardlinked files
+// still count once per path but their bytes are only added once.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	top := defaultStatsTop
+	if v := r.URL.Query().Get("top"); v != "" {
+		var err error
+		if top, err = strconv.Atoi(v); err != nil || top < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'top' parameter: %q", v))
+			return
+		}
+		top = min(top, maxStatsTop)
+	}
+	seen, err := parseDedup(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	dirs := configuredDirs()
+	roots := make([]RootStats, 0, len(dirs))
+	for _, root := range dirs {
+		if !opts.reaches(reportedPath(root)) {
+			continue
+		}
+		stats := RootStats{Root: reportedPath(root), Extensions: make(map[string]ExtensionCount)}
+		h := make(fileSizeHeap, 0, top)
+		walkAllowed(r, []string{root}, func(path string, d fs.DirEntry) error {
+			info, err := d.Info()
+			if err != nil {
+				requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+				return nil
+			}
+			size := info.Size()
+			counted := size
+			if seen != nil && !seen.firstSighting(info) {
+				counted = 0
+			}
+			stats.Files++
+			stats.TotalSize += counted
+
+			ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(d.Name()), "."))
+			c := stats.Extensions[ext]
+			c.Count++
+			c.TotalSize += counted
+			stats.Extensions[ext] = c
+
+			f := FileSize{Path: path, Size: size}
+			if h.Len() < top {
+				heap.Push(&h, f)
+			} else if top > 0 && size > h[0].Size {
+				h[0] = f
+				heap.Fix(&h, 0)
+			}
+			return nil
+		})
+
+		stats.Largest = make([]FileSize, len(h))
+		for i := len(h) - 1; i >= 0; i-- {
+			stats.Largest[i] = heap.Pop(&h).(FileSize)
+			stats.Largest[i].Path = reportedPath(stats.Largest[i].Path)
+		}
+		if disk, err := diskUsage(root); err == nil {
+			stats.Disk = disk
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			requestLogger(r).Warn("Error getting disk usage", "path", root, "err", err)
+		}
+		roots = append(roots, stats)
+	}
+	if walkCancelled(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatsResponse{Host: config.FriendlyName, Roots: roots})
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleLatestPerDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	showA := filepath.Join(tmpDir, "ShowA", "Season 01")
+	showB := filepath.Join(tmpDir, "ShowB")
+	os.MkdirAll(showA, 0755)
+	os.MkdirAll(showB, 0755)
+
+	base := time.Now().Add(-time.Hour)
+	write := func(path string, age time.Duration) {
+		os.WriteFile(path, []byte("test"), 0644)
+		os.Chtimes(path, base.Add(age), base.Add(age))
+	}
+	write(filepath.Join(showA, "e01.mkv"), 1*time.Minute)
+	write(filepath.Join(showA, "e02.mkv"), 3*time.Minute)
+	write(filepath.Join(showB, "e01.mkv"), 2*time.Minute)
+	write(filepath.Join(tmpDir, "loose.mkv"), 0)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/latest-per-dir", nil)
+	w := httptest.NewRecorder()
+	handleLatestPerDir(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var resp LatestResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := map[string]string{
+		tmpDir:                         filepath.Join(tmpDir, "loose.mkv"),
+		filepath.Join(tmpDir, "ShowA"): filepath.Join(showA, "e02.mkv"),
+		filepath.Join(tmpDir, "ShowB"): filepath.Join(showB, "e01.mkv"),
+	}
+	if len(resp.Directories) != len(want) {
+		t.Fatalf("expected %d directories, got %d", len(want), len(resp.Directories))
+	}
+	for _, e := range resp.Directories {
+		if want[e.Directory] != e.File.Path {
+			t.Errorf("directory %s: expected latest %s, got %s", e.Directory, want[e.Directory], e.File.Path)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"1KB", 1024, false},
+		{"100MB", 100 << 20, false},
+		{"1.5GiB", 3 << 29, false},
+		{"2g", 2 << 30, false},
+		{"lots", 0, true},
+		{"-1MB", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"+Inf", 0, true},
+		{"infinity", 0, true},
+		{"0x1p10", 0, true},
+		{"1e3", 0, true},
+		{"9223372036854775807", 0, true},
+		{"8EB", 0, true},
+		{"8388608TB", 0, true},
+		{"8388607TB", 8388607 << 40, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleSizeHistogram(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "tiny.txt"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "small.txt"), make([]byte, 100), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "medium.mkv"), make([]byte, 2048), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	config.SizeBuckets = "1MB,100MB,1GB"
+
+	t.Run("default buckets", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/size-histogram", nil)
+		w := httptest.NewRecorder()
+		handleSizeHistogram(w, req)
+
+		var resp HistogramResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Buckets) != 4 {
+			t.Fatalf("expected 4 buckets, got %d", len(resp.Buckets))
+		}
+		if resp.Buckets[0].Label != "<1MB" || resp.Buckets[0].Count != 3 || resp.Buckets[0].TotalSize != 2158 {
+			t.Errorf("unexpected first bucket %+v", resp.Buckets[0])
+		}
+		if resp.Buckets[3].Label != ">=1GB" {
+			t.Errorf("unexpected last bucket label %s", resp.Buckets[3].Label)
+		}
+	})
+
+	t.Run("query buckets", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/size-histogram?buckets=50,1KB", nil)
+		w := httptest.NewRecorder()
+		handleSizeHistogram(w, req)
+
+		var resp HistogramResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		got := []int{}
+		for _, b := range resp.Buckets {
+			got = append(got, b.Count)
+		}
+		if len(got) != 3 || got[0] != 1 || got[1] != 1 || got[2] != 1 {
+			t.Errorf("expected one file per bucket, got %v", got)
+		}
+		if resp.Buckets[1].Label != "50-1KB" {
+			t.Errorf("unexpected middle label %s", resp.Buckets[1].Label)
+		}
+	})
+
+	t.Run("invalid buckets", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/size-histogram?buckets=1GB,1MB", nil)
+		w := httptest.NewRecorder()
+		handleSizeHistogram(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandleCountsBySubdir(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "ShowA", "Season 01"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "ShowB"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "ShowA", "Season 01", "e01.mkv"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "ShowA", "Season 01", "e02.mkv"), make([]byte, 20), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "ShowB", "e01.mkv"), make([]byte, 5), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "loose.mkv"), make([]byte, 1), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/counts-by-subdir", nil)
+	w := httptest.NewRecorder()
+	handleCountsBySubdir(w, req)
+
+	var resp CountsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Roots) != 1 || resp.Roots[0].Root != tmpDir {
+		t.Fatalf("expected one root %s, got %+v", tmpDir, resp.Roots)
+	}
+
+	want := map[string]SubdirCount{
+		"ShowA": {Count: 2, TotalSize: 30},
+		"ShowB": {Count: 1, TotalSize: 5},
+		".":     {Count: 1, TotalSize: 1},
+	}
+	got := resp.Roots[0].Subdirs
+	if len(got) != len(want) {
+		t.Errorf("expected %d subdirs, got %v", len(want), got)
+	}
+	for name, c := range want {
+		if got[name] != c {
+			t.Errorf("%s: expected %+v, got %+v", name, c, got[name])
+		}
+	}
+}
+
+func TestHandleByDate(t *testing.T) {
+	tmpDir := t.TempDir()
+	for name, mtime := range map[string]time.Time{
+		"a.mkv": time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		"b.mkv": time.Date(2024, 3, 1, 23, 30, 0, 0, time.UTC),
+		"c.mkv": time.Date(2024, 4, 2, 8, 0, 0, 0, time.UTC),
+	} {
+		path := filepath.Join(tmpDir, name)
+		os.WriteFile(path, make([]byte, 10), 0644)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query string
+		want  map[string]DateCount
+	}{
+		{"?tz=UTC", map[string]DateCount{
+			"2024-03-01": {Count: 2, TotalSize: 20},
+			"2024-04-02": {Count: 1, TotalSize: 10},
+		}},
+		{"?granularity=month&tz=UTC", map[string]DateCount{
+			"2024-03": {Count: 2, TotalSize: 20},
+			"2024-04": {Count: 1, TotalSize: 10},
+		}},
+		// 23:30 UTC is already the next day in Tokyo.
+		{"?tz=Asia/Tokyo", map[string]DateCount{
+			"2024-03-01": {Count: 1, TotalSize: 10},
+			"2024-03-02": {Count: 1, TotalSize: 10},
+			"2024-04-02": {Count: 1, TotalSize: 10},
+		}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/by-date"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleByDate(w, req)
+
+		var resp ByDateResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Dates) != len(tt.want) {
+			t.Errorf("%q: expected %d dates, got %v", tt.query, len(tt.want), resp.Dates)
+		}
+		for date, c := range tt.want {
+			if resp.Dates[date] != c {
+				t.Errorf("%q: %s: expected %+v, got %+v", tt.query, date, c, resp.Dates[date])
+			}
+		}
+	}
+
+	for _, q := range []string{"?granularity=week", "?tz=Nowhere/Special"} {
+		req := httptest.NewRequest(http.MethodGet, "/by-date"+q, nil)
+		w := httptest.NewRecorder()
+		handleByDate(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestHandleLongestPaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "a-rather-long-directory-name"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "short.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "medium-length-name.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "a-rather-long-directory-name", "e01.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/longest-paths?n=2", nil)
+	w := httptest.NewRecorder()
+	handleLongestPaths(w, req)
+
+	var resp LongestPathsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := []string{
+		filepath.Join(tmpDir, "a-rather-long-directory-name", "e01.mkv"),
+		filepath.Join(tmpDir, "medium-length-name.mkv"),
+	}
+	if len(resp.Paths) != len(want) {
+		t.Fatalf("expected %d paths, got %+v", len(want), resp.Paths)
+	}
+	for i, p := range resp.Paths {
+		if p.Path != want[i] || p.Length != len(want[i]) {
+			t.Errorf("%d: expected %s (%d), got %+v", i, want[i], len(want[i]), p)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/longest-paths?n=-1", nil)
+	w = httptest.NewRecorder()
+	handleLongestPaths(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for n=-1, got %d", w.Code)
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "Movies"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "Movies", "big.mkv"), make([]byte, 300), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Movies", "small.MKV"), make([]byte, 100), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "notes.txt"), make([]byte, 20), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "README"), make([]byte, 5), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/stats?top=2", nil)
+	w := httptest.NewRecorder()
+	handleStats(w, req)
+
+	var resp StatsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Roots) != 1 {
+		t.Fatalf("expected 1 root, got %d", len(resp.Roots))
+	}
+	stats := resp.Roots[0]
+	if stats.Files != 4 || stats.TotalSize != 425 {
+		t.Errorf("expected 4 files, 425 bytes, got %d, %d", stats.Files, stats.TotalSize)
+	}
+	wantLargest := []FileSize{
+		{Path: filepath.Join(tmpDir, "Movies", "big.mkv"), Size: 300},
+		{Path: filepath.Join(tmpDir, "Movies", "small.MKV"), Size: 100},
+	}
+	if !reflect.DeepEqual(stats.Largest, wantLargest) {
+		t.Errorf("expected largest %+v, got %+v", wantLargest, stats.Largest)
+	}
+	wantExts := map[string]ExtensionCount{"mkv": {2, 400}, "txt": {1, 20}, "": {1, 5}}
+	if !reflect.DeepEqual(stats.Extensions, wantExts) {
+		t.Errorf("expected extensions %+v, got %+v", wantExts, stats.Extensions)
+	}
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		if stats.Disk == nil || stats.Disk.Total == 0 || stats.Disk.Used > stats.Disk.Total {
+			t.Errorf("expected disk usage, got %+v", stats.Disk)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/stats?top=x", nil)
+	w = httptest.NewRecorder()
+	handleStats(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for top=x, got %d", w.Code)
+	}
+}
+
+func TestReportsHonourAllowedPrefixes(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"tv/show.mkv", "movies/film.mkv"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(tmpDir, name)), 0755)
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("same"), 0644)
+	}
+	other := t.TempDir()
+	os.WriteFile(filepath.Join(other, "other.mkv"), []byte("same"), 0644)
+	config.Dirs = []string{tmpDir, other}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	handlers := map[string]http.HandlerFunc{
+		"/tree":             handleTree,
+		"/duplicates":       handleDuplicates,
+		"/stats":            handleStats,
+		"/feed.xml":         handleFeed,
+		"/latest-per-dir":   handleLatestPerDir,
+		"/counts-by-subdir": handleCountsBySubdir,
+		"/longest-paths":    handleLongestPaths,
+	}
+	for path, handler := range handlers {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Allowed-Prefixes", filepath.Join(tmpDir, "tv"))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		body := w.Body.String()
+		if w.Code != http.StatusOK || strings.Contains(body, "movies") || strings.Contains(body, "film.mkv") || strings.Contains(body, other) {
+			t.Errorf("%s: expected only tv, got %d %s", path, w.Code, body)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("X-Allowed-Prefixes", filepath.Join(tmpDir, "tv"))
+	w := httptest.NewRecorder()
+	handleStats(w, req)
+	var resp StatsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Roots) != 1 || resp.Roots[0].Files != 1 {
+		t.Errorf("expected one root with one file, got %+v", resp.Roots)
+	}
+}
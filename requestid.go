@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+)
+
+// maxRequestIDLen bounds client-supplied request IDs before they reach logs.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// withRequestID tags each request with an ID, taken from a valid incoming
+// X-Request-ID header or generated, echoes it in the response's X-Request-ID
+// and puts it in the request context, where requestLogger adds it to every
+// log record, so a slow client call can be tied to the server-side work it
+// caused.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts non-empty IDs of printable ASCII, so a client can't
+// inject newlines or control characters into the log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID withRequestID assigned to ctx's request, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// statusWriter records the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes through to the underlying writer so streaming handlers such as
+// /additions keep working.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
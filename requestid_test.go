@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	var seen string
+	handler := withRequestID(withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r.Context())
+		requestLogger(r).Info("scanning")
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	tests := []struct {
+		incoming string
+		keep     bool
+	}{
+		{"client-abc-123", true},
+		{"", false},
+		{"bad id\nforged line", false},
+	}
+
+	for _, tt := range tests {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/list", nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-ID", tt.incoming)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		id := w.Header().Get("X-Request-ID")
+		if id == "" || id != seen {
+			t.Errorf("%q: expected echoed ID to match handler's %q, got %q", tt.incoming, seen, id)
+		}
+		if got := id == tt.incoming; got != tt.keep {
+			t.Errorf("%q: expected incoming ID kept=%v, got ID %q", tt.incoming, tt.keep, id)
+		}
+		if !strings.Contains(logs.String(), "msg=scanning request_id="+id) || !strings.Contains(logs.String(), "msg=Request request_id="+id+" method=GET path=/list status=418") {
+			t.Errorf("%q: expected log lines tagged with %s, got %q", tt.incoming, id, logs.String())
+		}
+	}
+}
+
+func TestWalkLogsRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "walk-123")
+	walkDisk(ctx, []string{missing}, func(path string, d fs.DirEntry) error { return nil })
+
+	if !strings.Contains(logs.String(), `msg="Error accessing path" request_id=walk-123`) {
+		t.Errorf("expected the walk's error tagged with its request ID, got %q", logs.String())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"math/rand/v2"
+	"sort"
+)
+
+// reservoir keeps a uniform random sample of up to n items from a stream of
+// unknown length (Algorithm R).
+type reservoir[T any] struct {
+	n     int
+	seen  int
+	items []T
+}
+
+func newReservoir[T any](n int) *reservoir[T] {
+	return &reservoir[T]{n: n, items: make([]T, 0, n)}
+}
+
+func (s *reservoir[T]) add(item T) {
+	s.seen++
+	if len(s.items) < s.n {
+		s.items = append(s.items, item)
+		return
+	}
+	if i := rand.IntN(s.seen); i < s.n {
+		s.items[i] = item
+	}
+}
+
+// sampleFiles walks opts.Dirs keeping a uniform sample of up to n
+// files, so memory stays bounded however large the tree is. Entries are only
+// built (and stat'd) for the files that end up in the sample. It returns the
+// sample, sorted by path, and the number of files it was drawn from.
+func sampleFiles(ctx context.Context, n int, opts listOptions) ([]FileEntry, int) {
+	sample := newReservoir[indexedFile](n)
+
+	walkFiles(ctx, opts.Dirs, func(path string, d fs.DirEntry) error {
+		if opts.allowed(reportedPath(path)) {
+			sample.add(indexedFile{path: path, d: d})
+		}
+		return nil
+	})
+
+	files := make([]FileEntry, 0, len(sample.items))
+	for _, f := range sample.items {
+		entry, err := newFileEntry(f.path, f.d, opts)
+		if err != nil {
+			contextLogger(ctx).Warn("Error getting file info", "path", f.path, "err", err)
+			continue
+		}
+		files = append(files, entry)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files, sample.seen
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReservoirIsUniform(t *testing.T) {
+	counts := make([]int, 10)
+	for range 10000 {
+		s := newReservoir[int](1)
+		for i := range 10 {
+			s.add(i)
+		}
+		counts[s.items[0]]++
+	}
+
+	// Each item should be picked about 1000 times.
+	for i, c := range counts {
+		if c < 800 || c > 1200 {
+			t.Errorf("item %d picked %d times, expected about 1000", i, c)
+		}
+	}
+}
+
+func TestHandleListSample(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := range 20 {
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("movie%02d.mkv", i)), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list?sample=5", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.Sampled || resp.Scanned != 20 || len(resp.Files) != 5 {
+		t.Fatalf("expected 5 sampled files of 20, got sampled=%v scanned=%d files=%d", resp.Sampled, resp.Scanned, len(resp.Files))
+	}
+	for _, f := range resp.Files {
+		if f.Size != 4 {
+			t.Errorf("%s: expected size 4, got %d", f.Name, f.Size)
+		}
+	}
+
+	for _, q := range []string{"sample=0", "sample=many", "sample=5&delta-from=sha256:abc"} {
+		req := httptest.NewRequest(http.MethodGet, "/list?"+q, nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", q, w.Code)
+		}
+	}
+}
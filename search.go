@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// handleSearch finds files whose names approximately match q, tolerating
+// typos and missing letters, ranked best first. Each word of q must be
+// within a few edits of some word of the name, so "edg of darknes" finds
+// Edge.of.Darkness.2010.1080p.mkv. The list options of /list apply.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	words := searchWords(r.URL.Query().Get("q"))
+	if len(words) == 0 {
+		writeError(w, http.StatusBadRequest, "missing 'q' parameter")
+		return
+	}
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var files []FileEntry
+	walkFiles(withoutFileInfo(r.Context(), opts.needsInfo()), opts.Dirs, func(path string, d fs.DirEntry) error {
+		score := fuzzyScore(words, searchWords(d.Name()))
+		if score == 0 || !opts.allowed(reportedPath(path)) {
+			return nil
+		}
+		entry, _ := newFileEntry(path, d, opts)
+		entry.Score = score
+		files = append(files, entry)
+		return nil
+	})
+	if walkCancelled(w, r) {
+		return
+	}
+
+	response := ListResponse{Host: config.FriendlyName, InstanceID: instanceID, Files: files}
+	fanOut(r, &response)
+	sortByScore(response.Files)
+
+	w.Header().Set("Content-Type", "application/json")
+	writeListResponse(w, r, response, opts)
+}
+
+// searchWords lowercases s and splits it into runs of letters and digits, so
+// dots, underscores and brackets in file names separate words like spaces.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// fuzzyScore rates how well the query words match the name words, from 1 to
+// 100, or 0 if some query word is more than maxEdits from every name word.
+// Each query word counts its closest name word's similarity (1 minus the
+// edit distance over the longer length), averaged over the query.
+func fuzzyScore(query, name []string) int {
+	var total float64
+	for _, q := range query {
+		best := -1.0
+		for _, n := range name {
+			d := levenshtein(q, n)
+			if d > maxEdits(q) {
+				continue
+			}
+			best = max(best, 1-float64(d)/float64(max(len([]rune(q)), len([]rune(n)))))
+		}
+		if best < 0 {
+			return 0
+		}
+		total += best
+	}
+	return max(1, int(100*total/float64(len(query))))
+}
+
+// maxEdits is how many typos a query word may have: none for one or two
+// letters, where any edit changes the word, one up to five and two beyond.
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein returns the number of single-rune insertions, deletions and
+// substitutions that turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHandleSearch(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{
+		"Edge.of.Darkness.2010.1080p.mkv",
+		"Edge.of.Tomorrow.2014.720p.mkv",
+		"The.Dark.Knight.2008.mkv",
+		"notes.txt",
+	} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"edg of darknes", []string{"Edge.of.Darkness.2010.1080p.mkv"}},
+		{"Edge of Darkness", []string{"Edge.of.Darkness.2010.1080p.mkv"}},
+		{"edge of", []string{"Edge.of.Tomorrow.2014.720p.mkv", "Edge.of.Darkness.2010.1080p.mkv"}}, // tie: shorter name first
+		{"dark knigth", []string{"The.Dark.Knight.2008.mkv"}},
+		{"darkness tomorrow", nil},
+		{"ot", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q="+url.QueryEscape(tt.q), nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			var resp ListResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			var got []string
+			for _, f := range resp.Files {
+				if f.Score <= 0 || f.Score > 100 {
+					t.Errorf("%s: score %d out of range", f.Name, f.Score)
+				}
+				got = append(got, f.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q=...", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a query with no words, got %d", w.Code)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"edge", "edge", 0},
+		{"edg", "edge", 1},
+		{"darknes", "darkness", 1},
+		{"knigth", "knight", 2},
+		{"kitten", "sitting", 3},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// shutdownGrace is how long running requests get to finish after SIGINT or
+// SIGTERM before their walks are cancelled.
+var shutdownGrace = 30 * time.Second
+
+// shutdownCancelGrace is how long requests get, once cancelled, to send their
+// 503 before connections are closed outright.
+var shutdownCancelGrace = 5 * time.Second
+
+type shutdownKey struct{}
+
+// shutdownStarted returns a channel that is closed when the server serving
+// ctx's request begins shutting down. Long-polls and event streams select on
+// it so they end at once instead of holding the shutdown open. It returns nil,
+// which never fires, for requests not served by serve (e.g. in tests).
+func shutdownStarted(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(shutdownKey{}).(chan struct{})
+	return ch
+}
+
+// serve serves handler on every listener until a value arrives on stop, then
+// shuts down gracefully: the listeners are closed (removing a Unix socket
+// file), idle connections are dropped and running requests get shutdownGrace
+// to finish. Requests still running after that have their contexts cancelled,
+// which stops their walks so they answer 503 rather than a truncated listing.
+// Finally the --index-db database, if any, is closed.
+func serve(listeners []net.Listener, handler http.Handler, stop <-chan os.Signal) error {
+	started := make(chan struct{})
+	baseCtx, cancelRequests := context.WithCancel(context.WithValue(context.Background(), shutdownKey{}, started))
+	defer cancelRequests()
+
+	srv := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(sync.OnceFunc(func() { close(started) }))
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { errs <- srv.Serve(l) }()
+	}
+
+	select {
+	case err := <-errs:
+		srv.Close()
+		return err
+	case <-stop:
+	}
+	// Close the index database last, so a restart finds it intact.
+	defer func() {
+		if err := index.close(); err != nil {
+			slog.Warn("Error closing index database", "err", err)
+		}
+	}()
+
+	slog.Info("Shutting down, waiting for running requests", "grace", shutdownGrace)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	slog.Info("Cancelling requests still running after the grace period", "grace", shutdownGrace)
+	cancelRequests()
+	ctx, cancel = context.WithTimeout(context.Background(), shutdownCancelGrace)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return srv.Close()
+	}
+	return err
+}
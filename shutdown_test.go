@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServeFinishesRunningRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inHandler := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- serve([]net.Listener{l}, handler, stop) }()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-inHandler
+	stop <- os.Interrupt
+
+	if body := <-responses; body != "done" {
+		t.Errorf("expected the running request to finish, got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestServeCancelsRequestsAfterGrace(t *testing.T) {
+	shutdownGrace = 50 * time.Millisecond
+	t.Cleanup(func() { shutdownGrace = 30 * time.Second })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inHandler := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		<-r.Context().Done() // a walk that would never finish by itself
+		walkCancelled(w, r)
+	})
+
+	stop := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- serve([]net.Listener{l}, handler, stop) }()
+
+	statuses := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			statuses <- 0
+			return
+		}
+		resp.Body.Close()
+		statuses <- resp.StatusCode
+	}()
+
+	<-inHandler
+	stop <- os.Interrupt
+
+	if status := <-statuses; status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for the cancelled request, got %d", status)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestHandleListCancelled(t *testing.T) {
+	config.Dirs = []string{makeTestTree(t)}
+	t.Cleanup(func() { config.Dirs = nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/list", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a cancelled walk, got %d: %s", w.Code, w.Body.String())
+	}
+}
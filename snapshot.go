@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// scanSnapshot remembers, for each root, the files seen by its last disk
+// walk, so that walks requested within --min-scan-interval of it replay that
+// result instead of touching the disk again. Each file's info is fetched
+// during the walk, so a replay makes no system calls at all.
+type scanSnapshot struct {
+	mu    sync.Mutex // guards roots, never held while walking or calling fn
+	roots map[string]snapshotRoot
+}
+
+// snapshotRoot is one root's last walk.
+type snapshotRoot struct {
+	at    time.Time
+	files []indexedFile
+	errs  []WalkError // what the walk couldn't read, replayed with files
+}
+
+// diskSnapshot is what walkFiles replays under --min-scan-interval.
+var diskSnapshot = &scanSnapshot{}
+
+// walk calls fn for every file under dirs, like walkDisk, reusing each
+// root's previous walk if it is younger than interval. It returns the age of
+// the oldest data fn was given. Returning fs.SkipAll from fn stops the calls,
+// though a fresh walk still finishes so it can be kept; cancelling ctx stops
+// it with ctx.Err(), and a cancelled walk isn't kept. Requests arriving
+// together while a root is stale each walk it.
+func (s *scanSnapshot) walk(ctx context.Context, dirs []string, interval time.Duration, fn walkFunc) (time.Duration, error) {
+	var oldest time.Duration
+	stopped := false
+	call := func(path string, d fs.DirEntry) error {
+		if stopped {
+			return nil
+		}
+		err := fn(path, d)
+		if err == fs.SkipAll {
+			stopped, err = true, nil
+		}
+		return err
+	}
+
+	for _, dir := range dirs {
+		if stopped {
+			break
+		}
+		s.mu.Lock()
+		root, ok := s.roots[dir]
+		s.mu.Unlock()
+		if age := time.Since(root.at); ok && age < interval {
+			oldest = max(oldest, age)
+			walkErrorsFrom(ctx).addAll(root.errs)
+			for _, f := range root.files {
+				if err := ctx.Err(); err != nil {
+					return oldest, err
+				}
+				if err := call(f.path, f.d); err != nil {
+					return oldest, err
+				}
+			}
+			continue
+		}
+
+		// Collect this walk's errors apart from any earlier ones in ctx's,
+		// and have the walk fetch file info whatever fn needs, since it is
+		// kept.
+		collected := &walkErrors{}
+		walkCtx := context.WithValue(context.WithValue(ctx, walkErrorsKey{}, collected), noInfoKey{}, nil)
+		var files []indexedFile
+		var fnErr error
+		err := walkDisk(walkCtx, []string{dir}, func(path string, d fs.DirEntry) error {
+			if info, err := d.Info(); err == nil {
+				d = fs.FileInfoToDirEntry(info)
+			}
+			files = append(files, indexedFile{path: path, d: d})
+			if fnErr == nil {
+				fnErr = call(path, d)
+			}
+			return nil
+		})
+		errs := collected.list()
+		walkErrorsFrom(ctx).addAll(errs)
+		if err != nil {
+			return oldest, err
+		}
+
+		s.mu.Lock()
+		if s.roots == nil {
+			s.roots = make(map[string]snapshotRoot)
+		}
+		s.roots[dir] = snapshotRoot{at: time.Now(), files: files, errs: errs}
+		s.mu.Unlock()
+		if fnErr != nil {
+			return oldest, fnErr
+		}
+	}
+	return oldest, nil
+}
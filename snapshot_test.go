@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandleListMinScanInterval(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "first.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	config.MinScanInterval = time.Hour
+	diskSnapshot = &scanSnapshot{}
+	t.Cleanup(func() {
+		config.MinScanInterval = 0
+		diskSnapshot = &scanSnapshot{}
+	})
+
+	list := func() (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/list", nil)
+		w := httptest.NewRecorder()
+		handleList(w, req)
+
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return len(resp.Files), w.Header().Get("Age")
+	}
+
+	if n, age := list(); n != 1 || age != "0" {
+		t.Fatalf("expected 1 fresh file, got %d with Age %q", n, age)
+	}
+
+	// The replay uses the info fetched by the walk, without touching the disk.
+	os.Remove(filepath.Join(tmpDir, "first.mkv"))
+	os.WriteFile(filepath.Join(tmpDir, "second.mkv"), []byte("test"), 0644)
+	if n, age := list(); n != 1 || age == "" {
+		t.Errorf("expected the previous scan to be replayed, got %d files with Age %q", n, age)
+	}
+
+	// Other endpoints replay the same walk.
+	w := httptest.NewRecorder()
+	handleStats(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats StatsResponse
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if len(stats.Roots) != 1 || stats.Roots[0].Files != 1 || stats.Roots[0].Largest[0].Path != filepath.Join(tmpDir, "first.mkv") {
+		t.Errorf("expected /stats to replay the previous scan, got %+v", stats.Roots)
+	}
+
+	root := diskSnapshot.roots[tmpDir]
+	root.at = time.Now().Add(-2 * time.Hour)
+	diskSnapshot.roots[tmpDir] = root
+	if n, _ := list(); n != 1 {
+		t.Errorf("expected a new scan once the interval passed, got %d files", n)
+	}
+}
+
+func TestScanSnapshotKeepsStoppedWalks(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.mkv", "b.mkv", "c.mkv"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("test"), 0644)
+	}
+
+	s := &scanSnapshot{}
+	n := 0
+	_, err := s.walk(context.Background(), []string{tmpDir}, time.Hour, func(path string, d fs.DirEntry) error {
+		n++
+		return fs.SkipAll
+	})
+	if err != nil || n != 1 || len(s.roots[tmpDir].files) != 3 {
+		t.Errorf("expected fn called once and all 3 files kept, got %d calls, %d kept (%v)", n, len(s.roots[tmpDir].files), err)
+	}
+}
+
+func TestWalkFilesZeroMinScanIntervalBypasses(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "first.mkv"), []byte("test"), 0644)
+	diskSnapshot = &scanSnapshot{}
+
+	n := 0
+	walkFiles(context.Background(), []string{tmpDir}, func(path string, d fs.DirEntry) error {
+		n++
+		return nil
+	})
+	if n != 1 || diskSnapshot.roots != nil {
+		t.Errorf("expected a plain walk with nothing kept, got %d files and %d roots kept", n, len(diskSnapshot.roots))
+	}
+}
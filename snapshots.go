@@ -0,0 +1,248 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// snapshotNamePattern is what ?name= may be: it becomes a file name in
+// --snapshot-dir.
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// snapshotFileSuffix is appended to a snapshot's name for its file.
+const snapshotFileSuffix = ".json.gz"
+
+// SnapshotFile is one file recorded in a snapshot.
+type SnapshotFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// savedSnapshot is the gzipped JSON stored in --snapshot-dir.
+type savedSnapshot struct {
+	Name      string         `json:"name"`
+	Host      string         `json:"host"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []SnapshotFile `json:"files"`
+}
+
+type SnapshotResponse struct {
+	Host      string    `json:"host"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     int       `json:"files"`
+	TotalSize int64     `json:"total_size"`
+}
+
+type SnapshotChange struct {
+	Path        string    `json:"path"`
+	SizeFrom    int64     `json:"size_from"`
+	SizeTo      int64     `json:"size_to"`
+	ModTimeFrom time.Time `json:"mtime_from"`
+	ModTimeTo   time.Time `json:"mtime_to"`
+}
+
+type SnapshotDiffResponse struct {
+	Host    string           `json:"host"`
+	From    string           `json:"from"`
+	To      string           `json:"to"` // "now" for the live files
+	Added   []SnapshotFile   `json:"added"`
+	Removed []SnapshotFile   `json:"removed"`
+	Changed []SnapshotChange `json:"changed"`
+}
+
+// handleSnapshot records every file's path, size and modification time under
+// --snapshot-dir, as ?name= or, by default, the UTC time, e.g.
+// 20240501T120000Z. Existing snapshots aren't overwritten.
+func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	if config.SnapshotDir == "" {
+		writeError(w, http.StatusServiceUnavailable, "snapshots need --snapshot-dir")
+		return
+	}
+
+	now := time.Now().UTC()
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = now.Format("20060102T150405Z")
+	} else if !snapshotNamePattern.MatchString(name) || name == "now" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'name' parameter: %q (letters, digits, '.', '_' and '-'; not now)", name))
+		return
+	}
+
+	files := currentSnapshotFiles(r)
+	if walkCancelled(w, r) {
+		return
+	}
+	snap := savedSnapshot{Name: name, Host: config.FriendlyName, CreatedAt: now, Files: files}
+	if err := writeSnapshot(snap); errors.Is(err, fs.ErrExist) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("snapshot %q already exists", name))
+		return
+	} else if err != nil {
+		requestLogger(r).Warn("Error saving snapshot", "name", name, "err", err)
+		writeError(w, http.StatusInternalServerError, "saving snapshot failed")
+		return
+	}
+
+	resp := SnapshotResponse{Host: config.FriendlyName, Name: name, CreatedAt: now, Files: len(files)}
+	for _, f := range files {
+		resp.TotalSize += f.Size
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// currentSnapshotFiles lists every file now, sorted by path.
+func currentSnapshotFiles(r *http.Request) []SnapshotFile {
+	var files []SnapshotFile
+	walkAllowed(r, configuredDirs(), func(path string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+			return nil
+		}
+		files = append(files, SnapshotFile{Path: reportedPath(path), Size: info.Size(), ModTime: info.ModTime().UTC()})
+		return nil
+	})
+	slices.SortFunc(files, func(a, b SnapshotFile) int { return strings.Compare(a.Path, b.Path) })
+	return files
+}
+
+// writeSnapshot stores snap in --snapshot-dir through a temporary file, so
+// a failed write never leaves a truncated snapshot. It returns an error
+// wrapping fs.ErrExist if one of that name is already there.
+func writeSnapshot(snap savedSnapshot) error {
+	if err := os.MkdirAll(config.SnapshotDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(config.SnapshotDir, snap.Name+snapshotFileSuffix)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s: %w", path, fs.ErrExist)
+	}
+
+	tmp, err := os.CreateTemp(config.SnapshotDir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	if err := json.NewEncoder(gz).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readSnapshot loads the snapshot called name from --snapshot-dir.
+func readSnapshot(name string) (savedSnapshot, error) {
+	var snap savedSnapshot
+	if !snapshotNamePattern.MatchString(name) {
+		return snap, fs.ErrNotExist
+	}
+	f, err := os.Open(filepath.Join(config.SnapshotDir, name+snapshotFileSuffix))
+	if err != nil {
+		return snap, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return snap, err
+	}
+	err = json.NewDecoder(gz).Decode(&snap)
+	return snap, err
+}
+
+// handleSnapshotDiff is /diff?from=&to=: the files added, removed and
+// changed (in size or modification time) between two snapshots, or between
+// one and the live files when to is missing or "now".
+func handleSnapshotDiff(w http.ResponseWriter, r *http.Request) {
+	if config.SnapshotDir == "" {
+		writeError(w, http.StatusServiceUnavailable, "snapshots need --snapshot-dir")
+		return
+	}
+
+	var sides [2][]SnapshotFile
+	names := [2]string{r.URL.Query().Get("from"), r.URL.Query().Get("to")}
+	if names[1] == "" {
+		names[1] = "now"
+	}
+	for i, name := range names {
+		if i == 1 && name == "now" {
+			if sides[i] = currentSnapshotFiles(r); walkCancelled(w, r) {
+				return
+			}
+			continue
+		}
+		snap, err := readSnapshot(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("no snapshot %q", name))
+			return
+		} else if err != nil {
+			requestLogger(r).Warn("Error reading snapshot", "name", name, "err", err)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("reading snapshot %q failed", name))
+			return
+		}
+		opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+		for _, f := range snap.Files {
+			if opts.allowed(f.Path) {
+				sides[i] = append(sides[i], f)
+			}
+		}
+	}
+
+	response := SnapshotDiffResponse{
+		Host:    config.FriendlyName,
+		From:    names[0],
+		To:      names[1],
+		Added:   []SnapshotFile{},
+		Removed: []SnapshotFile{},
+		Changed: []SnapshotChange{},
+	}
+	before := make(map[string]SnapshotFile, len(sides[0]))
+	for _, f := range sides[0] {
+		before[f.Path] = f
+	}
+	for _, f := range sides[1] {
+		old, ok := before[f.Path]
+		delete(before, f.Path)
+		switch {
+		case !ok:
+			response.Added = append(response.Added, f)
+		case old.Size != f.Size || !old.ModTime.Equal(f.ModTime):
+			response.Changed = append(response.Changed, SnapshotChange{
+				Path: f.Path, SizeFrom: old.Size, SizeTo: f.Size, ModTimeFrom: old.ModTime, ModTimeTo: f.ModTime,
+			})
+		}
+	}
+	for _, f := range sides[0] {
+		if _, ok := before[f.Path]; ok {
+			response.Removed = append(response.Removed, f)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	kept, gone, grown := filepath.Join(tmpDir, "kept.mkv"), filepath.Join(tmpDir, "gone.mkv"), filepath.Join(tmpDir, "grown.mkv")
+	os.WriteFile(kept, []byte("kept"), 0644)
+	os.WriteFile(gone, []byte("gone"), 0644)
+	os.WriteFile(grown, []byte("1"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	config.SnapshotDir = filepath.Join(t.TempDir(), "snapshots")
+	t.Cleanup(func() { config.SnapshotDir = "" })
+
+	snapshot := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleSnapshot(w, httptest.NewRequest(http.MethodPost, "/snapshot"+query, nil))
+		return w
+	}
+	diff := func(query string) SnapshotDiffResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleDiff(w, httptest.NewRequest(http.MethodGet, "/diff"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status 200, got %d: %s", query, w.Code, w.Body)
+		}
+		var resp SnapshotDiffResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	w := snapshot("?name=monday")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body)
+	}
+	var saved SnapshotResponse
+	json.Unmarshal(w.Body.Bytes(), &saved)
+	if saved.Name != "monday" || saved.Files != 3 || saved.TotalSize != 9 {
+		t.Errorf("expected monday with 3 files, 9 bytes, got %+v", saved)
+	}
+	if w := snapshot("?name=monday"); w.Code != http.StatusConflict {
+		t.Errorf("expected status 409 for an existing name, got %d", w.Code)
+	}
+	for _, name := range []string{"../escape", ".hidden", "now"} {
+		if w := snapshot("?name=" + name); w.Code != http.StatusBadRequest {
+			t.Errorf("name %q: expected status 400, got %d", name, w.Code)
+		}
+	}
+
+	added := filepath.Join(tmpDir, "new.mkv")
+	os.WriteFile(added, []byte("new"), 0644)
+	os.Remove(gone)
+	os.WriteFile(grown, []byte("12345"), 0644)
+	os.Chtimes(grown, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+
+	live := diff("?from=monday")
+	if live.To != "now" || len(live.Added) != 1 || live.Added[0].Path != added || live.Added[0].Size != 3 {
+		t.Errorf("expected new.mkv added against now, got %+v", live)
+	}
+	if len(live.Removed) != 1 || live.Removed[0].Path != gone {
+		t.Errorf("expected gone.mkv removed, got %+v", live.Removed)
+	}
+	if len(live.Changed) != 1 || live.Changed[0].Path != grown || live.Changed[0].SizeFrom != 1 || live.Changed[0].SizeTo != 5 {
+		t.Errorf("expected grown.mkv changed from 1 to 5 bytes, got %+v", live.Changed)
+	}
+
+	w = snapshot("")
+	json.Unmarshal(w.Body.Bytes(), &saved)
+	if w.Code != http.StatusCreated || len(saved.Name) != len("20240501T120000Z") {
+		t.Fatalf("expected a timestamped snapshot, got %d %+v", w.Code, saved)
+	}
+	if resp := diff("?from=monday&to=" + saved.Name); len(resp.Added) != 1 || len(resp.Removed) != 1 || len(resp.Changed) != 1 {
+		t.Errorf("expected the same differences between the snapshots, got %+v", resp)
+	}
+	if resp := diff("?from=" + saved.Name); len(resp.Added)+len(resp.Removed)+len(resp.Changed) != 0 {
+		t.Errorf("expected no differences from the latest snapshot, got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	handleDiff(w, httptest.NewRequest(http.MethodGet, "/diff?from=tuesday", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a missing snapshot, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleSnapshot(w, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for GET /snapshot, got %d", w.Code)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// ownerSupported reports whether fileOwner can report file owners.
+const ownerSupported = false
+
+// statOf always returns false: stat fields aren't available on this platform.
+func statOf(info fs.FileInfo) (fileStat, bool) {
+	return fileStat{}, false
+}
+
+// fileLinks always returns 0: link counts aren't available on this platform.
+func fileLinks(info fs.FileInfo) uint64 {
+	return 0
+}
+
+// fileKey always returns false: inode numbers aren't available on this platform.
+func fileKey(info fs.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
+
+// fileOwner always returns false: uids aren't available on this platform.
+func fileOwner(info fs.FileInfo) (uint32, bool) {
+	return 0, false
+}
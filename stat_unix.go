@@ -0,0 +1,44 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// ownerSupported reports whether fileOwner can report file owners.
+const ownerSupported = true
+
+// statOf returns the owner, link count, device and inode of the file
+// described by info, read from the platform's stat or, for a restored
+// --index-db entry, from what was stored; false if neither is there (e.g.
+// for archive members).
+func statOf(info fs.FileInfo) (fileStat, bool) {
+	switch st := info.Sys().(type) {
+	case *syscall.Stat_t:
+		return fileStat{dev: uint64(st.Dev), ino: uint64(st.Ino), nlink: uint64(st.Nlink), uid: st.Uid}, true
+	case *fileStat:
+		return *st, true
+	}
+	return fileStat{}, false
+}
+
+// fileLinks returns the number of hard links to the file described by info.
+func fileLinks(info fs.FileInfo) uint64 {
+	st, _ := statOf(info)
+	return st.nlink
+}
+
+// fileKey returns the device and inode identifying the physical file behind
+// info, and false if they aren't available (e.g. for archive members).
+func fileKey(info fs.FileInfo) (inodeKey, bool) {
+	st, ok := statOf(info)
+	return inodeKey{dev: st.dev, ino: st.ino}, ok
+}
+
+// fileOwner returns the owner uid of the file described by info.
+func fileOwner(info fs.FileInfo) (uint32, bool) {
+	st, ok := statOf(info)
+	return st.uid, ok
+}
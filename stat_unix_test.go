@@ -0,0 +1,219 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHandleListLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	original := filepath.Join(tmpDir, "movie.mkv")
+	os.WriteFile(original, []byte("test"), 0644)
+	if err := os.Link(original, filepath.Join(tmpDir, "movie-link.mkv")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "single.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list?links=1&nosize=1", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	want := map[string]uint64{"movie.mkv": 2, "movie-link.mkv": 2, "single.mkv": 1}
+	for _, f := range resp.Files {
+		if f.Nlink != want[f.Name] {
+			t.Errorf("%s: expected nlink %d, got %d", f.Name, want[f.Name], f.Nlink)
+		}
+		if f.Size != 0 {
+			t.Errorf("%s: expected nosize to still apply, got size %d", f.Name, f.Size)
+		}
+	}
+}
+
+func TestHandleSizeHistogramDedupInode(t *testing.T) {
+	tmpDir := t.TempDir()
+	original := filepath.Join(tmpDir, "movie.mkv")
+	os.WriteFile(original, make([]byte, 100), 0644)
+	if err := os.Link(original, filepath.Join(tmpDir, "movie-link.mkv")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	config.SizeBuckets = "1MB"
+
+	tests := []struct {
+		query     string
+		wantCount int
+		wantSize  int64
+	}{
+		{"", 2, 200},
+		{"?dedup=inode", 2, 100},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/size-histogram"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleSizeHistogram(w, req)
+
+		var resp HistogramResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Buckets[0].Count != tt.wantCount || resp.Buckets[0].TotalSize != tt.wantSize {
+			t.Errorf("%q: expected count %d size %d, got %+v", tt.query, tt.wantCount, tt.wantSize, resp.Buckets[0])
+		}
+	}
+}
+
+func TestHandleStatsDedupInode(t *testing.T) {
+	tmpDir := t.TempDir()
+	original := filepath.Join(tmpDir, "movie.mkv")
+	os.WriteFile(original, make([]byte, 100), 0644)
+	if err := os.Link(original, filepath.Join(tmpDir, "movie-link.mkv")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query     string
+		wantFiles int
+		wantSize  int64
+	}{
+		{"", 2, 200},
+		{"?dedup=inode", 2, 100},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/stats"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleStats(w, req)
+
+		var resp StatsResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		stats := resp.Roots[0]
+		if stats.Files != tt.wantFiles || stats.TotalSize != tt.wantSize || stats.Extensions["mkv"].TotalSize != tt.wantSize {
+			t.Errorf("%q: expected %d files, %d bytes, got %+v", tt.query, tt.wantFiles, tt.wantSize, stats)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleStats(w, httptest.NewRequest(http.MethodGet, "/stats?dedup=path", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for dedup=path, got %d", w.Code)
+	}
+}
+
+func TestHandleFilterUID(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "mine.mkv"), []byte("test"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	tests := []struct {
+		query     string
+		wantCode  int
+		wantCount int
+	}{
+		{"uid=" + strconv.Itoa(os.Getuid()), http.StatusOK, 1},
+		{"q=*.mkv&uid=" + strconv.Itoa(os.Getuid()+1), http.StatusOK, 0},
+		{"uid=bob", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/filter?"+tt.query, nil)
+		w := httptest.NewRecorder()
+		handleFilter(w, req)
+
+		if w.Code != tt.wantCode {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.wantCode, w.Code)
+			continue
+		}
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Files) != tt.wantCount {
+			t.Errorf("%q: expected %d files, got %d", tt.query, tt.wantCount, len(resp.Files))
+		}
+	}
+}
+
+func TestSameDeviceSkipsMountPoints(t *testing.T) {
+	rootInfo, err := os.Stat("/")
+	if err != nil {
+		t.Skip(err)
+	}
+	procInfo, err := os.Lstat("/proc")
+	if err != nil {
+		t.Skip(err)
+	}
+	rootKey, _ := fileKey(rootInfo)
+	procKey, _ := fileKey(procInfo)
+	if rootKey.dev == procKey.dev {
+		t.Skip("/proc is not a separate mount here")
+	}
+
+	descend := sameDevice("/")
+	if descend("/proc", fs.FileInfoToDirEntry(procInfo)) {
+		t.Error("expected /proc to be skipped as a mount point")
+	}
+
+	tmpDir := t.TempDir()
+	os.Mkdir(filepath.Join(tmpDir, "sub"), 0755)
+	subInfo, _ := os.Lstat(filepath.Join(tmpDir, "sub"))
+	if !sameDevice(tmpDir)(filepath.Join(tmpDir, "sub"), fs.FileInfoToDirEntry(subInfo)) {
+		t.Error("expected a plain subdirectory to be entered")
+	}
+}
+
+func TestIndexStoreKeepsStat(t *testing.T) {
+	tmpDir := t.TempDir()
+	original := filepath.Join(tmpDir, "movie.mkv")
+	os.WriteFile(original, []byte("test"), 0644)
+	if err := os.Link(original, filepath.Join(tmpDir, "movie-link.mkv")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	store, err := openIndexStore(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.close() })
+
+	info, _ := os.Lstat(original)
+	files := []indexedFile{{path: original, d: fs.FileInfoToDirEntry(info)}}
+	if err := store.save(map[string][]indexedFile{tmpDir: files}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	roots, _, err := store.load([]string{tmpDir})
+	if err != nil || len(roots[tmpDir]) != 1 {
+		t.Fatalf("expected one stored file, got %v (%v)", roots, err)
+	}
+
+	// A restored entry answers ?uid=, ?links= and ?dedup=inode as the disk would.
+	restored, _ := roots[tmpDir][0].d.Info()
+	wantKey, _ := fileKey(info)
+	wantUID, _ := fileOwner(info)
+	if key, ok := fileKey(restored); !ok || key != wantKey {
+		t.Errorf("expected inode key %v restored, got %v (%v)", wantKey, key, ok)
+	}
+	if uid, ok := fileOwner(restored); !ok || uid != wantUID {
+		t.Errorf("expected uid %d restored, got %d (%v)", wantUID, uid, ok)
+	}
+	if n := fileLinks(restored); n != 2 {
+		t.Errorf("expected 2 links restored, got %d", n)
+	}
+}
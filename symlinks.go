@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// linkInfo is a followed symlink's target's info, with ModeSymlink added to
+// its mode so the entry is still reported as a link.
+type linkInfo struct {
+	fs.FileInfo
+}
+
+func (i linkInfo) Mode() fs.FileMode { return i.FileInfo.Mode() | fs.ModeSymlink }
+
+// followLink returns the entry a walk should use for d. With
+// --follow-symlinks a link is replaced by its target, so a linked directory
+// is entered and a linked file has its target's size, though both are still
+// marked as links. Otherwise, or if the link is dangling, d is returned as it
+// is and the link itself is listed.
+func followLink(path string, d fs.DirEntry) fs.DirEntry {
+	if !config.FollowSymlinks || d.Type()&fs.ModeSymlink == 0 {
+		return d
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Debug("Not following symlink", "path", path, "err", err)
+		return d
+	}
+	return fs.FileInfoToDirEntry(linkInfo{info})
+}
+
+// enteringOnce returns a descendFunc that enters each directory under root
+// at most once, telling them apart by device and inode (or, where those
+// aren't available, by resolved path). Following symlinks, that stops a link
+// to an ancestor from looping forever and a directory linked from two places
+// from being listed twice.
+func enteringOnce(root string, next descendFunc) descendFunc {
+	var mu sync.Mutex
+	seen := make(map[any]bool)
+	if info, err := os.Stat(root); err == nil {
+		if id, ok := dirIdentity(root, fs.FileInfoToDirEntry(info)); ok {
+			seen[id] = true
+		}
+	}
+
+	return func(path string, d fs.DirEntry) bool {
+		if !next(path, d) {
+			return false
+		}
+		id, ok := dirIdentity(path, d)
+		if !ok {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[id] {
+			slog.Info("Skipping directory already walked", "path", path)
+			return false
+		}
+		seen[id] = true
+		return true
+	}
+}
+
+func dirIdentity(path string, d fs.DirEntry) (any, bool) {
+	if info, err := d.Info(); err == nil {
+		if key, ok := fileKey(info); ok {
+			return key, true
+		}
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	return resolved, err == nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scanSlots holds a token for every disk walk in progress, capping them at
+// --max-concurrent-scans; nil when unset. Walks served from the index don't
+// touch the disk and don't take one.
+var scanSlots chan struct{}
+
+// acquireScanSlot waits for a free walk slot and returns the function that
+// frees it, or ctx's error if ctx ends first.
+func acquireScanSlot(ctx context.Context) (func(), error) {
+	if scanSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case scanSlots <- struct{}{}:
+		return func() { <-scanSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// clientLimiter is a token bucket per client address: each holds up to
+// burst requests and refills at rate per second.
+type clientLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	clients   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter enforces --rate-limit; nil when unset.
+var rateLimiter *clientLimiter
+
+func newClientLimiter(rate float64, burst int) *clientLimiter {
+	return &clientLimiter{rate: rate, burst: float64(burst), clients: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from client's bucket. If there is none it returns
+// false and how long until there will be.
+func (l *clientLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Buckets idle long enough to have refilled are the same as new ones.
+	if full := time.Duration(l.burst / l.rate * float64(time.Second)); now.Sub(l.lastSweep) > max(full, time.Minute) {
+		for c, b := range l.clients {
+			if now.Sub(b.last) > full {
+				delete(l.clients, c)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// withRateLimit answers 429 Too Many Requests, with a Retry-After, to
+// clients over --rate-limit. Clients are told apart by IP address.
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if ok, wait := rateLimiter.allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, fmt.Sprintf("rate limit of %g requests per second exceeded", rateLimiter.rate))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
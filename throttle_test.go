@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientLimiter(t *testing.T) {
+	l := newClientLimiter(2, 3)
+	now := time.Now()
+	for i := range 3 {
+		if ok, _ := l.allow("10.0.0.1", now); !ok {
+			t.Fatalf("expected request %d within the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := l.allow("10.0.0.1", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected the 4th request refused for 500ms, got %v %v", ok, wait)
+	}
+	if ok, _ := l.allow("10.0.0.2", now); !ok {
+		t.Error("expected another client to have its own bucket")
+	}
+	if ok, _ := l.allow("10.0.0.1", now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected a token back after 500ms at 2/s")
+	}
+
+	rateLimiter = newClientLimiter(1, 1)
+	t.Cleanup(func() { rateLimiter = nil })
+	handler := withRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := make([]int, 2)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/list", nil))
+		codes[i] = w.Code
+		if i == 1 && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("expected Retry-After: 1, got %q", w.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("expected 200 then 429, got %v", codes)
+	}
+}
+
+func TestMaxConcurrentScans(t *testing.T) {
+	root := makeTestTree(t)
+	scanSlots = make(chan struct{}, 1)
+	t.Cleanup(func() { scanSlots = nil })
+
+	// With the only slot taken, a walk waits and gives up with its context.
+	release, _ := acquireScanSlot(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	seen := 0
+	err := walkFiles(ctx, []string{root}, func(string, fs.DirEntry) error {
+		seen++
+		return nil
+	})
+	if err != context.DeadlineExceeded || seen != 0 {
+		t.Errorf("expected the walk to time out waiting, got %v after %d files", err, seen)
+	}
+
+	done := make(chan int)
+	go func() {
+		n := 0
+		walkFiles(context.Background(), []string{root}, func(string, fs.DirEntry) error {
+			n++
+			return nil
+		})
+		done <- n
+	}()
+	release()
+	if n := <-done; n != 21 {
+		t.Errorf("expected the walk to run once the slot was free, saw %d files", n)
+	}
+	if len(scanSlots) != 0 {
+		t.Error("expected the walk to give its slot back")
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// streamingPaths stay open by design, so --request-timeout doesn't apply to
+// them.
+var streamingPaths = map[string]bool{"/additions": true, "/events": true, "/ws": true}
+
+// withRequestTimeout cancels each request's context once --request-timeout
+// has passed, which stops its walk just as a client disconnecting does.
+// Streams and ?wait-for-change long-polls are left alone; they end on their
+// own terms.
+func withRequestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.RequestTimeout <= 0 || streamingPaths[r.URL.Path] || r.URL.Query().Has("wait-for-change") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), config.RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	config.Dirs = []string{makeTestTree(t)}
+	config.RequestTimeout = time.Nanosecond
+	t.Cleanup(func() { config.RequestTimeout = 0 })
+
+	w := httptest.NewRecorder()
+	withRequestTimeout(http.HandlerFunc(handleList)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/list", nil))
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "timed out") {
+		t.Errorf("expected a 503 timeout, got %d: %s", w.Code, w.Body)
+	}
+
+	for _, target := range []string{"/events", "/ws", "/list?wait-for-change=abc"} {
+		var hasDeadline bool
+		withRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, hasDeadline = r.Context().Deadline()
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		if hasDeadline {
+			t.Errorf("%s: expected no request timeout", target)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// selfSignedValidity is how long a generated certificate lasts. Clients pin
+// it rather than trust a CA, so a long life saves re-pinning.
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// tlsConfig returns the TLS settings for the TCP listener, or nil to serve
+// plain HTTP. --tls-cert and --tls-key name a certificate to load; with
+// --tls-self-signed one is generated there (or in the user cache directory)
+// when missing, and --acme-domain gets certificates from Let's Encrypt.
+func tlsConfig() (*tls.Config, error) {
+	if len(config.ACMEDomains) > 0 {
+		if config.TLSCert != "" || config.TLSKey != "" || config.TLSSelfSigned {
+			return nil, errors.New("--acme-domain can't be combined with --tls-cert, --tls-key or --tls-self-signed")
+		}
+		return acmeConfig(config.ACMEDomains)
+	}
+	if config.TLSCert == "" && config.TLSKey == "" && !config.TLSSelfSigned {
+		return nil, nil
+	}
+
+	certFile, keyFile := config.TLSCert, config.TLSKey
+	if config.TLSSelfSigned && certFile == "" && keyFile == "" {
+		dir, err := cacheDir()
+		if err != nil {
+			return nil, err
+		}
+		certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be given together")
+	}
+
+	if config.TLSSelfSigned {
+		if err := ensureSelfSigned(certFile, keyFile, certHosts()); err != nil {
+			return nil, fmt.Errorf("generating self-signed certificate: %w", err)
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Serving HTTPS", "cert", certFile, "sha256_fingerprint", certFingerprint(cert))
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// acmeConfig obtains and renews certificates for domains from Let's Encrypt,
+// caching them under the user cache directory. Challenges are answered with
+// tls-alpn-01, so the listener must be reachable from the internet on 443.
+func acmeConfig(domains []string) (*tls.Config, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(filepath.Join(dir, "acme")),
+	}
+	slog.Info("Serving HTTPS with Let's Encrypt certificates", "domains", domains)
+	// The server isn't set up for HTTP/2, so don't offer it.
+	return &tls.Config{GetCertificate: m.GetCertificate, NextProtos: []string{"http/1.1", acme.ALPNProto}}, nil
+}
+
+// cacheDir returns the directory generated certificates are kept in.
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("finding a directory for certificates: %w", err)
+	}
+	return filepath.Join(dir, "filesystem-lister"), nil
+}
+
+// certHosts returns the names and addresses a self-signed certificate should
+// cover: localhost, the hostname and friendly name, and every interface
+// address.
+func certHosts() []string {
+	hosts := []string{"localhost", config.FriendlyName}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+	return hosts
+}
+
+// ensureSelfSigned writes a new self-signed certificate for hosts to
+// certFile and its key to keyFile, unless certFile already exists. Keeping
+// the first one means clients that pinned it keep working across restarts.
+func ensureSelfSigned(certFile, keyFile string, hosts []string) error {
+	if _, err := os.Stat(certFile); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	certPEM, keyPEM, err := selfSignedCert(hosts, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	slog.Info("Generated self-signed certificate", "cert", certFile, "hosts", hosts)
+	return nil
+}
+
+// selfSignedCert returns a PEM-encoded ECDSA P-256 certificate for hosts,
+// valid from now for selfSignedValidity, and its PEM-encoded key.
+func selfSignedCert(hosts []string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "filesystem-lister " + config.FriendlyName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// certFingerprint returns the hex SHA-256 of cert's leaf, for pinning.
+func certFingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
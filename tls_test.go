@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEnsureSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls", "cert.pem"), filepath.Join(dir, "tls", "key.pem")
+	config.TLSCert, config.TLSKey, config.TLSSelfSigned = certFile, keyFile, true
+	t.Cleanup(func() { config.TLSCert, config.TLSKey, config.TLSSelfSigned = "", "", false })
+
+	conf, err := tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(certFile)
+	if info, err := os.Stat(keyFile); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("expected the key to be written private, got %v %v", info, err)
+	}
+
+	if _, err := tlsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(certFile); !bytes.Equal(first, again) {
+		t.Errorf("expected the existing certificate to be kept on restart")
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	// Trusting the generated certificate alone, the client must accept it
+	// for the loopback address it was issued to.
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(first)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	resp, err := client.Get("https://127.0.0.1:" + port + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("expected the request to reach the handler, got %d", resp.StatusCode)
+	}
+}
+
+func TestTLSConfigRejectsConflicts(t *testing.T) {
+	t.Cleanup(func() { config.TLSCert, config.TLSKey, config.TLSSelfSigned, config.ACMEDomains = "", "", false, nil })
+
+	tests := []struct {
+		name       string
+		cert, key  string
+		selfSigned bool
+		acme       []string
+	}{
+		{"cert without key", "cert.pem", "", false, nil},
+		{"key without cert", "", "key.pem", false, nil},
+		{"acme with a certificate", "cert.pem", "key.pem", false, []string{"files.example.com"}},
+		{"acme with self-signed", "", "", true, []string{"files.example.com"}},
+	}
+	for _, tt := range tests {
+		config.TLSCert, config.TLSKey, config.TLSSelfSigned, config.ACMEDomains = tt.cert, tt.key, tt.selfSigned, tt.acme
+		if _, err := tlsConfig(); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+
+	config.TLSCert, config.TLSKey, config.TLSSelfSigned, config.ACMEDomains = "", "", false, nil
+	if conf, err := tlsConfig(); conf != nil || err != nil {
+		t.Errorf("expected plain HTTP without TLS flags, got %v, %v", conf, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TreeNode is one directory in a /tree response. Files and Size cover
+// everything beneath it, including directories cut off by ?depth=.
+type TreeNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Files    int         `json:"files"`
+	Size     int64       `json:"size"`
+	Children []*TreeNode `json:"children,omitempty"`
+
+	dir    string
+	byName map[string]*TreeNode
+}
+
+type TreeResponse struct {
+	Host  string      `json:"host"`
+	Roots []*TreeNode `json:"roots"`
+}
+
+// child returns the subdirectory of n called name, creating it if needed.
+func (n *TreeNode) child(name string) *TreeNode {
+	if c, ok := n.byName[name]; ok {
+		return c
+	}
+	dir := filepath.Join(n.dir, name)
+	c := &TreeNode{Name: name, Path: reportedPath(dir), dir: dir}
+	if n.byName == nil {
+		n.byName = make(map[string]*TreeNode)
+	}
+	n.byName[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sortChildren orders every level of the tree by name.
+func (n *TreeNode) sortChildren() {
+	slices.SortFunc(n.Children, func(a, b *TreeNode) int { return strings.Compare(a.Name, b.Name) })
+	for _, c := range n.Children {
+		c.sortChildren()
+	}
+}
+
+// handleTree returns each configured root as a tree of its directories with
+// the number and total size of the files under each. ?depth=N stops the
+// tree N levels below the roots (0, the default, is unlimited); deeper files
+// still count towards the deepest directory shown. Directories holding no
+// files don't appear.
+func handleTree(w http.ResponseWriter, r *http.Request) {
+	depth := 0
+	if v := r.URL.Query().Get("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid 'depth' parameter: %q", v))
+			return
+		}
+		depth = n
+	}
+
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	dirs := configuredDirs()
+	roots := make([]*TreeNode, 0, len(dirs))
+	for _, root := range dirs {
+		if !opts.reaches(reportedPath(root)) {
+			continue
+		}
+		top := &TreeNode{Name: filepath.Base(root), Path: reportedPath(root), dir: root}
+		walkAllowed(r, []string{root}, func(path string, d fs.DirEntry) error {
+			info, err := d.Info()
+			if err != nil {
+				requestLogger(r).Warn("Error getting file info", "path", path, "err", err)
+				return nil
+			}
+			rel, err := filepath.Rel(root, filepath.Dir(path))
+			if err != nil {
+				return nil
+			}
+
+			node := top
+			node.Files++
+			node.Size += info.Size()
+			if rel == "." {
+				return nil
+			}
+			for i, name := range strings.Split(filepath.ToSlash(rel), "/") {
+				if depth > 0 && i >= depth {
+					break
+				}
+				node = node.child(name)
+				node.Files++
+				node.Size += info.Size()
+			}
+			return nil
+		})
+		top.sortChildren()
+		roots = append(roots, top)
+	}
+	if walkCancelled(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TreeResponse{Host: config.FriendlyName, Roots: roots})
+}
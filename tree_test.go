@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleTree(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, "Shows", "Show A", "Season 1"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "Movies"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "Empty"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "readme.txt"), make([]byte, 1), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Movies", "a.mkv"), make([]byte, 10), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Shows", "Show A", "Season 1", "e01.mkv"), make([]byte, 20), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "Shows", "Show A", "Season 1", "e02.mkv"), make([]byte, 30), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	get := func(query string) TreeResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleTree(w, httptest.NewRequest(http.MethodGet, "/tree"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected status 200, got %d", query, w.Code)
+		}
+		var resp TreeResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := get("")
+	if len(resp.Roots) != 1 {
+		t.Fatalf("expected 1 root, got %d", len(resp.Roots))
+	}
+	root := resp.Roots[0]
+	if root.Files != 4 || root.Size != 61 {
+		t.Errorf("expected root with 4 files, 61 bytes, got %d, %d", root.Files, root.Size)
+	}
+	if len(root.Children) != 2 || root.Children[0].Name != "Movies" || root.Children[1].Name != "Shows" {
+		t.Fatalf("expected children [Movies Shows], got %+v", root.Children)
+	}
+	season := root.Children[1].Children[0].Children[0]
+	if season.Name != "Season 1" || season.Files != 2 || season.Size != 50 {
+		t.Errorf("expected Season 1 with 2 files, 50 bytes, got %+v", season)
+	}
+	if want := filepath.Join(tmpDir, "Shows", "Show A", "Season 1"); season.Path != want {
+		t.Errorf("expected path %s, got %s", want, season.Path)
+	}
+
+	shows := get("?depth=1").Roots[0].Children[1]
+	if shows.Files != 2 || shows.Size != 50 || len(shows.Children) != 0 {
+		t.Errorf("expected depth=1 to fold Shows into 2 files, 50 bytes and no children, got %+v", shows)
+	}
+
+	w := httptest.NewRecorder()
+	handleTree(w, httptest.NewRequest(http.MethodGet, "/tree?depth=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a negative depth, got %d", w.Code)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the browser UI: a single page that browses, searches, sorts and
+// downloads using the JSON endpoints, so it needs nothing server-side.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// handleUI serves the browser UI at /. Other unmatched paths still 404.
+func handleUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeWithETag(w, r, uiPage)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleUI(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", handleUI)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected an HTML content type, got %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "<title>filesystem-lister</title>") {
+		t.Error("expected the embedded page")
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/no-such-endpoint", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected other paths to 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// maxVerifyFiles bounds how many files one /verify request may hash.
+	maxVerifyFiles = 1000
+	// maxVerifyBody bounds the size of the /verify request body.
+	maxVerifyBody = 1 << 20
+)
+
+const (
+	verifyOK       = "ok"
+	verifyMismatch = "mismatch"
+	verifyMissing  = "missing"
+	verifySkipped  = "skipped" // not read: the request's read budget ran out
+	verifyError    = "error"   // couldn't be read, e.g. a directory; see Errors
+)
+
+var errNotRegular = errors.New("not a regular file")
+
+type VerifyResponse struct {
+	Host      string            `json:"host"`
+	Results   map[string]string `json:"results"`
+	Errors    map[string]string `json:"errors,omitempty"` // why each "error" path couldn't be read
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// handleVerify checks files against a client-supplied manifest. The body is a
+// JSON object of path to sha256 (hex, optionally prefixed "sha256:"), and each
+// path is reported as ok, mismatch or missing, skipped once the read budget
+// is used up, or error if it couldn't be read. Paths hidden by
+// X-Allowed-Prefixes are reported missing, so they can't be probed.
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var manifest map[string]string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxVerifyBody)).Decode(&manifest); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid manifest: %v", err))
+		return
+	}
+	if len(manifest) > maxVerifyFiles {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many files: %d (max %d)", len(manifest), maxVerifyFiles))
+		return
+	}
+
+	resolved := make(map[string]string, len(manifest))
+	for p := range manifest {
+		real, ok := resolveReportedPath(p)
+		if !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("path outside configured directories: %q", p))
+			return
+		}
+		resolved[p] = real
+	}
+
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+	budget := newReadBudget()
+	resp := VerifyResponse{Host: config.FriendlyName, Results: make(map[string]string, len(manifest))}
+	results := resp.Results
+	for p, want := range manifest {
+		if !opts.allowed(p) {
+			results[p] = verifyMissing
+			continue
+		}
+		// contentHashes treats anything but a regular file as missing.
+		var got string
+		err := errNotRegular
+		if info, statErr := os.Stat(resolved[p]); statErr != nil || info.Mode().IsRegular() {
+			got, err = contentHashes.sum(resolved[p], "sha256", budget)
+		}
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			results[p] = verifyMissing
+		case errors.Is(err, errReadBudgetExceeded):
+			results[p] = verifySkipped
+		case err != nil:
+			requestLogger(r).Warn("Error verifying file", "path", resolved[p], "err", err)
+			results[p] = verifyError
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]string)
+			}
+			resp.Errors[p] = err.Error()
+		case strings.EqualFold(got, strings.TrimPrefix(want, "sha256:")):
+			results[p] = verifyOK
+		default:
+			results[p] = verifyMismatch
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp.Truncated = budget.Exhausted()
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveReportedPath(t *testing.T) {
+	root := t.TempDir()
+	config.Dirs = []string{root}
+	t.Cleanup(func() { config.PathPrefix = "" })
+
+	tests := []struct {
+		prefix string
+		path   string
+		want   string
+		ok     bool
+	}{
+		{"", filepath.Join(root, "movie.mkv"), filepath.Join(root, "movie.mkv"), true},
+		{"", root + "/../etc/passwd", "", false},
+		{"", "/etc/passwd", "", false},
+		{"/remote/nas", "/remote/nas" + filepath.ToSlash(root) + "/movie.mkv", filepath.Join(root, "movie.mkv"), true},
+		{"/remote/nas", filepath.Join(root, "movie.mkv"), "", false},
+	}
+
+	for _, tt := range tests {
+		config.PathPrefix = tt.prefix
+		got, ok := resolveReportedPath(tt.path)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("resolveReportedPath(%q) with prefix %q = %q, %v; want %q, %v", tt.path, tt.prefix, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHandleVerify(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "good.mkv"), []byte("good"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "bad.mkv"), []byte("bad"), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handleVerify(w, req)
+		return w
+	}
+
+	manifest, _ := json.Marshal(map[string]string{
+		filepath.Join(tmpDir, "good.mkv"):    "sha256:" + sum("good"),
+		filepath.Join(tmpDir, "bad.mkv"):     sum("something else"),
+		filepath.Join(tmpDir, "missing.mkv"): sum("gone"),
+	})
+
+	w := post(string(manifest))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp VerifyResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	want := map[string]string{
+		filepath.Join(tmpDir, "good.mkv"):    verifyOK,
+		filepath.Join(tmpDir, "bad.mkv"):     verifyMismatch,
+		filepath.Join(tmpDir, "missing.mkv"): verifyMissing,
+	}
+	for p, status := range want {
+		if resp.Results[p] != status {
+			t.Errorf("%s: expected %s, got %s", p, status, resp.Results[p])
+		}
+	}
+
+	t.Run("hidden by X-Allowed-Prefixes", func(t *testing.T) {
+		os.Mkdir(filepath.Join(tmpDir, "season1"), 0755)
+		manifest, _ := json.Marshal(map[string]string{
+			filepath.Join(tmpDir, "good.mkv"): sum("good"),
+			filepath.Join(tmpDir, "season1"):  sum("dir"),
+		})
+		req := httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader(string(manifest)))
+		req.Header.Set("X-Allowed-Prefixes", filepath.Join(tmpDir, "season1"))
+		w := httptest.NewRecorder()
+		handleVerify(w, req)
+		var resp VerifyResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || resp.Results[filepath.Join(tmpDir, "good.mkv")] != verifyMissing {
+			t.Errorf("expected good.mkv reported missing outside the allowed prefix, got %d %s", w.Code, w.Body.String())
+		}
+		// A directory fails on its own rather than failing the request.
+		if resp.Results[filepath.Join(tmpDir, "season1")] != verifyError || resp.Errors[filepath.Join(tmpDir, "season1")] == "" {
+			t.Errorf("expected an error for the directory alone, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("path escaping root", func(t *testing.T) {
+		body := fmt.Sprintf(`{%q: "abc"}`, tmpDir+"/../outside.mkv")
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("too many files", func(t *testing.T) {
+		big := make(map[string]string, maxVerifyFiles+1)
+		for i := range maxVerifyFiles + 1 {
+			big[filepath.Join(tmpDir, fmt.Sprintf("f%d", i))] = "abc"
+		}
+		body, _ := json.Marshal(big)
+		if w := post(string(body)); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("wrong method", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/verify", nil)
+		w := httptest.NewRecorder()
+		handleVerify(w, req)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected status 405, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandleVerifyReadBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.mkv"), make([]byte, 60), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "b.mkv"), make([]byte, 60), 0644)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+	config.MaxReadBytes = 100
+	t.Cleanup(func() { config.MaxReadBytes = 0 })
+
+	manifest, _ := json.Marshal(map[string]string{
+		filepath.Join(tmpDir, "a.mkv"): "abc",
+		filepath.Join(tmpDir, "b.mkv"): "abc",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/verify", strings.NewReader(string(manifest)))
+	w := httptest.NewRecorder()
+	handleVerify(w, req)
+
+	var resp VerifyResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !resp.Truncated {
+		t.Error("expected response to be marked truncated")
+	}
+	counts := map[string]int{}
+	for _, status := range resp.Results {
+		counts[status]++
+	}
+	if counts[verifyMismatch] != 1 || counts[verifySkipped] != 1 {
+		t.Errorf("expected one hashed and one skipped file, got %v", resp.Results)
+	}
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanErrors counts files and directories walks couldn't read, for /metrics.
+var scanErrors atomic.Int64
+
+// accessError logs (with ctx's request ID, if any) and counts an entry a walk
+// couldn't read, and records it in ctx's walkErrors for the response; the
+// walk then carries on without it.
+func accessError(ctx context.Context, path string, err error) {
+	scanErrors.Add(1)
+	walkErrorsFrom(ctx).add(path, err)
+	contextLogger(ctx).Warn("Error accessing path", "path", path, "err", err)
+}
+
+// dirSettings holds the per-directory options given after a --dir path,
+// e.g. --dir /mnt/ssd,workers=8 or --dir /srv,mounts=skip.
+type dirSettings struct {
+	Workers    int  // concurrent directory readers; 0 or 1 walks sequentially
+	SkipMounts bool // don't descend into directories on another device
+}
+
+// parseDirSpec splits a --dir value into its path and settings. Options are
+// comma-separated key=value pairs after the path; trailing segments that
+// aren't recognised options are treated as part of the path, so directories
+// with commas in their names still work.
+func parseDirSpec(spec string) (string, dirSettings, error) {
+	var settings dirSettings
+	dir := spec
+
+	for {
+		i := strings.LastIndex(dir, ",")
+		if i < 0 {
+			break
+		}
+
+		key, value, _ := strings.Cut(dir[i+1:], "=")
+		switch key {
+		case "workers":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return "", settings, fmt.Errorf("workers must be a positive integer, got %q", value)
+			}
+			settings.Workers = n
+		case "mounts":
+			switch value {
+			case "cross":
+				settings.SkipMounts = false
+			case "skip":
+				settings.SkipMounts = true
+			default:
+				return "", settings, fmt.Errorf("mounts must be cross or skip, got %q", value)
+			}
+		default:
+			return dir, settings, nil
+		}
+
+		dir = dir[:i]
+	}
+
+	return dir, settings, nil
+}
+
+// parseDirs parses every --dir value into the configured roots and their
+// settings.
+func parseDirs(specs []string) ([]string, map[string]dirSettings, error) {
+	var dirs []string
+	settings := make(map[string]dirSettings)
+	for _, spec := range specs {
+		dir, s, err := parseDirSpec(spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --dir %q: %w", spec, err)
+		}
+		dirs = append(dirs, dir)
+		settings[dir] = s
+	}
+	if len(dirs) == 0 {
+		return nil, nil, errors.New("at least one --dir must be specified")
+	}
+	return dirs, settings, nil
+}
+
+// noInfoKey marks a walk's context when its caller won't look at file info,
+// as with ?nosize=1, so walkDirConcurrent leaves each DirEntry to stat lazily
+// rather than fetching every file's info up front.
+type noInfoKey struct{}
+
+// withoutFileInfo returns ctx marked with noInfoKey when needed is false.
+func withoutFileInfo(ctx context.Context, needed bool) context.Context {
+	if needed {
+		return ctx
+	}
+	return context.WithValue(ctx, noInfoKey{}, true)
+}
+
+// walkFunc is called by walkFiles for every file found under the scanned directories.
+type walkFunc func(path string, d fs.DirEntry) error
+
+// descendFunc reports whether a walk should enter the directory at path.
+type descendFunc func(path string, d fs.DirEntry) bool
+
+func alwaysDescend(string, fs.DirEntry) bool { return true }
+
+// sameDevice returns a descendFunc that refuses directories on a different
+// device than root, i.e. mount points of other filesystems (bind mounts,
+// autofs and other network shares). A mount is detected by the directory's
+// st_dev differing from root's, so it only works where fileKey reports
+// devices; elsewhere every directory is entered.
+func sameDevice(root string) descendFunc {
+	info, err := os.Stat(root)
+	if err != nil {
+		return alwaysDescend
+	}
+	rootKey, ok := fileKey(info)
+	if !ok {
+		return alwaysDescend
+	}
+
+	return func(path string, d fs.DirEntry) bool {
+		info, err := d.Info()
+		if err != nil {
+			return true
+		}
+		if key, ok := fileKey(info); ok && key.dev != rootKey.dev {
+			slog.Info("Skipping mount point", "path", path)
+			return false
+		}
+		return true
+	}
+}
+
+// pathDepth returns how many levels below root path is: 1 for an entry
+// directly inside root.
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
+
+// configuredRoot returns the configured --dir containing path, or path
+// itself if there is none.
+func configuredRoot(path string) string {
+	for _, dir := range configuredDirs() {
+		if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsLocal(rel) {
+			return dir
+		}
+	}
+	return path
+}
+
+// selectDirs returns the configured directories named in want, in --dir
+// order. Each may be given as configured or as reported in listings (with
+// any --path-prefix); naming anything else is an error.
+func selectDirs(want []string) ([]string, error) {
+	var dirs []string
+	matched := make([]bool, len(want))
+	for _, dir := range configuredDirs() {
+		clean := filepath.Clean(dir)
+		selected := false
+		for i, w := range want {
+			if w == dir || w == clean || w == reportedPath(clean) {
+				matched[i], selected = true, true
+			}
+		}
+		if selected {
+			dirs = append(dirs, dir)
+		}
+	}
+	for i, ok := range matched {
+		if !ok {
+			return nil, fmt.Errorf("%q is not a configured directory", want[i])
+		}
+	}
+	return dirs, nil
+}
+
+// withinMaxDepth returns a descendFunc that enters directories only while
+// their files would be at most maxDepth levels below root, so files deeper
+// than --max-depth are never read. Depth counts from the configured root even
+// when a walk starts lower down.
+func withinMaxDepth(root string, maxDepth int, next descendFunc) descendFunc {
+	return func(path string, d fs.DirEntry) bool {
+		return pathDepth(root, path) < maxDepth && next(path, d)
+	}
+}
+
+// excluded reports whether path matches an --exclude pattern, by its base
+// name or its full path, or is hidden with --include-hidden=false.
+func excluded(path string) bool {
+	if config.SkipHidden && hidden(filepath.Base(path)) {
+		return true
+	}
+	for _, pattern := range config.Excludes {
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// excluding reports whether excluded can match anything.
+func excluding() bool {
+	return len(config.Excludes) > 0 || config.SkipHidden
+}
+
+// hidden reports whether name is a dotfile or dot-directory.
+func hidden(name string) bool {
+	return len(name) > 1 && name[0] == '.' && name != ".."
+}
+
+// scanIncludes reports whether the file at path belongs in the listing at
+// all: it isn't excluded and, if --ext is set, has one of those extensions.
+func scanIncludes(path string) bool {
+	if excluded(path) {
+		return false
+	}
+	if len(config.Extensions) == 0 {
+		return true
+	}
+	name := filepath.Base(path)
+	for _, ext := range config.Extensions {
+		if matchExtension(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutExcluded returns a descendFunc that prunes excluded directories.
+func withoutExcluded(next descendFunc) descendFunc {
+	return func(path string, d fs.DirEntry) bool {
+		return !excluded(path) && next(path, d)
+	}
+}
+
+// untilDone returns a descendFunc that stops entering directories once ctx
+// is cancelled.
+func untilDone(ctx context.Context, next descendFunc) descendFunc {
+	return func(path string, d fs.DirEntry) bool {
+		return ctx.Err() == nil && next(path, d)
+	}
+}
+
+// walkFiles calls fn for every file under each directory in dirs. Returning
+// fs.SkipAll from fn stops the walk, and cancelling ctx stops it with
+// ctx.Err(). With --scan-interval the files come from the background index
+// when every dir is an indexed root; otherwise the disk is walked, at most
+// once per --min-scan-interval for each root (see scanSnapshot).
+func walkFiles(ctx context.Context, dirs []string, fn walkFunc) error {
+	_, err := walkFilesAged(ctx, dirs, fn)
+	return err
+}
+
+// walkFilesAged is walkFiles, also returning how old the files fn was given
+// are: non-zero only when --min-scan-interval replayed an earlier walk.
+func walkFilesAged(ctx context.Context, dirs []string, fn walkFunc) (time.Duration, error) {
+	if served, err := index.walk(ctx, dirs, fn); served {
+		return 0, err
+	}
+	if config.MinScanInterval > 0 {
+		return diskSnapshot.walk(ctx, dirs, config.MinScanInterval, fn)
+	}
+	return 0, walkDisk(ctx, dirs, fn)
+}
+
+// walkDisk walks each directory in dirs and calls fn for every file.
+// Entries that can't be read are logged, skipped and recorded in ctx's
+// walkErrors, if it has one. When --expand-archives is
+// set, the members of .zip files are reported as well. Returning fs.SkipAll
+// from fn stops the walk, and cancelling ctx stops it with ctx.Err(): no more
+// files are visited and no more directories entered.
+//
+// The walk waits for a --max-concurrent-scans slot first.
+//
+// Directories configured with workers > 1 (or all of them, with
+// --scan-workers > 1) are read concurrently, in which case files under them
+// are visited in no particular order; --scan-workers > 1 also walks the dirs
+// at the same time. Otherwise the walk is depth-first, or breadth-first with
+// --breadth-first.
+func walkDisk(ctx context.Context, dirs []string, fn walkFunc) error {
+	release, err := acquireScanSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if scanPacer != nil {
+		start, entries := time.Now(), 0
+		unpaced := fn
+		fn = func(path string, d fs.DirEntry) error {
+			scanPacer.wait()
+			entries++
+			return unpaced(path, d)
+		}
+		defer func() {
+			elapsed := time.Since(start)
+			contextLogger(ctx).Info("Walked entries", "entries", entries, "duration", elapsed, "per_second", float64(entries)/elapsed.Seconds())
+		}()
+	}
+
+	if excluding() || len(config.Extensions) > 0 {
+		unfiltered := fn
+		fn = func(path string, d fs.DirEntry) error {
+			if !scanIncludes(path) {
+				return nil
+			}
+			return unfiltered(path, d)
+		}
+	}
+
+	visit := func(path string, d fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(path, d); err != nil {
+			return err
+		}
+
+		if config.ExpandArchives && isZipArchive(d.Name()) {
+			if err := walkArchive(path, fn); err == fs.SkipAll {
+				return err
+			} else if err != nil {
+				scanErrors.Add(1)
+				walkErrorsFrom(ctx).add(path, err)
+				contextLogger(ctx).Warn("Error reading archive", "path", path, "err", err)
+			}
+		}
+
+		return nil
+	}
+
+	if config.ScanWorkers > 1 && len(dirs) > 1 {
+		return walkRootsConcurrent(ctx, dirs, visit)
+	}
+	for _, dir := range dirs {
+		err := walkRoot(ctx, dir, visit)
+		if err == fs.SkipAll {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			scanErrors.Add(1)
+			contextLogger(ctx).Error("Error walking directory", "dir", dir, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// walkRoot walks one of walkDisk's directories with its --dir settings,
+// falling back to --scan-workers for its worker count.
+func walkRoot(ctx context.Context, dir string, visit walkFunc) error {
+	settings := dirSettingsFor(dir)
+	descend := descendFunc(alwaysDescend)
+	if settings.SkipMounts {
+		descend = sameDevice(dir)
+	}
+	if config.MaxDepth > 0 {
+		descend = withinMaxDepth(configuredRoot(dir), config.MaxDepth, descend)
+	}
+	if excluding() {
+		descend = withoutExcluded(descend)
+	}
+	if config.FollowSymlinks {
+		descend = enteringOnce(dir, descend)
+	}
+	if ctx.Done() != nil {
+		descend = untilDone(ctx, descend)
+	}
+
+	workers := settings.Workers
+	if workers == 0 {
+		workers = config.ScanWorkers
+	}
+	switch {
+	case workers > 1:
+		return walkDirConcurrent(ctx, dir, workers, ctx.Value(noInfoKey{}) == nil, descend, visit)
+	case config.BreadthFirst:
+		return walkDirBreadthFirst(ctx, dir, descend, visit)
+	default:
+		return walkDirSequential(ctx, dir, descend, visit)
+	}
+}
+
+// walkRootsConcurrent walks every one of dirs at once, for roots on separate
+// disks. visit is still called by one goroutine at a time, and once it
+// returns an error every walk stops at its next file.
+func walkRootsConcurrent(ctx context.Context, dirs []string, visit walkFunc) error {
+	var (
+		mu      sync.Mutex // guards stopErr and calls to visit
+		stopErr error
+		wg      sync.WaitGroup
+	)
+	serial := func(path string, d fs.DirEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopErr != nil {
+			return stopErr
+		}
+		stopErr = visit(path, d)
+		return stopErr
+	}
+
+	errs := make([]error, len(dirs))
+	for i, dir := range dirs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = walkRoot(ctx, dir, serial)
+		}()
+	}
+	wg.Wait()
+
+	if stopErr == fs.SkipAll {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for i, err := range errs {
+		if err != nil && err != fs.SkipAll {
+			scanErrors.Add(1)
+			contextLogger(ctx).Error("Error walking directory", "dir", dirs[i], "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDirSequential visits the files under root depth-first, in lexical
+// order within each directory, like filepath.WalkDir; unlike WalkDir it can
+// follow symlinked directories, and passes fs.SkipAll back to the caller so a
+// stop request can span several roots.
+func walkDirSequential(ctx context.Context, root string, descend descendFunc, visit walkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		accessError(ctx, root, err)
+		return nil
+	}
+	if d := followLink(root, fs.FileInfoToDirEntry(info)); !d.IsDir() {
+		return visit(root, d)
+	}
+	return walkDirDepthFirst(ctx, root, descend, visit)
+}
+
+func walkDirDepthFirst(ctx context.Context, dir string, descend descendFunc, visit walkFunc) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		accessError(ctx, dir, err)
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		e = followLink(path, e)
+		if e.IsDir() {
+			if descend(path, e) {
+				if err := walkDirDepthFirst(ctx, path, descend, visit); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := visit(path, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDirBreadthFirst visits the files under root level by level, so every
+// file at depth n is visited before any file at depth n+1; within a directory
+// files are visited in lexical order. The cost is memory: the queue holds
+// every directory of the next level at once, where a depth-first walk only
+// holds the current path.
+func walkDirBreadthFirst(ctx context.Context, root string, descend descendFunc, visit walkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		accessError(ctx, root, err)
+		return nil
+	}
+	if d := followLink(root, fs.FileInfoToDirEntry(info)); !d.IsDir() {
+		return visit(root, d)
+	}
+
+	queue := []string{root}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			accessError(ctx, dir, err)
+		}
+
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			e = followLink(path, e)
+			if e.IsDir() {
+				if descend(path, e) {
+					queue = append(queue, path)
+				}
+				continue
+			}
+			if err := visit(path, e); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkDirConcurrent visits the files under root using a pool of workers that
+// read directories in parallel. The pool is the backpressure: at most workers
+// directories are being read at any one time, and discovered subdirectories
+// wait in a queue. With prefetch, file info is fetched by the workers so the
+// stat calls are spread across the pool too; without it, entries are left
+// lazy as the sequential walkers leave them. visit is called by one goroutine
+// at a time.
+func walkDirConcurrent(ctx context.Context, root string, workers int, prefetch bool, descend descendFunc, visit walkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		accessError(ctx, root, err)
+		return nil
+	}
+	if d := followLink(root, fs.FileInfoToDirEntry(info)); !d.IsDir() {
+		return visit(root, d)
+	}
+
+	var (
+		mu      sync.Mutex // guards queue, active, stopErr and calls to visit
+		cond    = sync.NewCond(&mu)
+		queue   = []string{root}
+		active  int
+		stopErr error
+		wg      sync.WaitGroup
+	)
+
+	// next blocks until there is a directory to read, or returns false once
+	// the queue is drained and no worker can add to it.
+	next := func() (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(queue) == 0 && active > 0 && stopErr == nil {
+			cond.Wait()
+		}
+		if len(queue) == 0 || stopErr != nil {
+			cond.Broadcast()
+			return "", false
+		}
+		dir := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		active++
+		return dir, true
+	}
+
+	readDir := func(dir string) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			accessError(ctx, dir, err)
+		}
+
+		var subdirs []string
+		var files []fs.DirEntry
+		for _, e := range entries {
+			e = followLink(filepath.Join(dir, e.Name()), e)
+			if e.IsDir() {
+				if path := filepath.Join(dir, e.Name()); descend(path, e) {
+					subdirs = append(subdirs, path)
+				}
+				continue
+			}
+			if !scanIncludes(filepath.Join(dir, e.Name())) {
+				continue // don't stat files that will be filtered out
+			}
+			if prefetch {
+				if info, err := e.Info(); err == nil {
+					e = fs.FileInfoToDirEntry(info)
+				}
+			}
+			files = append(files, e)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		queue = append(queue, subdirs...)
+		for _, f := range files {
+			if stopErr != nil {
+				break
+			}
+			if err := visit(filepath.Join(dir, f.Name()), f); err != nil {
+				stopErr = err
+			}
+		}
+		active--
+		cond.Broadcast()
+	}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				dir, ok := next()
+				if !ok {
+					return
+				}
+				readDir(dir)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return stopErr
+}
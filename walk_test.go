@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestParseDirSpec(t *testing.T) {
+	tests := []struct {
+		spec        string
+		wantDir     string
+		wantWorkers int
+		wantErr     bool
+	}{
+		{"/mnt/media", "/mnt/media", 0, false},
+		{"/mnt/ssd,workers=8", "/mnt/ssd", 8, false},
+		{"/mnt/odd,name", "/mnt/odd,name", 0, false},
+		{"/mnt/odd,name,workers=2", "/mnt/odd,name", 2, false},
+		{"/mnt/hdd,workers=0", "", 0, true},
+		{"/mnt/hdd,workers=lots", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			dir, settings, err := parseDirSpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDirSpec(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if dir != tt.wantDir || settings.Workers != tt.wantWorkers {
+				t.Errorf("parseDirSpec(%q) = %q, %+v; want %q, workers=%d", tt.spec, dir, settings, tt.wantDir, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+func makeTestTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for i := range 5 {
+		dir := filepath.Join(root, fmt.Sprintf("show%d", i), "season1")
+		os.MkdirAll(dir, 0755)
+		for j := range 4 {
+			os.WriteFile(filepath.Join(dir, fmt.Sprintf("ep%d.mkv", j)), []byte("episode"), 0644)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "top.mkv"), []byte("top"), 0644)
+	return root
+}
+
+func collectPaths(t *testing.T, root string) []string {
+	t.Helper()
+	var paths []string
+	walkFiles(context.Background(), []string{root}, func(path string, d fs.DirEntry) error {
+		paths = append(paths, path)
+		return nil
+	})
+	sort.Strings(paths)
+	return paths
+}
+
+func TestWalkFilesConcurrentMatchesSequential(t *testing.T) {
+	root := makeTestTree(t)
+	t.Cleanup(func() { config.DirSettings = nil })
+
+	config.DirSettings = nil
+	sequential := collectPaths(t, root)
+
+	config.DirSettings = map[string]dirSettings{root: {Workers: 4}}
+	concurrent := collectPaths(t, root)
+
+	if len(sequential) != 21 {
+		t.Fatalf("expected 21 files, got %d", len(sequential))
+	}
+	if fmt.Sprint(sequential) != fmt.Sprint(concurrent) {
+		t.Errorf("concurrent walk differs from sequential:\n%v\n%v", concurrent, sequential)
+	}
+}
+
+func TestWalkFilesSkipAllStopsConcurrentWalk(t *testing.T) {
+	root := makeTestTree(t)
+	config.DirSettings = map[string]dirSettings{root: {Workers: 4}}
+	t.Cleanup(func() { config.DirSettings = nil })
+
+	seen := 0
+	err := walkFiles(context.Background(), []string{root, root}, func(path string, d fs.DirEntry) error {
+		seen++
+		if seen == 3 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("expected nil error, got %v", err)
+	}
+	if seen != 3 {
+		t.Errorf("expected walk to stop after 3 files, saw %d", seen)
+	}
+}
+
+func TestWalkFilesStopsWhenCancelled(t *testing.T) {
+	root := makeTestTree(t)
+	for _, workers := range []int{0, 4} {
+		config.DirSettings = map[string]dirSettings{root: {Workers: workers}}
+		t.Cleanup(func() { config.DirSettings = nil })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		seen := 0
+		err := walkFiles(ctx, []string{root}, func(path string, d fs.DirEntry) error {
+			seen++
+			if seen == 3 {
+				cancel()
+			}
+			return nil
+		})
+
+		if err != context.Canceled {
+			t.Errorf("workers=%d: expected context.Canceled, got %v", workers, err)
+		}
+		if seen != 3 {
+			t.Errorf("workers=%d: expected walk to stop after 3 files, saw %d", workers, seen)
+		}
+	}
+}
+
+func TestWalkFilesScanWorkers(t *testing.T) {
+	roots := []string{makeTestTree(t), makeTestTree(t), makeTestTree(t)}
+	t.Cleanup(func() { config.ScanWorkers = 0 })
+
+	var sequential []string
+	walkFiles(context.Background(), roots, func(path string, d fs.DirEntry) error {
+		sequential = append(sequential, path)
+		return nil
+	})
+	sort.Strings(sequential)
+
+	config.ScanWorkers = 4
+	var concurrent []string
+	err := walkFiles(context.Background(), roots, func(path string, d fs.DirEntry) error {
+		concurrent = append(concurrent, path)
+		return nil
+	})
+	sort.Strings(concurrent)
+	if err != nil || len(sequential) != 63 || fmt.Sprint(sequential) != fmt.Sprint(concurrent) {
+		t.Errorf("expected the concurrent walk to match the sequential one's 63 files, got %d (%v)", len(concurrent), err)
+	}
+
+	// Stopping one root's walk stops them all.
+	seen := 0
+	err = walkFiles(context.Background(), roots, func(path string, d fs.DirEntry) error {
+		if seen++; seen == 3 {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil || seen != 3 {
+		t.Errorf("expected a nil error after 3 files, got %v after %d", err, seen)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	seen = 0
+	err = walkFiles(ctx, roots, func(path string, d fs.DirEntry) error {
+		if seen++; seen == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled || seen != 3 {
+		t.Errorf("expected context.Canceled after 3 files, got %v after %d", err, seen)
+	}
+}
+
+func TestWalkFilesConcurrentWithoutFileInfo(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("test"), 0644)
+	config.ScanWorkers = 2
+	t.Cleanup(func() { config.ScanWorkers = 0 })
+
+	// Removing the file before asking for its info shows whether the walk
+	// had already fetched it.
+	infoAfterRemoval := func(ctx context.Context) error {
+		var infoErr error
+		walkFiles(ctx, []string{tmpDir}, func(path string, d fs.DirEntry) error {
+			os.Remove(path)
+			_, infoErr = d.Info()
+			return nil
+		})
+		os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("test"), 0644)
+		return infoErr
+	}
+	if err := infoAfterRemoval(context.Background()); err != nil {
+		t.Errorf("expected the concurrent walk to prefetch file info, got %v", err)
+	}
+	// Windows directory reads carry each file's info, so there is nothing lazy to see.
+	if err := infoAfterRemoval(withoutFileInfo(context.Background(), false)); err == nil && runtime.GOOS != "windows" {
+		t.Error("expected file info to be left for the caller to fetch")
+	}
+}
+
+func TestWalkFilesBreadthFirst(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "a", "deep"), 0755)
+	os.MkdirAll(filepath.Join(root, "b"), 0755)
+	os.WriteFile(filepath.Join(root, "a", "deep", "3.mkv"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "a", "2.mkv"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "b", "2.mkv"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "z.mkv"), nil, 0644)
+
+	config.BreadthFirst = true
+	t.Cleanup(func() { config.BreadthFirst = false })
+
+	var got []string
+	walkFiles(context.Background(), []string{root}, func(path string, d fs.DirEntry) error {
+		rel, _ := filepath.Rel(root, path)
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+
+	want := []string{"z.mkv", "a/2.mkv", "b/2.mkv", "a/deep/3.mkv"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected breadth-first order %v, got %v", want, got)
+	}
+}
+
+func TestParseDirSpecMounts(t *testing.T) {
+	dir, settings, err := parseDirSpec("/srv/media,mounts=skip,workers=4")
+	if err != nil || dir != "/srv/media" || !settings.SkipMounts || settings.Workers != 4 {
+		t.Errorf("unexpected parse: %q, %+v, %v", dir, settings, err)
+	}
+
+	if _, settings, _ := parseDirSpec("/srv/media,mounts=cross"); settings.SkipMounts {
+		t.Error("expected mounts=cross to descend into mounts")
+	}
+	if _, _, err := parseDirSpec("/srv/media,mounts=maybe"); err == nil {
+		t.Error("expected an error for mounts=maybe")
+	}
+}
+
+func TestWalkFilesSkipMountsKeepsSameDevice(t *testing.T) {
+	root := makeTestTree(t)
+	want := collectPaths(t, root)
+	t.Cleanup(func() { config.DirSettings = nil })
+
+	for _, settings := range []dirSettings{{SkipMounts: true}, {SkipMounts: true, Workers: 4}} {
+		config.DirSettings = map[string]dirSettings{root: settings}
+		if got := collectPaths(t, root); len(got) != len(want) {
+			t.Errorf("%+v: expected %d files on the same device, got %d", settings, len(want), len(got))
+		}
+	}
+}
+
+func TestWalkFilesMaxDepth(t *testing.T) {
+	root := makeTestTree(t)
+	config.Dirs = []string{root}
+	t.Cleanup(func() {
+		config.MaxDepth = 0
+		config.BreadthFirst = false
+		config.DirSettings = nil
+	})
+
+	tests := []struct {
+		maxDepth int
+		want     int
+	}{
+		{1, 1},  // top.mkv
+		{2, 1},  // show dirs hold no files directly
+		{3, 21}, // every episode
+		{0, 21},
+	}
+
+	modes := map[string]func(){
+		"sequential":    func() {},
+		"breadth-first": func() { config.BreadthFirst = true },
+		"concurrent":    func() { config.DirSettings = map[string]dirSettings{root: {Workers: 4}} },
+	}
+
+	for name, setup := range modes {
+		config.BreadthFirst = false
+		config.DirSettings = nil
+		setup()
+		for _, tt := range tests {
+			config.MaxDepth = tt.maxDepth
+			if got := len(collectPaths(t, root)); got != tt.want {
+				t.Errorf("%s, max depth %d: expected %d files, got %d", name, tt.maxDepth, tt.want, got)
+			}
+		}
+	}
+
+	// A walk starting below the root still counts depth from the root.
+	config.BreadthFirst = false
+	config.DirSettings = nil
+	config.MaxDepth = 2
+	if got := collectPaths(t, filepath.Join(root, "show0")); len(got) != 0 {
+		t.Errorf("expected no files within depth 2 under show0, got %v", got)
+	}
+}
+
+func TestWalkFilesExclude(t *testing.T) {
+	root := makeTestTree(t)
+	os.MkdirAll(filepath.Join(root, "show0", "@eaDir"), 0755)
+	os.WriteFile(filepath.Join(root, "show0", "@eaDir", "thumb.jpg"), []byte("thumb"), 0644)
+	os.WriteFile(filepath.Join(root, ".DS_Store"), []byte("junk"), 0644)
+
+	config.Dirs = []string{root}
+	config.Excludes = []string{"@eaDir", ".DS_Store", filepath.Join(root, "show1")}
+	t.Cleanup(func() { config.Excludes = nil })
+
+	// Pruned directories are never entered, so their files aren't even seen.
+	entered := 0
+	descend := withoutExcluded(func(path string, d fs.DirEntry) bool {
+		entered++
+		return true
+	})
+	walkDirSequential(context.Background(), root, descend, func(path string, d fs.DirEntry) error { return nil })
+	// show0, show0/season1, show2-4 and their season1 dirs.
+	if entered != 8 {
+		t.Errorf("expected 8 directories entered, got %d", entered)
+	}
+
+	got := collectPaths(t, root)
+	if len(got) != 17 {
+		t.Errorf("expected 17 files (show1 and junk excluded), got %d: %v", len(got), got)
+	}
+	for _, p := range got {
+		if filepath.Base(p) == ".DS_Store" || filepath.Base(p) == "thumb.jpg" {
+			t.Errorf("expected %s to be excluded", p)
+		}
+	}
+}
+
+func TestWalkFilesExtensions(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"movie.mkv", "clip.MP4", "movie.nfo", "poster.jpg", "backup.tar.gz"} {
+		os.WriteFile(filepath.Join(root, name), []byte("test"), 0644)
+	}
+
+	config.Dirs = []string{root}
+	config.Extensions = []string{"mkv", ".mp4", "tar.gz"}
+	t.Cleanup(func() { config.Extensions = nil })
+
+	var names []string
+	for _, p := range collectPaths(t, root) {
+		names = append(names, filepath.Base(p))
+	}
+	want := []string{"backup.tar.gz", "clip.MP4", "movie.mkv"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestWalkFilesSkipHidden(t *testing.T) {
+	root := filepath.Join(t.TempDir(), ".media") // a hidden root is still walked
+	os.MkdirAll(filepath.Join(root, ".git", "objects"), 0755)
+	os.MkdirAll(filepath.Join(root, "show"), 0755)
+	os.WriteFile(filepath.Join(root, ".git", "objects", "pack"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(root, ".DS_Store"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(root, "show", ".episode1.mkv.part"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(root, "show", "episode1.mkv"), []byte("test"), 0644)
+	os.WriteFile(filepath.Join(root, "..notes"), []byte("test"), 0644)
+
+	config.Dirs = []string{root}
+	if got := collectPaths(t, root); len(got) != 5 {
+		t.Fatalf("expected hidden files listed by default, got %v", got)
+	}
+
+	config.SkipHidden = true
+	t.Cleanup(func() { config.SkipHidden = false })
+
+	// Hidden directories are pruned, never read.
+	var entered []string
+	descend := withoutExcluded(func(path string, d fs.DirEntry) bool {
+		entered = append(entered, filepath.Base(path))
+		return true
+	})
+	walkDirSequential(context.Background(), root, descend, func(path string, d fs.DirEntry) error { return nil })
+	if fmt.Sprint(entered) != "[show]" {
+		t.Errorf("expected only show entered, got %v", entered)
+	}
+
+	got := collectPaths(t, root)
+	if want := []string{filepath.Join(root, "show", "episode1.mkv")}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestWalkFilesFollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "real"), 0755)
+	os.WriteFile(filepath.Join(root, "real", "file.mkv"), []byte("0123456789"), 0644)
+	if err := os.Symlink(root, filepath.Join(root, "real", "loop")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	os.Symlink(filepath.Join(root, "real"), filepath.Join(root, "alias"))
+	os.Symlink(filepath.Join("real", "file.mkv"), filepath.Join(root, "link.mkv"))
+	os.Symlink(filepath.Join(root, "missing"), filepath.Join(root, "dangling.mkv"))
+	t.Cleanup(func() {
+		config.FollowSymlinks, config.BreadthFirst, config.DirSettings = false, false, nil
+	})
+
+	walk := func() map[string]fs.DirEntry {
+		files := make(map[string]fs.DirEntry)
+		walkFiles(context.Background(), []string{root}, func(path string, d fs.DirEntry) error {
+			rel, _ := filepath.Rel(root, path)
+			files[filepath.ToSlash(rel)] = d
+			return nil
+		})
+		return files
+	}
+
+	// Unfollowed, every link is listed as itself.
+	if files := walk(); len(files) != 5 || files["alias"] == nil || files["real/loop"] == nil {
+		t.Fatalf("expected the links listed as files, got %v", slices.Sorted(maps.Keys(files)))
+	}
+
+	config.FollowSymlinks = true
+	for _, mode := range []string{"sequential", "breadth-first", "workers"} {
+		t.Run(mode, func(t *testing.T) {
+			config.BreadthFirst = mode == "breadth-first"
+			config.DirSettings = nil
+			if mode == "workers" {
+				config.DirSettings = map[string]dirSettings{root: {Workers: 4}}
+			}
+
+			// real and alias are the same directory, so only one is
+			// walked, and the loop back to root isn't entered.
+			files := walk()
+			if len(files) != 3 || (files["real/file.mkv"] == nil) == (files["alias/file.mkv"] == nil) {
+				t.Fatalf("expected file.mkv once, link.mkv and dangling.mkv, got %v", slices.Sorted(maps.Keys(files)))
+			}
+
+			entry, err := newFileEntry(filepath.Join(root, "link.mkv"), files["link.mkv"], listOptions{})
+			if err != nil || !entry.Symlink || entry.Size != 10 || entry.Target != filepath.Join("real", "file.mkv") {
+				t.Errorf("expected link.mkv with its target's size, got %+v (%v)", entry, err)
+			}
+			if d := files["dangling.mkv"]; d == nil || d.Type()&fs.ModeSymlink == 0 {
+				t.Errorf("expected the dangling link listed as a link, got %v", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// maxWalkErrors caps how many unreadable paths one response reports; a
+// failing mount can otherwise produce one per directory under it. Any more
+// are only logged.
+const maxWalkErrors = 100
+
+// WalkError is a file or directory a walk couldn't read, such as an
+// unmounted NFS share or one without permission. Its files are missing from
+// the listing.
+type WalkError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+	Host  string `json:"host,omitempty"` // with --peer: the lister the path is on
+}
+
+// walkErrors collects the WalkErrors of the walks made for one request. A
+// nil *walkErrors discards them.
+type walkErrors struct {
+	mu   sync.Mutex
+	errs []WalkError
+}
+
+type walkErrorsKey struct{}
+
+// withWalkErrors gives every request somewhere for its walks to record what
+// they couldn't read, for writeListResponse to report.
+func withWalkErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), walkErrorsKey{}, &walkErrors{})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// walkErrorsFrom returns ctx's collector, or nil if it has none.
+func walkErrorsFrom(ctx context.Context) *walkErrors {
+	errs, _ := ctx.Value(walkErrorsKey{}).(*walkErrors)
+	return errs
+}
+
+func (e *walkErrors) add(path string, err error) {
+	if e == nil {
+		return
+	}
+	e.addAll([]WalkError{{Path: reportedPath(path), Error: err.Error()}})
+}
+
+func (e *walkErrors) addAll(errs []WalkError) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := min(len(errs), maxWalkErrors-len(e.errs))
+	e.errs = append(e.errs, errs[:n]...)
+}
+
+// list returns the errors collected so far.
+func (e *walkErrors) list() []WalkError {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.errs)
+}
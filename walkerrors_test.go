@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListReportsWalkErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "movie.mkv"), []byte("test"), 0644)
+	missing := filepath.Join(t.TempDir(), "unmounted")
+
+	handler := withWalkErrors(http.HandlerFunc(handleList))
+	list := func(t *testing.T, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/list"+query, nil))
+		return w
+	}
+	check := func(t *testing.T) {
+		t.Helper()
+		w := list(t, "")
+		var resp ListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || len(resp.Files) != 1 || !resp.Partial {
+			t.Fatalf("expected a partial listing of 1 file, got %d %s", w.Code, w.Body.String())
+		}
+		if len(resp.Errors) != 1 || resp.Errors[0].Path != missing || resp.Errors[0].Error == "" {
+			t.Errorf("expected an error for %s, got %+v", missing, resp.Errors)
+		}
+
+		w = list(t, "?strict=1")
+		var errResp ErrorResponse
+		json.Unmarshal(w.Body.Bytes(), &errResp)
+		if w.Code != http.StatusServiceUnavailable || len(errResp.Errors) != 1 {
+			t.Errorf("expected 503 with the error under ?strict=1, got %d %s", w.Code, w.Body.String())
+		}
+	}
+
+	config.Dirs = []string{tmpDir, missing}
+	t.Run("disk", check)
+
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+	index.scan()
+	t.Run("index", check)
+
+	// A readable empty directory is complete, not partial.
+	config.Dirs = []string{t.TempDir()}
+	index = nil
+	w := list(t, "?strict=1")
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Partial || resp.Errors != nil {
+		t.Errorf("expected a complete empty listing, got %d %s", w.Code, w.Body.String())
+	}
+}
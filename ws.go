@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsKeepAlive is how often an idle /ws connection is pinged so proxies
+// don't close it.
+var wsKeepAlive = 30 * time.Second
+
+// wsGUID is the fixed suffix hashed into Sec-WebSocket-Accept (RFC 6455).
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsMaxFrame bounds what a client may send; it has nothing to say beyond
+// control frames.
+const wsMaxFrame = 64 << 10
+
+// handleWS upgrades to a WebSocket and sends each index change as a text
+// message holding a ChangeEvent. Messages from the client are ignored apart
+// from ping and close. Needs an index (--scan-interval or --watch): with
+// --watch changes arrive within a second, otherwise at the next rescan.
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	if index == nil {
+		writeError(w, http.StatusServiceUnavailable, "change notifications need an index (--scan-interval or --watch)")
+		return
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeError(w, http.StatusBadRequest, "expected a WebSocket upgrade")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(w, http.StatusUpgradeRequired, "unsupported WebSocket version")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "missing Sec-WebSocket-Key")
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "WebSocket upgrade not supported")
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{}) // the server's timeouts are for HTTP requests
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	ch := changes.subscribe()
+	defer changes.unsubscribe(ch)
+	opts := listOptions{AllowedPrefixes: allowedPrefixes(r)}
+
+	ws := &wsConn{conn: conn}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.readLoop(rw.Reader)
+	}()
+
+	keepAlive := time.NewTicker(wsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case ev := <-ch:
+			if !opts.allowed(ev.Path) {
+				continue
+			}
+			data, _ := json.Marshal(ev)
+			if ws.write(wsText, data) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if ws.write(wsPing, nil) != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-shutdownStarted(r.Context()):
+			ws.write(wsClose, []byte{0x03, 0xE9}) // 1001 going away
+			return
+		}
+	}
+}
+
+// headerHasToken reports whether a comma-separated header such as
+// Connection lists token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn writes frames to a hijacked connection. Writes come from both the
+// event loop and the reader's pong and close replies, so they are locked.
+type wsConn struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// write sends one unfragmented, unmasked frame, as servers must.
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// readLoop reads client frames until the connection fails or the client
+// closes it, answering pings and echoing the close.
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsPing:
+			c.write(wsPong, payload)
+		case wsClose:
+			c.write(wsClose, payload[:min(len(payload), 2)])
+			return
+		}
+	}
+}
+
+// readWSFrame reads one masked client frame and returns its opcode and
+// unmasked payload. Fragments are returned as they come; only their opcode
+// differs.
+func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("unmasked client frame")
+	}
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrame {
+		return 0, nil, fmt.Errorf("client frame of %d bytes", n)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return head[0] & 0x0F, payload, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleWS(t *testing.T) {
+	config.Dirs = []string{t.TempDir()}
+
+	w := httptest.NewRecorder()
+	handleWS(w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 without an index, got %d", w.Code)
+	}
+
+	index = newFileIndex(config.Dirs)
+	t.Cleanup(func() { index = nil })
+	w = httptest.NewRecorder()
+	handleWS(w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without an upgrade, got %d", w.Code)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(handleWS))
+	defer srv.Close()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The example key and accept value from RFC 6455.
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("expected 101 with the RFC's accept value, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// Wait for the handler to subscribe before publishing.
+	for changes.subscribers() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	changes.publish([]ChangeEvent{{Type: "removed", Path: "/media/a.mkv"}})
+
+	opcode, payload, err := readServerFrame(br)
+	if err != nil || opcode != wsText {
+		t.Fatalf("expected a text frame, got %x (%v)", opcode, err)
+	}
+	var ev ChangeEvent
+	if err := json.Unmarshal(payload, &ev); err != nil || ev.Type != "removed" || ev.Path != "/media/a.mkv" {
+		t.Errorf("expected the removed event, got %q (%v)", payload, err)
+	}
+	if strings.Contains(string(payload), "mtime") {
+		t.Errorf("expected no mtime for a removal, got %s", payload)
+	}
+
+	// A masked close frame is echoed and ends the connection.
+	mask := []byte{1, 2, 3, 4}
+	code := []byte{0x03 ^ 1, 0xE8 ^ 2}
+	conn.Write(append([]byte{0x80 | wsClose, 0x80 | 2}, append(mask, code...)...))
+	opcode, echoed, err := readServerFrame(br)
+	if err != nil || opcode != wsClose || string(echoed) != "\x03\xe8" {
+		t.Errorf("expected the close echoed with code 1000, got %x %x (%v)", opcode, echoed, err)
+	}
+}
+
+// readServerFrame reads one small unmasked frame.
+func readServerFrame(br *bufio.Reader) (byte, []byte, error) {
+	b0, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	b1, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, b1&0x7F)
+	_, err = io.ReadFull(br, payload)
+	return b0 & 0x0F, payload, err
+}
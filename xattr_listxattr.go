@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path (not following
+// symlinks), or nil if it has none or they can't be read. Values that aren't
+// valid UTF-8, such as the binary plists macOS uses for Finder tags, are
+// base64 encoded with a "base64:" prefix.
+func readXattrs(path string) map[string]string {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil
+	}
+
+	attrs := make(map[string]string)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(path, name, value); err != nil {
+			continue
+		}
+		attrs[name] = xattrValue(value[:n])
+	}
+
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+func xattrValue(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(b)
+}
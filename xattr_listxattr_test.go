@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHandleListXattrs(t *testing.T) {
+	tmpDir := t.TempDir()
+	tagged := filepath.Join(tmpDir, "tagged.mkv")
+	os.WriteFile(tagged, []byte("test"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "plain.mkv"), []byte("test"), 0644)
+
+	if err := unix.Setxattr(tagged, "user.comment", []byte("keep"), 0); err != nil {
+		t.Skipf("xattrs not supported: %v", err)
+	}
+	unix.Setxattr(tagged, "user.blob", []byte{0xff, 0x00}, 0)
+
+	config.FriendlyName = "test-host"
+	config.Dirs = []string{tmpDir}
+
+	req := httptest.NewRequest(http.MethodGet, "/list?xattrs=1", nil)
+	w := httptest.NewRecorder()
+	handleList(w, req)
+
+	var resp ListResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	for _, f := range resp.Files {
+		switch f.Name {
+		case "tagged.mkv":
+			if f.Xattrs["user.comment"] != "keep" || f.Xattrs["user.blob"] != "base64:/wA=" {
+				t.Errorf("unexpected xattrs %v", f.Xattrs)
+			}
+		case "plain.mkv":
+			if f.Xattrs != nil {
+				t.Errorf("expected no xattrs on plain.mkv, got %v", f.Xattrs)
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package main
+
+// readXattrs always returns nil: extended attributes aren't supported here.
+func readXattrs(path string) map[string]string {
+	return nil
+}